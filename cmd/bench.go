@@ -0,0 +1,583 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/NikoMalik/potoc/pkg/client"
+)
+
+// benchReadPool bounds the ids reads are picked from.
+const benchReadPool = 100_000
+
+func runBench(args []string) error {
+	fs, r := newRemoteFlags("bench", "[flags]", 0)
+	sizes := &sizeDist{}
+	sizes.Set("4KiB")
+	fs.Var(sizes, "size", "payload sizes: 4KiB, a range 1KiB-64KiB, or weighted 1KiB:90,1MiB:10")
+	concurrency := fs.Int("concurrency", 8, "number of workers, each with its own streams")
+	duration := fs.Duration("duration", 30*time.Second, "how long to run")
+	readRatio := fs.Float64("read", 0, "fraction of operations that are reads, 0 to 1")
+	rate := fs.Float64("rate", 0, "target operations per second over all workers, 0 for as fast as possible")
+	prefill := fs.Int("prefill", 100, "objects written before the run when there are reads")
+	cleanup := fs.Bool("cleanup", true, "delete the written objects afterwards")
+	if args = parse(fs, args); len(args) != 0 || *concurrency < 1 || *duration <= 0 ||
+		*readRatio < 0 || *readRatio > 1 || *rate < 0 {
+		fs.Usage()
+		return _errUsage
+	}
+
+	ctx, c, done, err := r.dial(
+		client.WithMaxConcurrency(2**concurrency),
+		// A retried operation would hide the failure and skew its latency.
+		client.WithRetryPolicy(client.NoRetry),
+	)
+	if err != nil {
+		return r.fail(err)
+	}
+	defer done()
+
+	b := &bench{
+		c:         c,
+		sizes:     sizes,
+		readRatio: *readRatio,
+		payload:   make([]byte, sizes.max()),
+		runID:     strconv.FormatInt(time.Now().UnixNano(), 36),
+	}
+	for i := range b.payload {
+		b.payload[i] = byte(rand.Uint32())
+	}
+
+	if *readRatio > 0 {
+		if err := b.prefill(ctx, *prefill); err != nil {
+			return r.fail(fmt.Errorf("prefill: %w", err))
+		}
+	}
+
+	report, err := b.run(ctx, *concurrency, *duration, *rate)
+	report.Server = r.server
+	report.Config = benchConfig{
+		Sizes:       sizes.String(),
+		Concurrency: *concurrency,
+		Duration:    duration.String(),
+		ReadRatio:   *readRatio,
+		Rate:        *rate,
+	}
+
+	if *cleanup {
+		// The run context may be used up by now.
+		cctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), time.Minute)
+		if err := b.cleanup(cctx, *concurrency); err != nil {
+			fmt.Fprintf(os.Stderr, "cleanup: %v\n", err)
+		}
+		cancel()
+	}
+
+	if err := r.print(report, report.print); err != nil {
+		return err
+	}
+	return r.fail(err)
+}
+
+type bench struct {
+	c         *client.Client
+	sizes     *sizeDist
+	readRatio float64
+	payload   []byte
+	runID     string
+
+	mu  sync.Mutex
+	ids []string // read candidates, at most benchReadPool
+}
+
+func (b *bench) runLabel() map[string]string {
+	return map[string]string{"potoc-bench": b.runID}
+}
+
+func (b *bench) labels() client.CallOption {
+	return client.WithLabels(b.runLabel())
+}
+
+// cleanup deletes the objects written by the run, found by their label so
+// that uploads whose response was cut off by the end of the run go too.
+func (b *bench) cleanup(ctx context.Context, workers int) error {
+	objects, err := b.c.List(ctx, client.WithLabelFilter(b.runLabel()))
+	if err != nil {
+		return err
+	}
+	ids := make([]string, len(objects))
+	for i, o := range objects {
+		ids[i] = o.ID
+	}
+	s := transfer(ctx, ids, workers, func() (transferFunc, func()) {
+		return func(id string) (int64, bool, error) {
+			return 0, false, b.c.Delete(ctx, id)
+		}, func() {}
+	})
+	return s.err()
+}
+
+func (b *bench) addID(rng *rand.Rand, id string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.ids) < benchReadPool {
+		b.ids = append(b.ids, id)
+	} else {
+		b.ids[rng.IntN(len(b.ids))] = id
+	}
+}
+
+func (b *bench) pickID(rng *rand.Rand) (string, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.ids) == 0 {
+		return "", false
+	}
+	return b.ids[rng.IntN(len(b.ids))], true
+}
+
+func (b *bench) prefill(ctx context.Context, n int) error {
+	u, err := b.c.NewUploadStream(ctx, b.labels())
+	if err != nil {
+		return err
+	}
+	defer u.Close()
+
+	rng := rand.New(rand.NewPCG(rand.Uint64(), 0))
+	for range n {
+		id, err := u.Put(bytes.NewReader(b.payload[:b.sizes.pick(rng)]))
+		if err != nil {
+			return err
+		}
+		b.addID(rng, id)
+	}
+	return nil
+}
+
+// run drives the workers for d. With a target rate, operations are
+// scheduled ahead of time and their latency counts from the scheduled
+// start, so that a stalled server shows up as latency rather than as a
+// lower rate.
+//
+// run fails with the first error when no operation succeeded.
+func (b *bench) run(ctx context.Context, workers int, d time.Duration, rate float64) (*benchReport, error) {
+	ctx, cancel := context.WithTimeout(ctx, d)
+	defer cancel()
+
+	var schedule <-chan time.Time
+	if rate > 0 {
+		schedule = pace(ctx, rate, workers)
+	}
+
+	var (
+		wg    sync.WaitGroup
+		stats = make([]*benchWorker, workers)
+		start = time.Now()
+	)
+	for i := range stats {
+		w := &benchWorker{b: b, rng: rand.New(rand.NewPCG(rand.Uint64(), uint64(i)))}
+		stats[i] = w
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.run(ctx, schedule)
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	write, read := &opStats{}, &opStats{}
+	for _, w := range stats {
+		write.merge(&w.write)
+		read.merge(&w.read)
+	}
+	total := &opStats{}
+	total.merge(write)
+	total.merge(read)
+
+	report := &benchReport{
+		Start:    start.UTC(),
+		Elapsed:  elapsed.Seconds(),
+		Total:    total.report(elapsed),
+		Write:    write.report(elapsed),
+		Read:     read.report(elapsed),
+		RunLabel: "potoc-bench=" + b.runID,
+	}
+	if total.latency.n == 0 && total.err != nil {
+		return report, fmt.Errorf("every operation failed: %w", total.err)
+	}
+	return report, nil
+}
+
+// pace sends the scheduled start of each operation. The buffer lets
+// workers run up to one operation per worker ahead.
+func pace(ctx context.Context, rate float64, burst int) <-chan time.Time {
+	ch := make(chan time.Time, burst)
+	interval := time.Duration(float64(time.Second) / rate)
+	go func() {
+		defer close(ch)
+		next := time.Now()
+		for {
+			select {
+			case ch <- next:
+			case <-ctx.Done():
+				return
+			}
+			next = next.Add(interval)
+			if wait := time.Until(next); wait > 0 {
+				t := time.NewTimer(wait)
+				select {
+				case <-t.C:
+				case <-ctx.Done():
+					t.Stop()
+					return
+				}
+			}
+		}
+	}()
+	return ch
+}
+
+type benchWorker struct {
+	b     *bench
+	rng   *rand.Rand
+	write opStats
+	read  opStats
+
+	up   *client.UploadStream
+	down *client.DownloadStream
+}
+
+func (w *benchWorker) run(ctx context.Context, schedule <-chan time.Time) {
+	defer func() {
+		if w.up != nil {
+			w.up.Close()
+		}
+		if w.down != nil {
+			w.down.Close()
+		}
+	}()
+
+	for {
+		var start time.Time
+		if schedule != nil {
+			var ok bool
+			if start, ok = <-schedule; !ok {
+				return
+			}
+		} else {
+			if ctx.Err() != nil {
+				return
+			}
+			start = time.Now()
+		}
+
+		if w.rng.Float64() < w.b.readRatio {
+			if id, ok := w.b.pickID(w.rng); ok {
+				n, err := w.get(ctx, id)
+				w.read.record(ctx, start, n, err)
+				continue
+			}
+		}
+		n, err := w.put(ctx)
+		w.write.record(ctx, start, n, err)
+	}
+}
+
+func (w *benchWorker) put(ctx context.Context) (int64, error) {
+	if w.up == nil {
+		u, err := w.b.c.NewUploadStream(ctx, w.b.labels())
+		if err != nil {
+			return 0, err
+		}
+		w.up = u
+	}
+	data := w.b.payload[:w.b.sizes.pick(w.rng)]
+	id, err := w.up.Put(bytes.NewReader(data))
+	if err != nil {
+		return 0, err
+	}
+	w.b.addID(w.rng, id)
+	return int64(len(data)), nil
+}
+
+func (w *benchWorker) get(ctx context.Context, id string) (int64, error) {
+	if w.down == nil {
+		d, err := w.b.c.NewDownloadStream(ctx)
+		if err != nil {
+			return 0, err
+		}
+		w.down = d
+	}
+	rc, err := w.down.Get(id)
+	if err != nil {
+		return 0, err
+	}
+	defer rc.Close()
+	return io.Copy(io.Discard, rc)
+}
+
+type opStats struct {
+	latency histogram
+	bytes   int64
+	errors  map[string]int64
+	err     error // the first one
+}
+
+func (s *opStats) record(ctx context.Context, start time.Time, n int64, err error) {
+	if err != nil {
+		// Operations cut short by the end of the run are not failures.
+		if ctx.Err() != nil {
+			return
+		}
+		if s.errors == nil {
+			s.errors = map[string]int64{}
+		}
+		s.errors[code(err).String()]++
+		if s.err == nil {
+			s.err = err
+		}
+		return
+	}
+	s.latency.record(time.Since(start))
+	s.bytes += n
+}
+
+func (s *opStats) merge(o *opStats) {
+	s.latency.merge(&o.latency)
+	s.bytes += o.bytes
+	if s.err == nil {
+		s.err = o.err
+	}
+	for c, n := range o.errors {
+		if s.errors == nil {
+			s.errors = map[string]int64{}
+		}
+		s.errors[c] += n
+	}
+}
+
+type benchConfig struct {
+	Sizes       string  `json:"sizes"`
+	Concurrency int     `json:"concurrency"`
+	Duration    string  `json:"duration"`
+	ReadRatio   float64 `json:"read_ratio"`
+	Rate        float64 `json:"rate"`
+}
+
+type benchReport struct {
+	Server   string      `json:"server"`
+	Config   benchConfig `json:"config"`
+	Start    time.Time   `json:"start"`
+	Elapsed  float64     `json:"elapsed_seconds"`
+	RunLabel string      `json:"run_label"`
+	Total    opReport    `json:"total"`
+	Write    opReport    `json:"write"`
+	Read     opReport    `json:"read"`
+}
+
+type opReport struct {
+	Ops         int64             `json:"ops"`
+	Errors      map[string]int64  `json:"errors"`
+	OpsPerSec   float64           `json:"ops_per_sec"`
+	BytesPerSec float64           `json:"bytes_per_sec"`
+	Latency     latencyReport     `json:"latency"`
+	Histogram   []histogramBucket `json:"histogram"`
+}
+
+// latencyReport is in microseconds.
+type latencyReport struct {
+	Min  float64 `json:"min_us"`
+	Mean float64 `json:"mean_us"`
+	P50  float64 `json:"p50_us"`
+	P95  float64 `json:"p95_us"`
+	P99  float64 `json:"p99_us"`
+	P999 float64 `json:"p999_us"`
+	Max  float64 `json:"max_us"`
+}
+
+func micros(d time.Duration) float64 {
+	return float64(d) / float64(time.Microsecond)
+}
+
+func (s *opStats) report(elapsed time.Duration) opReport {
+	h := &s.latency
+	errors := s.errors
+	if errors == nil {
+		errors = map[string]int64{}
+	}
+	return opReport{
+		Ops:         h.n,
+		Errors:      errors,
+		OpsPerSec:   float64(h.n) / elapsed.Seconds(),
+		BytesPerSec: float64(s.bytes) / elapsed.Seconds(),
+		Latency: latencyReport{
+			Min:  micros(h.min),
+			Mean: micros(h.mean()),
+			P50:  micros(h.quantile(0.50)),
+			P95:  micros(h.quantile(0.95)),
+			P99:  micros(h.quantile(0.99)),
+			P999: micros(h.quantile(0.999)),
+			Max:  micros(h.max),
+		},
+		Histogram: h.buckets(),
+	}
+}
+
+func (r *benchReport) print() {
+	fmt.Printf("%s for %.1fs, %s\n\n", r.Server, r.Elapsed, r.RunLabel)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "\tops\tops/s\tMiB/s\terrors\tmin\tmean\tp50\tp95\tp99\tmax\t")
+	for _, op := range []struct {
+		name string
+		r    *opReport
+	}{{"write", &r.Write}, {"read", &r.Read}, {"total", &r.Total}} {
+		var errs int64
+		for _, n := range op.r.Errors {
+			errs += n
+		}
+		l := op.r.Latency
+		fmt.Fprintf(w, "%s\t%d\t%.1f\t%.2f\t%d\t%s\t%s\t%s\t%s\t%s\t%s\t\n",
+			op.name, op.r.Ops, op.r.OpsPerSec, op.r.BytesPerSec/(1<<20), errs,
+			usString(l.Min), usString(l.Mean), usString(l.P50), usString(l.P95), usString(l.P99), usString(l.Max))
+	}
+	w.Flush()
+
+	for _, op := range []struct {
+		name string
+		r    *opReport
+	}{{"write", &r.Write}, {"read", &r.Read}} {
+		if op.r.Ops == 0 {
+			continue
+		}
+		fmt.Printf("\n%s latency\n", op.name)
+		printHistogram(op.r.Histogram, op.r.Ops)
+	}
+	for _, op := range []*opReport{&r.Write, &r.Read} {
+		for c, n := range op.Errors {
+			fmt.Fprintf(os.Stderr, "%d errors: %s\n", n, c)
+		}
+	}
+}
+
+func usString(us float64) string {
+	return time.Duration(us * float64(time.Microsecond)).Round(time.Microsecond).String()
+}
+
+func printHistogram(buckets []histogramBucket, total int64) {
+	const width = 40
+	for _, b := range buckets {
+		bar := int(float64(width) * float64(b.Count) / float64(total))
+		if bar == 0 && b.Count > 0 {
+			bar = 1
+		}
+		fmt.Printf("  <= %9s  %-*s %d\n", usString(float64(b.LeUS)), width, strings.Repeat("#", bar), b.Count)
+	}
+}
+
+// sizeDist is a flag describing the payload sizes: a fixed size, a range
+// picked uniformly, or several of those with integer weights.
+type sizeDist struct {
+	spec    string
+	choices []sizeChoice
+	total   int
+}
+
+type sizeChoice struct {
+	min, max int
+	weight   int
+}
+
+func (s *sizeDist) String() string { return s.spec }
+
+func (s *sizeDist) Set(spec string) error {
+	var (
+		choices []sizeChoice
+		total   int
+	)
+	for _, part := range strings.Split(spec, ",") {
+		sizes, weight, hasWeight := strings.Cut(strings.TrimSpace(part), ":")
+		c := sizeChoice{weight: 1}
+		if hasWeight {
+			w, err := strconv.Atoi(weight)
+			if err != nil || w <= 0 {
+				return fmt.Errorf("weight %q is not a positive integer", weight)
+			}
+			c.weight = w
+		}
+
+		lo, hi, isRange := strings.Cut(sizes, "-")
+		var err error
+		if c.min, err = parseSize(lo); err != nil {
+			return err
+		}
+		c.max = c.min
+		if isRange {
+			if c.max, err = parseSize(hi); err != nil {
+				return err
+			}
+			if c.max < c.min {
+				return fmt.Errorf("size range %q is reversed", sizes)
+			}
+		}
+		choices = append(choices, c)
+		total += c.weight
+	}
+	s.spec, s.choices, s.total = spec, choices, total
+	return nil
+}
+
+func (s *sizeDist) pick(rng *rand.Rand) int {
+	n := rng.IntN(s.total)
+	for _, c := range s.choices {
+		if n < c.weight {
+			return c.min + rng.IntN(c.max-c.min+1)
+		}
+		n -= c.weight
+	}
+	panic("unreachable")
+}
+
+func (s *sizeDist) max() int {
+	m := 0
+	for _, c := range s.choices {
+		m = max(m, c.max)
+	}
+	return m
+}
+
+var sizeUnits = []struct {
+	suffix string
+	n      int
+}{
+	{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30},
+	{"KB", 1e3}, {"MB", 1e6}, {"GB", 1e9},
+	{"B", 1},
+}
+
+// parseSize parses a byte count such as 512, 4KiB or 1MB.
+func parseSize(s string) (int, error) {
+	mult := 1
+	num := s
+	for _, u := range sizeUnits {
+		if strings.HasSuffix(s, u.suffix) {
+			num, mult = strings.TrimSuffix(s, u.suffix), u.n
+			break
+		}
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(num))
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("size %q is not a byte count like 512, 4KiB or 1MB", s)
+	}
+	return n * mult, nil
+}
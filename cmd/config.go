@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/NikoMalik/potoc/internal/app"
+	"github.com/NikoMalik/potoc/internal/config"
+	"gopkg.in/yaml.v3"
+)
+
+const configUsage = `usage: potoc config <command>
+
+commands:
+  print     print the resolved configuration, secrets masked
+  validate  load the configuration and report problems
+`
+
+func runConfig(args []string) error {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, configUsage)
+		return _errUsage
+	}
+
+	switch args[0] {
+	case "print":
+		if _, err := app.ReadConfig(); err != nil {
+			return err
+		}
+		enc := yaml.NewEncoder(os.Stdout)
+		enc.SetIndent(2)
+		if err := enc.Encode(config.Settings()); err != nil {
+			return err
+		}
+		return enc.Close()
+	case "validate":
+		cfg, err := app.ReadConfig()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("config %q is valid\n", cfg.Env)
+		return nil
+	default:
+		fmt.Fprint(os.Stderr, configUsage)
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+}
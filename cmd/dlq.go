@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func runDLQ(args []string) error {
+	fs, r := newRemoteFlags("dlq", "[flags] [-retry <id>...]", defaultTimeout)
+	endpoint := fs.String("endpoint", "", "only dead letters of this webhook endpoint")
+	retry := fs.Bool("retry", false, "queue the dead letters with the given ids for delivery again")
+	args = parse(fs, args)
+	if *retry == (len(args) == 0) || (*retry && *endpoint != "") {
+		fs.Usage()
+		return _errUsage
+	}
+
+	ids := make([]int64, len(args))
+	for i, a := range args {
+		id, err := strconv.ParseInt(a, 10, 64)
+		if err != nil {
+			return r.fail(fmt.Errorf("dead letter id %q is not a number", a))
+		}
+		ids[i] = id
+	}
+
+	ctx, c, done, err := r.dial()
+	if err != nil {
+		return r.fail(err)
+	}
+	defer done()
+
+	if *retry {
+		n, err := c.RetryDeadLetters(ctx, ids)
+		if err != nil {
+			return r.fail(err)
+		}
+		return r.print(struct {
+			Retried int `json:"retried"`
+		}{n}, func() {
+			fmt.Printf("%d of %d dead letters queued again\n", n, len(ids))
+		})
+	}
+
+	letters, err := c.DeadLetters(ctx, *endpoint)
+	if err != nil {
+		return r.fail(err)
+	}
+	return r.print(letters, func() {
+		for _, l := range letters {
+			fmt.Printf("%d\t%s\t%s\t%s\t%s\t%d\t%s\n", l.ID, l.Endpoint, l.DeadAt.Format(time.RFC3339), l.Event.Kind,
+				l.Event.Object.ID, l.Attempts, strings.ReplaceAll(l.LastError, "\n", " "))
+		}
+	})
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"math"
+	"math/bits"
+	"time"
+)
+
+// histogramGrowth is the ratio between the bounds of neighbouring fine
+// buckets, so percentiles are off by at most 1%.
+const histogramGrowth = 1.01
+
+// histogram counts latencies without keeping every sample. Fine buckets
+// give the percentiles, coarse power of two buckets the printed shape.
+type histogram struct {
+	fine   []int64
+	coarse [64]int64
+	n      int64
+	sum    time.Duration
+	min    time.Duration
+	max    time.Duration
+}
+
+func fineBucket(d time.Duration) int {
+	if d < time.Microsecond {
+		return 0
+	}
+	return 1 + int(math.Log(float64(d)/float64(time.Microsecond))/math.Log(histogramGrowth))
+}
+
+// fineBound is the upper bound of fine bucket i.
+func fineBound(i int) time.Duration {
+	return time.Duration(float64(time.Microsecond) * math.Pow(histogramGrowth, float64(i)))
+}
+
+func (h *histogram) record(d time.Duration) {
+	i := fineBucket(d)
+	if i >= len(h.fine) {
+		h.fine = append(h.fine, make([]int64, i+1-len(h.fine))...)
+	}
+	h.fine[i]++
+	h.coarse[bits.Len64(uint64(d/time.Microsecond))]++
+
+	if h.n == 0 || d < h.min {
+		h.min = d
+	}
+	h.max = max(h.max, d)
+	h.n++
+	h.sum += d
+}
+
+func (h *histogram) merge(o *histogram) {
+	if o.n == 0 {
+		return
+	}
+	if len(o.fine) > len(h.fine) {
+		h.fine = append(h.fine, make([]int64, len(o.fine)-len(h.fine))...)
+	}
+	for i, c := range o.fine {
+		h.fine[i] += c
+	}
+	for i, c := range o.coarse {
+		h.coarse[i] += c
+	}
+	if h.n == 0 || o.min < h.min {
+		h.min = o.min
+	}
+	h.max = max(h.max, o.max)
+	h.n += o.n
+	h.sum += o.sum
+}
+
+// quantile returns the latency below which q of the samples fall.
+func (h *histogram) quantile(q float64) time.Duration {
+	if h.n == 0 {
+		return 0
+	}
+	rank := int64(math.Ceil(q * float64(h.n)))
+	var seen int64
+	for i, c := range h.fine {
+		if seen += c; seen >= rank {
+			return min(fineBound(i), h.max)
+		}
+	}
+	return h.max
+}
+
+func (h *histogram) mean() time.Duration {
+	if h.n == 0 {
+		return 0
+	}
+	return h.sum / time.Duration(h.n)
+}
+
+type histogramBucket struct {
+	// LeUS is the bucket's upper bound in microseconds.
+	LeUS  int64 `json:"le_us"`
+	Count int64 `json:"count"`
+}
+
+// buckets returns the coarse buckets from the first to the last non-empty
+// one.
+func (h *histogram) buckets() []histogramBucket {
+	first, last := -1, -1
+	for i, c := range h.coarse {
+		if c > 0 {
+			if first < 0 {
+				first = i
+			}
+			last = i
+		}
+	}
+	if first < 0 {
+		return []histogramBucket{}
+	}
+	out := make([]histogramBucket, 0, last-first+1)
+	for i := first; i <= last; i++ {
+		out = append(out, histogramBucket{LeUS: 1<<i - 1, Count: h.coarse[i]})
+	}
+	return out
+}
@@ -1,54 +1,76 @@
 package main
 
 import (
-	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
-	"os/signal"
-	"syscall"
-	"time"
-
-	"github.com/NikoMalik/potoc/internal/app"
-	"github.com/NikoMalik/potoc/internal/logger"
-
-	"go.uber.org/zap"
 )
 
-func main() {
-	ctx := context.Background()
-	app, err := app.NewApp(ctx)
-	if err != nil {
-		logger.Fatal("failed to initialize app", zap.Error(err))
-	}
+type command struct {
+	name  string
+	usage string
+	run   func(args []string) error
+}
 
-	go func() {
-		logger.Info("Starting App...",
-			zap.String("log_level", app.Config.LogLevel),
-			zap.String("host", app.Config.Server.Host),
-			zap.String("port", app.Config.Server.Port),
-		)
-		if err := app.Run(); err != nil {
-			logger.Fatal("app failed", zap.Error(err))
-		}
-	}()
+var commands = []command{
+	{"serve", "start the gRPC server (default)", runServe},
+	{"migrate", "apply or inspect database migrations", runMigrate},
+	{"seed", "fill random_data with generated rows", runSeed},
+	{"config", "print or validate the resolved configuration", runConfig},
+	{"version", "print version and build information", runVersion},
+	{"put", "store a file or stdin as an object", runPut},
+	{"get", "fetch an object", runGet},
+	{"rm", "delete objects", runRm},
+	{"ls", "list objects, optionally by label", runLs},
+	{"stat", "describe an object", runStat},
+	{"sync", "upload a directory, skipping unchanged files", runSync},
+	{"restore", "download a synced directory", runRestore},
+	{"consume", "take objects from a queue and ack them", runConsume},
+	{"watch", "print object events as they happen", runWatch},
+	{"dlq", "list or retry webhook deliveries that ran out of attempts", runDLQ},
+	{"bench", "load the server and report throughput and latency", runBench},
+}
 
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, syscall.SIGTERM, syscall.SIGINT)
-	timeout := make(chan struct{})
+var _errUsage = errors.New("usage")
 
-	go func() {
-		<-ctx.Done()
-		close(timeout)
-	}()
+func main() {
+	name, args := "serve", os.Args[1:]
+	if len(args) > 0 {
+		name, args = args[0], args[1:]
+	}
 
-	<-quit
-	logger.Info("Shutdown Server...")
-	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+	if name == "help" || name == "-h" || name == "--help" {
+		usage()
+		return
+	}
 
-	if err := app.Close(shutdownCtx); err != nil {
-		logger.Fatal("Server Shutdown", zap.Error(err))
+	for _, cmd := range commands {
+		if cmd.name != name {
+			continue
+		}
+		if err := cmd.run(args); err != nil {
+			var jerr *jsonError
+			switch {
+			case errors.Is(err, _errUsage):
+			case errors.As(err, &jerr):
+				json.NewEncoder(os.Stderr).Encode(jerr)
+			default:
+				fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+			}
+			os.Exit(exitCode(err))
+		}
+		return
 	}
 
-	logger.Info("Server exiting")
+	fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+	usage()
+	os.Exit(exitUsage)
+}
 
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: potoc <command> [flags]\n\ncommands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-8s %s\n", cmd.name, cmd.usage)
+	}
 }
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"strconv"
+
+	"github.com/NikoMalik/potoc/internal/app"
+	"github.com/NikoMalik/potoc/internal/database"
+	"github.com/golang-migrate/migrate/v4"
+)
+
+const migrateUsage = `usage: potoc migrate [-dir path] <command>
+
+commands:
+  up           apply all pending migrations
+  down [N|all] roll back N migrations (default 1) or all of them
+  version      print the current version and dirty flag
+  force V      set the version to V without running migrations
+
+flags:
+`
+
+func runMigrate(args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	dir := fs.String("dir", "", "read migrations from this directory instead of the embedded ones")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), migrateUsage)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return _errUsage
+	}
+
+	config, err := app.LoadConfig()
+	if err != nil {
+		return err
+	}
+	if *dir == "" {
+		*dir = config.DB.MigrationsDir
+	}
+
+	db, err := database.NewDB(context.Background(), config.DB)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	m, err := database.NewMigrator(db, *dir)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	switch cmd := fs.Arg(0); cmd {
+	case "up":
+		err = m.Up()
+	case "down":
+		err = migrateDown(m, fs.Arg(1))
+	case "version":
+		return printVersion(m)
+	case "force":
+		v, convErr := strconv.Atoi(fs.Arg(1))
+		if convErr != nil {
+			return fmt.Errorf("force needs a version number: %w", convErr)
+		}
+		err = m.Force(v)
+	default:
+		fs.Usage()
+		return fmt.Errorf("unknown command %q", cmd)
+	}
+
+	if errors.Is(err, migrate.ErrNoChange) {
+		fmt.Println("no change")
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+	return printVersion(m)
+}
+
+func migrateDown(m *database.Migrator, arg string) error {
+	switch arg {
+	case "all":
+		return m.Down()
+	case "":
+		return m.Steps(-1)
+	}
+
+	n, err := strconv.Atoi(arg)
+	if err != nil || n <= 0 {
+		return fmt.Errorf("down needs a positive number of steps or \"all\", got %q", arg)
+	}
+	return m.Steps(-n)
+}
+
+func printVersion(m *database.Migrator) error {
+	version, dirty, err := m.Version()
+	if errors.Is(err, migrate.ErrNilVersion) {
+		fmt.Println("no migrations applied")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("version=%d dirty=%t\n", version, dirty)
+	return nil
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/NikoMalik/potoc/pkg/client"
+)
+
+func runPut(args []string) error {
+	fs, r := newRemoteFlags("put", "[flags] <file|->", defaultTimeout)
+	l := labels{}
+	fs.Var(l, "label", "attach label key=value, repeatable")
+	queue := fs.String("queue", "", "put the object in this queue for consume")
+	args = parse(fs, args)
+	if len(args) != 1 {
+		fs.Usage()
+		return _errUsage
+	}
+
+	in := os.Stdin
+	if args[0] != "-" {
+		f, err := os.Open(args[0])
+		if err != nil {
+			return r.fail(err)
+		}
+		defer f.Close()
+		in = f
+	}
+	data, err := io.ReadAll(in)
+	if err != nil {
+		return r.fail(err)
+	}
+
+	ctx, c, done, err := r.dial()
+	if err != nil {
+		return r.fail(err)
+	}
+	defer done()
+
+	id, err := c.Put(ctx, bytes.NewReader(data), client.WithLabels(l), client.WithQueue(*queue))
+	if err != nil {
+		return r.fail(err)
+	}
+	return r.print(struct {
+		ID     string            `json:"id"`
+		Size   int               `json:"size"`
+		Labels map[string]string `json:"labels,omitempty"`
+		Queue  string            `json:"queue,omitempty"`
+	}{id, len(data), l, *queue}, func() { fmt.Println(id) })
+}
+
+func runGet(args []string) error {
+	fs, r := newRemoteFlags("get", "[flags] <id>", defaultTimeout)
+	out := fs.String("o", "", "write the object to this file instead of stdout")
+	args = parse(fs, args)
+	if len(args) != 1 {
+		fs.Usage()
+		return _errUsage
+	}
+	id := args[0]
+
+	ctx, c, done, err := r.dial()
+	if err != nil {
+		return r.fail(err)
+	}
+	defer done()
+
+	rc, err := c.Get(ctx, id)
+	if err != nil {
+		return r.fail(err)
+	}
+	defer rc.Close()
+
+	if *out == "" {
+		_, err = io.Copy(os.Stdout, rc)
+		return r.fail(err)
+	}
+
+	// Write next to the target and rename so that a failed get never
+	// leaves a truncated file behind.
+	tmp := *out + ".part"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return r.fail(err)
+	}
+	n, err := io.Copy(f, rc)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, *out)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return r.fail(err)
+	}
+
+	return r.print(struct {
+		ID   string `json:"id"`
+		Size int64  `json:"size"`
+		Path string `json:"path"`
+	}{id, n, *out}, func() {})
+}
+
+func runRm(args []string) error {
+	fs, r := newRemoteFlags("rm", "[flags] <id>...", defaultTimeout)
+	ids := parse(fs, args)
+	if len(ids) == 0 {
+		fs.Usage()
+		return _errUsage
+	}
+
+	ctx, c, done, err := r.dial()
+	if err != nil {
+		return r.fail(err)
+	}
+	defer done()
+
+	deleted := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if err := c.Delete(ctx, id); err != nil {
+			return r.fail(err)
+		}
+		deleted = append(deleted, id)
+	}
+	return r.print(struct {
+		Deleted []string `json:"deleted"`
+	}{deleted}, func() {})
+}
+
+func runLs(args []string) error {
+	fs, r := newRemoteFlags("ls", "[flags]", defaultTimeout)
+	l := labels{}
+	fs.Var(l, "label", "only list objects with label key=value, repeatable")
+	if args = parse(fs, args); len(args) != 0 {
+		fs.Usage()
+		return _errUsage
+	}
+
+	ctx, c, done, err := r.dial()
+	if err != nil {
+		return r.fail(err)
+	}
+	defer done()
+
+	objects, err := c.List(ctx, client.WithLabelFilter(l))
+	if err != nil {
+		return r.fail(err)
+	}
+	if objects == nil {
+		objects = []client.Object{}
+	}
+	return r.print(objects, func() {
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tSIZE\tCREATED\tLABELS")
+		for _, o := range objects {
+			fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", o.ID, o.Size, o.CreatedAt.Format(time.RFC3339), labels(o.Labels))
+		}
+		w.Flush()
+	})
+}
+
+func runStat(args []string) error {
+	fs, r := newRemoteFlags("stat", "[flags] <id>", defaultTimeout)
+	args = parse(fs, args)
+	if len(args) != 1 {
+		fs.Usage()
+		return _errUsage
+	}
+
+	ctx, c, done, err := r.dial()
+	if err != nil {
+		return r.fail(err)
+	}
+	defer done()
+
+	o, err := c.Stat(ctx, args[0])
+	if err != nil {
+		return r.fail(err)
+	}
+	return r.print(o, func() {
+		fmt.Printf("id:      %s\n", o.ID)
+		fmt.Printf("size:    %d\n", o.Size)
+		fmt.Printf("created: %s\n", o.CreatedAt.Format(time.RFC3339Nano))
+		fmt.Printf("labels:  %s\n", labels(o.Labels))
+	})
+}
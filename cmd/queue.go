@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/NikoMalik/potoc/pkg/client"
+)
+
+func runConsume(args []string) error {
+	fs, r := newRemoteFlags("consume", "[flags] <queue>", 0)
+	out := fs.String("o", "", "write each object to a file named by its id in this directory instead of stdout")
+	count := fs.Int("n", 1, "exit after this many objects, 0 to consume until interrupted")
+	nack := fs.Bool("nack", false, "give each object back to the queue instead of acking it")
+	visibility := fs.Duration("visibility", 0, "lease each object for this long, 0 for the server default")
+	prefetch := fs.Int("prefetch", 0, "objects leased ahead of handling, 0 for the server default")
+	args = parse(fs, args)
+	if len(args) != 1 {
+		fs.Usage()
+		return _errUsage
+	}
+
+	ctx, c, done, err := r.dial()
+	if err != nil {
+		return r.fail(err)
+	}
+	defer done()
+
+	prefetchN := *prefetch
+	if prefetchN == 0 && *count > 0 {
+		// Leasing more than will be handled only delays other consumers.
+		prefetchN = *count
+	}
+	cons, err := c.Consume(ctx, args[0], client.WithVisibilityTimeout(*visibility), client.WithPrefetch(prefetchN))
+	if err != nil {
+		return r.fail(err)
+	}
+	defer cons.Close()
+
+	enc := json.NewEncoder(os.Stdout)
+	for n := 0; *count == 0 || n < *count; n++ {
+		d, err := cons.Next()
+		if err != nil {
+			if ctx.Err() != nil && *count == 0 {
+				return nil
+			}
+			return r.fail(err)
+		}
+
+		// With -json and no directory the data goes in the JSON instead.
+		var path string
+		if !r.json || *out != "" {
+			path, err = deliver(d, *out)
+		}
+		if err != nil {
+			d.Nack(context.Background(), 0)
+			return r.fail(err)
+		}
+		if *nack {
+			err = d.Nack(ctx, 0)
+		} else {
+			err = d.Ack(ctx)
+		}
+		if err != nil {
+			return r.fail(err)
+		}
+
+		switch {
+		case r.json:
+			err = enc.Encode(struct {
+				ID      string            `json:"id"`
+				Size    int               `json:"size"`
+				Labels  map[string]string `json:"labels,omitempty"`
+				Attempt int               `json:"attempt"`
+				Path    string            `json:"path,omitempty"`
+				Data    []byte            `json:"data,omitempty"`
+				Acked   bool              `json:"acked"`
+			}{d.Object.ID, len(d.Data), d.Object.Labels, d.Attempt, path, data(d, path), !*nack})
+		case path != "":
+			fmt.Printf("%s\t%s\n", d.Object.ID, path)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// data is the object's data for the JSON output, unless it was written to
+// path.
+func data(d *client.Delivery, path string) []byte {
+	if path != "" {
+		return nil
+	}
+	return d.Data
+}
+
+// deliver writes the object to a file in dir, or to stdout without one,
+// and returns the file's path.
+func deliver(d *client.Delivery, dir string) (string, error) {
+	if dir == "" {
+		_, err := os.Stdout.Write(d.Data)
+		return "", err
+	}
+	path := filepath.Join(dir, d.Object.ID)
+	return path, writeFile(path, d.Data, 0o644)
+}
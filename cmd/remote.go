@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/NikoMalik/potoc/pkg/client"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Exit codes of the commands talking to a server. Scripts may rely on them.
+const (
+	exitOK          = 0
+	exitError       = 1
+	exitUsage       = 2
+	exitNotFound    = 3
+	exitUnavailable = 4
+	exitInvalid     = 5
+)
+
+const (
+	defaultServer  = "localhost:50051"
+	defaultTimeout = 30 * time.Second
+)
+
+// remote holds the flags shared by the commands talking to a server.
+type remote struct {
+	server  string
+	json    bool
+	timeout time.Duration
+}
+
+func newRemoteFlags(name, usage string, timeout time.Duration) (*flag.FlagSet, *remote) {
+	r := &remote{}
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	server := os.Getenv("POTOC_SERVER")
+	if server == "" {
+		server = defaultServer
+	}
+	fs.StringVar(&r.server, "server", server, "server address, $POTOC_SERVER if set")
+	fs.BoolVar(&r.json, "json", false, "print results and errors as JSON")
+	fs.DurationVar(&r.timeout, "timeout", timeout, "give up after this long, 0 to wait forever")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: potoc %s %s\n\nflags:\n", name, usage)
+		fs.PrintDefaults()
+	}
+	return fs, r
+}
+
+// parse parses flags given before, between and after the positional
+// arguments and returns the positional ones.
+func parse(fs *flag.FlagSet, args []string) []string {
+	var positional []string
+	for {
+		fs.Parse(args)
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// dial connects to the server and returns a context bounded by -timeout
+// and cancelled on interrupt.
+func (r *remote) dial(opts ...client.Option) (context.Context, *client.Client, func(), error) {
+	c, err := client.New(r.server, opts...)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	cancel := context.CancelFunc(func() {})
+	if r.timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, r.timeout)
+	}
+	return ctx, c, func() {
+		cancel()
+		stop()
+		c.Close()
+	}, nil
+}
+
+// print writes v as JSON in -json mode and text otherwise.
+func (r *remote) print(v any, text func()) error {
+	if !r.json {
+		text()
+		return nil
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// fail marks err to be reported as JSON in -json mode.
+func (r *remote) fail(err error) error {
+	if err == nil || !r.json {
+		return err
+	}
+	return &jsonError{err: err}
+}
+
+type jsonError struct {
+	err error
+}
+
+func (e *jsonError) Error() string { return e.err.Error() }
+func (e *jsonError) Unwrap() error { return e.err }
+
+func (e *jsonError) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Error string `json:"error"`
+		Code  string `json:"code"`
+		Exit  int    `json:"exit_code"`
+	}{e.err.Error(), code(e.err).String(), exitCode(e.err)})
+}
+
+// code returns the gRPC status code behind err.
+func code(err error) codes.Code {
+	switch {
+	case errors.Is(err, client.ErrNotFound):
+		return codes.NotFound
+	case errors.Is(err, context.DeadlineExceeded):
+		return codes.DeadlineExceeded
+	case errors.Is(err, context.Canceled):
+		return codes.Canceled
+	}
+	return status.Code(err)
+}
+
+// exitCode maps err to one of the exit codes above.
+func exitCode(err error) int {
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.Is(err, _errUsage):
+		return exitUsage
+	}
+	switch code(err) {
+	case codes.NotFound:
+		return exitNotFound
+	case codes.Unavailable, codes.DeadlineExceeded:
+		return exitUnavailable
+	case codes.InvalidArgument:
+		return exitInvalid
+	}
+	return exitError
+}
+
+// labels is a repeatable k=v flag.
+type labels map[string]string
+
+func (l labels) String() string {
+	pairs := make([]string, 0, len(l))
+	for k, v := range l {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (l labels) Set(s string) error {
+	k, v, ok := strings.Cut(s, "=")
+	if !ok || k == "" {
+		return fmt.Errorf("label %q is not key=value", s)
+	}
+	l[k] = v
+	return nil
+}
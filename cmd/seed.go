@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/NikoMalik/potoc/internal/app"
+	"github.com/NikoMalik/potoc/internal/database"
+	"github.com/NikoMalik/potoc/internal/repository"
+)
+
+func runSeed(args []string) error {
+	fs := flag.NewFlagSet("seed", flag.ExitOnError)
+	fs.Parse(args)
+
+	config, err := app.LoadConfig()
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	db, err := database.NewDB(ctx, config.DB)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return repository.NewRepositories(db).RandomRepo.GenerateRandomData(ctx)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/NikoMalik/potoc/internal/app"
+	"github.com/NikoMalik/potoc/internal/config"
+	"github.com/NikoMalik/potoc/internal/logger"
+
+	"go.uber.org/zap"
+)
+
+// shutdownGrace bounds stopping everything but the server, on top of the
+// time the server may spend draining.
+const shutdownGrace = 10 * time.Second
+
+func runServe(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	fs.Parse(args)
+
+	ctx := context.Background()
+	app, err := app.NewApp(ctx)
+	if err != nil {
+		return err
+	}
+
+	config.LogServerOptions(app.Config.Server)
+
+	runErr := make(chan error, 1)
+	go func() {
+		logger.Info("Starting App...",
+			zap.String("log_level", app.Config.LogLevel),
+			zap.String("host", app.Config.Server.Host),
+			zap.String("port", app.Config.Server.Port),
+		)
+		runErr <- app.Run()
+	}()
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGTERM, syscall.SIGINT)
+
+	// A server that fails to start or serve is shut down like on a signal.
+	select {
+	case <-quit:
+		logger.Info("Shutdown Server...")
+	case err = <-runErr:
+		if err != nil {
+			logger.Error("app failed", zap.Error(err))
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), app.Config.Server.DrainTimeout+shutdownGrace)
+	defer cancel()
+	if cerr := app.Close(ctx); cerr != nil {
+		logger.Error("Server Shutdown", zap.Error(cerr))
+		return errors.Join(err, cerr)
+	}
+
+	logger.Info("Server exiting")
+	return errors.Join(err, config.CloseLogs())
+}
@@ -0,0 +1,364 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/NikoMalik/potoc/pkg/client"
+)
+
+const defaultWorkers = 4
+
+// transferSummary is what sync and restore report.
+type transferSummary struct {
+	Transferred int       `json:"transferred"`
+	Skipped     int       `json:"skipped"`
+	Bytes       int64     `json:"bytes"`
+	Pruned      int       `json:"pruned,omitempty"`
+	Failed      []failure `json:"failed"`
+
+	mu sync.Mutex
+}
+
+type failure struct {
+	Path  string `json:"path"`
+	Error string `json:"error"`
+
+	err error
+}
+
+// err returns the first failure, wrapped so that it decides the exit code.
+func (s *transferSummary) err() error {
+	if len(s.Failed) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%d failed, first %s: %w", len(s.Failed), s.Failed[0].Path, s.Failed[0].err)
+}
+
+func (s *transferSummary) print(verb string) {
+	for _, f := range s.Failed {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", f.Path, f.Error)
+	}
+	fmt.Printf("%s %d files (%d bytes), skipped %d unchanged", verb, s.Transferred, s.Bytes, s.Skipped)
+	if s.Pruned > 0 {
+		fmt.Printf(", pruned %d objects", s.Pruned)
+	}
+	fmt.Printf(", %d failed\n", len(s.Failed))
+}
+
+// transferFunc moves one file and reports how many bytes it moved, or
+// that the file was already up to date.
+type transferFunc func(path string) (n int64, skipped bool, err error)
+
+// transfer runs the transferFunc made by open for every path on up to
+// workers goroutines, each with its own, and stops early when ctx is done.
+// open is called lazily by each worker on its first path.
+func transfer(ctx context.Context, paths []string, workers int, open func() (transferFunc, func())) *transferSummary {
+	var (
+		s    = &transferSummary{Failed: []failure{}}
+		wg   sync.WaitGroup
+		jobs = make(chan string)
+	)
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var do transferFunc
+			for path := range jobs {
+				if do == nil {
+					var closeWorker func()
+					do, closeWorker = open()
+					defer closeWorker()
+				}
+
+				n, skipped, err := do(path)
+				s.mu.Lock()
+				switch {
+				case err != nil:
+					s.Failed = append(s.Failed, failure{Path: path, Error: err.Error(), err: err})
+				case skipped:
+					s.Skipped++
+				default:
+					s.Transferred++
+					s.Bytes += n
+				}
+				s.mu.Unlock()
+			}
+		}()
+	}
+
+feed:
+	for _, path := range paths {
+		select {
+		case jobs <- path:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	sort.Slice(s.Failed, func(i, j int) bool { return s.Failed[i].Path < s.Failed[j].Path })
+	return s
+}
+
+// walk returns the regular files under dir, relative and slash separated,
+// leaving out skip.
+func walk(dir, skip string) (map[string]fs.FileMode, error) {
+	skip, err := filepath.Abs(skip)
+	if err != nil {
+		return nil, err
+	}
+
+	files := map[string]fs.FileMode{}
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		if abs, err := filepath.Abs(path); err != nil || abs == skip || abs == skip+".tmp" {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)] = info.Mode()
+		return nil
+	})
+	return files, err
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func runSync(args []string) error {
+	fs, r := newRemoteFlags("sync", "[flags] <dir>", 0)
+	manifestPath := fs.String("manifest", "", "manifest file, <dir>/"+manifestName+" by default")
+	workers := fs.Int("workers", defaultWorkers, "number of parallel upload streams")
+	prune := fs.Bool("prune", false, "delete the objects of files that were removed or changed")
+	verbose := fs.Bool("v", false, "print every uploaded file")
+	l := labels{}
+	fs.Var(l, "label", "attach label key=value to every uploaded object, repeatable")
+	args = parse(fs, args)
+	if len(args) != 1 || *workers < 1 {
+		fs.Usage()
+		return _errUsage
+	}
+	dir := args[0]
+	if *manifestPath == "" {
+		*manifestPath = filepath.Join(dir, manifestName)
+	}
+
+	prev, err := readManifest(*manifestPath)
+	if err != nil {
+		return r.fail(err)
+	}
+	files, err := walk(dir, *manifestPath)
+	if err != nil {
+		return r.fail(err)
+	}
+
+	ctx, c, done, err := r.dial(client.WithMaxConcurrency(*workers))
+	if err != nil {
+		return r.fail(err)
+	}
+	defer done()
+
+	// Files that fail or are never reached keep their previous entry, so
+	// the next run tries them again.
+	next := &manifest{Version: manifestVersion, Server: r.server, Files: map[string]manifestEntry{}}
+	for path := range files {
+		if e, ok := prev.Files[path]; ok {
+			next.Files[path] = e
+		}
+	}
+	var mu sync.Mutex
+
+	s := transfer(ctx, sortedKeys(files), *workers, func() (transferFunc, func()) {
+		var u *client.UploadStream
+		do := func(path string) (int64, bool, error) {
+			abs := filepath.Join(dir, filepath.FromSlash(path))
+			sum, err := fileChecksum(abs)
+			if err != nil {
+				return 0, false, err
+			}
+			if e, ok := prev.Files[path]; ok && e.SHA256 == sum {
+				return 0, true, nil
+			}
+
+			data, sum, err := readFile(abs)
+			if err != nil {
+				return 0, false, err
+			}
+			if u == nil {
+				if u, err = c.NewUploadStream(ctx, client.WithLabels(l)); err != nil {
+					return 0, false, err
+				}
+			}
+			id, err := u.Put(bytes.NewReader(data))
+			if err != nil {
+				return 0, false, err
+			}
+			if *verbose && !r.json {
+				fmt.Printf("%s %s\n", id, path)
+			}
+
+			mu.Lock()
+			next.Files[path] = manifestEntry{ID: id, Size: int64(len(data)), SHA256: sum, Mode: files[path]}
+			mu.Unlock()
+			return int64(len(data)), false, nil
+		}
+		return do, func() {
+			if u != nil {
+				u.Close()
+			}
+		}
+	})
+
+	if err := next.write(*manifestPath); err != nil {
+		return r.fail(err)
+	}
+	if ctx.Err() != nil {
+		return r.fail(ctx.Err())
+	}
+
+	if *prune {
+		s.Pruned, err = pruneObjects(ctx, c, prev, next)
+		if err != nil {
+			return r.fail(err)
+		}
+	}
+
+	if err := r.print(s, func() { s.print("uploaded") }); err != nil {
+		return err
+	}
+	return r.fail(s.err())
+}
+
+// pruneObjects deletes the objects prev refers to and next no longer does.
+func pruneObjects(ctx context.Context, c *client.Client, prev, next *manifest) (int, error) {
+	kept := make(map[string]bool, len(next.Files))
+	for _, e := range next.Files {
+		kept[e.ID] = true
+	}
+
+	n := 0
+	for _, path := range sortedKeys(prev.Files) {
+		id := prev.Files[path].ID
+		if kept[id] {
+			continue
+		}
+		err := c.Delete(ctx, id)
+		if err != nil && !errors.Is(err, client.ErrNotFound) {
+			return n, fmt.Errorf("prune %s: %w", path, err)
+		}
+		kept[id] = true
+		n++
+	}
+	return n, nil
+}
+
+func runRestore(args []string) error {
+	fs, r := newRemoteFlags("restore", "[flags] <dir>", 0)
+	manifestPath := fs.String("manifest", "", "manifest file, <dir>/"+manifestName+" by default")
+	workers := fs.Int("workers", defaultWorkers, "number of parallel download streams")
+	verbose := fs.Bool("v", false, "print every restored file")
+	args = parse(fs, args)
+	if len(args) != 1 || *workers < 1 {
+		fs.Usage()
+		return _errUsage
+	}
+	dir := args[0]
+	if *manifestPath == "" {
+		*manifestPath = filepath.Join(dir, manifestName)
+	}
+
+	if _, err := os.Stat(*manifestPath); err != nil {
+		return r.fail(err)
+	}
+	m, err := readManifest(*manifestPath)
+	if err != nil {
+		return r.fail(err)
+	}
+
+	ctx, c, done, err := r.dial(client.WithMaxConcurrency(*workers))
+	if err != nil {
+		return r.fail(err)
+	}
+	defer done()
+
+	s := transfer(ctx, sortedKeys(m.Files), *workers, func() (transferFunc, func()) {
+		var d *client.DownloadStream
+		do := func(path string) (int64, bool, error) {
+			// The manifest may come from anywhere: never write outside dir.
+			if !filepath.IsLocal(filepath.FromSlash(path)) {
+				return 0, false, fmt.Errorf("refusing to restore to a path outside %s", dir)
+			}
+			e := m.Files[path]
+			abs := filepath.Join(dir, filepath.FromSlash(path))
+			if sum, err := fileChecksum(abs); err == nil && sum == e.SHA256 {
+				return 0, true, nil
+			}
+
+			var err error
+			if d == nil {
+				if d, err = c.NewDownloadStream(ctx); err != nil {
+					return 0, false, err
+				}
+			}
+			rc, err := d.Get(e.ID)
+			if err != nil {
+				return 0, false, err
+			}
+			data, err := io.ReadAll(rc)
+			rc.Close()
+			if err != nil {
+				return 0, false, err
+			}
+			if checksum(data) != e.SHA256 {
+				return 0, false, fmt.Errorf("object %s does not match the manifest checksum", e.ID)
+			}
+			if err := writeFile(abs, data, e.Mode); err != nil {
+				return 0, false, err
+			}
+			if *verbose && !r.json {
+				fmt.Printf("%s %s\n", e.ID, path)
+			}
+			return int64(len(data)), false, nil
+		}
+		return do, func() {
+			if d != nil {
+				d.Close()
+			}
+		}
+	})
+	if ctx.Err() != nil {
+		return r.fail(ctx.Err())
+	}
+
+	if err := r.print(s, func() { s.print("restored") }); err != nil {
+		return err
+	}
+	return r.fail(s.err())
+}
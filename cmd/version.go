@@ -0,0 +1,19 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/NikoMalik/potoc/internal/version"
+)
+
+func runVersion([]string) error {
+	build := version.ReadBuild()
+
+	fmt.Printf("potoc %s\n", version.Version)
+	fmt.Printf("  go:       %s\n", build.GoVersion)
+	if build.Commit != "" {
+		fmt.Printf("  commit:   %s (modified: %t)\n", build.Commit, build.Modified)
+		fmt.Printf("  built at: %s\n", build.Time)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/NikoMalik/potoc/pkg/client"
+)
+
+func runWatch(args []string) error {
+	fs, r := newRemoteFlags("watch", "[flags]", 0)
+	l := labels{}
+	fs.Var(l, "label", "only events of objects with label key=value, repeatable")
+	var kinds eventKinds
+	fs.Var(&kinds, "kind", "only events of these kinds, comma separated: created, updated, deleted")
+	after := fs.Int64("after", -1, "start with the stored events after this seq instead of new ones")
+	count := fs.Int("n", 0, "exit after this many events, 0 to watch until interrupted")
+	if args = parse(fs, args); len(args) != 0 {
+		fs.Usage()
+		return _errUsage
+	}
+
+	opts := []client.CallOption{client.WithLabelFilter(l)}
+	if len(kinds) > 0 {
+		opts = append(opts, client.WithKinds(kinds...))
+	}
+	if *after >= 0 {
+		opts = append(opts, client.WithResumeAfter(*after))
+	}
+
+	ctx, c, done, err := r.dial()
+	if err != nil {
+		return r.fail(err)
+	}
+	defer done()
+
+	sub, err := c.Subscribe(ctx, opts...)
+	if err != nil {
+		return r.fail(err)
+	}
+	defer sub.Close()
+
+	// Events are printed as they come, one JSON object per line with -json.
+	enc := json.NewEncoder(os.Stdout)
+	for n := 0; *count == 0 || n < *count; n++ {
+		ev, err := sub.Next()
+		if err != nil {
+			// Interrupted or out of time: the watch is over, not failed.
+			if ctx.Err() != nil {
+				fmt.Fprintf(os.Stderr, "stopped, resume with -after %d\n", sub.Seq())
+				return nil
+			}
+			return r.fail(err)
+		}
+		if r.json {
+			if err := enc.Encode(ev); err != nil {
+				return err
+			}
+			continue
+		}
+		fmt.Printf("%d\t%s\t%s\t%s\t%d\t%s\n", ev.Seq, ev.Time.Format(time.RFC3339Nano), ev.Kind, ev.Object.ID, ev.Object.Size, labels(ev.Object.Labels))
+	}
+	return nil
+}
+
+type eventKinds []client.EventKind
+
+func (k *eventKinds) String() string {
+	s := make([]string, len(*k))
+	for i, kind := range *k {
+		s[i] = string(kind)
+	}
+	return strings.Join(s, ",")
+}
+
+func (k *eventKinds) Set(s string) error {
+	for _, kind := range strings.Split(s, ",") {
+		switch kind := client.EventKind(strings.TrimSpace(kind)); kind {
+		case client.EventCreated, client.EventUpdated, client.EventDeleted:
+			*k = append(*k, kind)
+		default:
+			return fmt.Errorf("%q is not one of created, updated, deleted", kind)
+		}
+	}
+	return nil
+}
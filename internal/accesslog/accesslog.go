@@ -0,0 +1,259 @@
+// Package accesslog records one audit entry per gRPC call: who called what,
+// how much data moved, which objects were touched and how it ended.
+package accesslog
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/NikoMalik/potoc/internal/logger"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	protobuf "google.golang.org/protobuf/proto"
+)
+
+const (
+	KindUnary  = "unary"
+	KindStream = "stream"
+)
+
+var _errClosed = errors.New("access log closed")
+
+// Entry is one access log record. Field names in the log output and the
+// audit table are part of the format and must not change.
+type Entry struct {
+	RequestID string
+	Method    string
+	Kind      string
+	Peer      string
+	Principal string
+	Start     time.Time
+	End       time.Time
+	MsgsIn    int64
+	MsgsOut   int64
+	BytesIn   int64
+	BytesOut  int64
+	Objects   []string
+	Code      string
+}
+
+// call is the entry of a call in progress. Handlers may still touch it
+// from their own goroutines, so every access holds mu.
+type call struct {
+	mu    sync.Mutex
+	entry Entry
+}
+
+// Store persists entries, e.g. in the audit table.
+type Store interface {
+	Insert(ctx context.Context, e *Entry) error
+}
+
+type callKey struct{}
+
+// Touch records that the call behind ctx read or wrote the given objects.
+// It is a no-op outside a logged call.
+func Touch(ctx context.Context, ids ...string) {
+	c, ok := ctx.Value(callKey{}).(*call)
+	if !ok {
+		return
+	}
+	c.mu.Lock()
+	c.entry.Objects = append(c.entry.Objects, ids...)
+	c.mu.Unlock()
+}
+
+// Log writes entries to its logger and, if it has one, to its store. Store
+// writes happen in the background so they never delay the call; when the
+// queue is full entries are dropped from the store but still logged.
+type Log struct {
+	log   *zap.Logger
+	store Store
+
+	queue   chan *Entry
+	done    chan struct{}
+	closeMu sync.RWMutex
+	closed  bool
+}
+
+// New returns an access log writing to log and, when store is not nil, to
+// store through a queue of queueSize entries.
+func New(log *zap.Logger, store Store, queueSize int) *Log {
+	l := &Log{log: log, store: store}
+	if store != nil {
+		l.queue = make(chan *Entry, queueSize)
+		l.done = make(chan struct{})
+		go l.run()
+	}
+	return l
+}
+
+// Close stops accepting entries and waits until the queued ones are
+// stored or ctx is done.
+func (l *Log) Close(ctx context.Context) error {
+	if l.queue == nil {
+		return nil
+	}
+
+	l.closeMu.Lock()
+	if !l.closed {
+		l.closed = true
+		close(l.queue)
+	}
+	l.closeMu.Unlock()
+
+	select {
+	case <-l.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (l *Log) run() {
+	defer close(l.done)
+	for e := range l.queue {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := l.store.Insert(ctx, e); err != nil {
+			logger.Error("failed to store access log entry", zap.String("request_id", e.RequestID), zap.Error(err))
+		}
+		cancel()
+	}
+}
+
+func (l *Log) record(e *Entry) {
+	l.log.Info("access",
+		zap.String("request_id", e.RequestID),
+		zap.String("method", e.Method),
+		zap.String("kind", e.Kind),
+		zap.String("peer", e.Peer),
+		zap.String("principal", e.Principal),
+		zap.Time("start", e.Start),
+		zap.Time("end", e.End),
+		zap.Int64("msgs_in", e.MsgsIn),
+		zap.Int64("msgs_out", e.MsgsOut),
+		zap.Int64("bytes_in", e.BytesIn),
+		zap.Int64("bytes_out", e.BytesOut),
+		zap.Strings("objects", e.Objects),
+		zap.String("code", e.Code),
+	)
+
+	if l.queue == nil {
+		return
+	}
+	l.closeMu.RLock()
+	defer l.closeMu.RUnlock()
+	if l.closed {
+		logger.Warn("access log entry not stored", zap.String("request_id", e.RequestID), zap.Error(_errClosed))
+		return
+	}
+	select {
+	case l.queue <- e:
+	default:
+		logger.Warn("access log queue full, entry not stored", zap.String("request_id", e.RequestID))
+	}
+}
+
+func newCall(ctx context.Context, method, kind string) *call {
+	c := &call{entry: Entry{
+		Method: method,
+		Kind:   kind,
+		Start:  time.Now(),
+	}}
+	if r, ok := logger.RequestFromContext(ctx); ok {
+		c.entry.RequestID, c.entry.Peer, c.entry.Principal = r.ID, r.Peer, r.Principal
+	}
+	return c
+}
+
+// finish completes the entry and records a copy of it, so objects touched
+// after the call returned do not race with the store.
+func (l *Log) finish(c *call, err error) {
+	c.mu.Lock()
+	c.entry.End = time.Now()
+	c.entry.Code = status.Code(err).String()
+	e := c.entry
+	e.Objects = slices.Clone(c.entry.Objects)
+	c.mu.Unlock()
+
+	l.record(&e)
+}
+
+func (c *call) count(in bool, m any) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if in {
+		c.entry.MsgsIn++
+		c.entry.BytesIn += size(m)
+	} else {
+		c.entry.MsgsOut++
+		c.entry.BytesOut += size(m)
+	}
+}
+
+// UnaryInterceptor logs every unary call. It must run after the logger
+// interceptors so the request ID is known.
+func (l *Log) UnaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	c := newCall(ctx, info.FullMethod, KindUnary)
+	c.count(true, req)
+
+	resp, err := handler(context.WithValue(ctx, callKey{}, c), req)
+	if err == nil {
+		c.count(false, resp)
+	}
+
+	l.finish(c, err)
+	return resp, err
+}
+
+// StreamInterceptor logs every stream once it ends.
+func (l *Log) StreamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	c := newCall(ss.Context(), info.FullMethod, KindStream)
+
+	err := handler(srv, &countingStream{
+		ServerStream: ss,
+		ctx:          context.WithValue(ss.Context(), callKey{}, c),
+		call:         c,
+	})
+
+	l.finish(c, err)
+	return err
+}
+
+type countingStream struct {
+	grpc.ServerStream
+	ctx  context.Context
+	call *call
+}
+
+func (s *countingStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *countingStream) SendMsg(m any) error {
+	err := s.ServerStream.SendMsg(m)
+	if err == nil {
+		s.call.count(false, m)
+	}
+	return err
+}
+
+func (s *countingStream) RecvMsg(m any) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err == nil {
+		s.call.count(true, m)
+	}
+	return err
+}
+
+func size(m any) int64 {
+	if pm, ok := m.(protobuf.Message); ok {
+		return int64(protobuf.Size(pm))
+	}
+	return 0
+}
@@ -0,0 +1,95 @@
+package accesslog
+
+import (
+	"context"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// blockingStore holds the first Insert until release is closed.
+type blockingStore struct {
+	started chan struct{}
+	release chan struct{}
+	once    sync.Once
+
+	mu     sync.Mutex
+	stored []string
+}
+
+func newBlockingStore() *blockingStore {
+	return &blockingStore{started: make(chan struct{}), release: make(chan struct{})}
+}
+
+func (s *blockingStore) Insert(_ context.Context, e *Entry) error {
+	s.once.Do(func() { close(s.started) })
+	<-s.release
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stored = append(s.stored, e.RequestID)
+	return nil
+}
+
+func TestQueueOverflow(t *testing.T) {
+	tests := []struct {
+		name    string
+		queue   int
+		entries int
+		// closed records the last entry after Close.
+		closed bool
+		// The first entry is taken off the queue and held by the store,
+		// the next queue entries wait, the rest are only logged.
+		wantStored int
+	}{
+		{name: "room for all", queue: 8, entries: 5, wantStored: 5},
+		{name: "queue full", queue: 2, entries: 5, wantStored: 3},
+		{name: "queue of one", queue: 1, entries: 4, wantStored: 2},
+		{name: "after close", queue: 8, entries: 3, closed: true, wantStored: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			core, logs := observer.New(zapcore.InfoLevel)
+			store := newBlockingStore()
+			l := New(zap.New(core), store, tt.queue)
+
+			l.record(&Entry{RequestID: "0"})
+			<-store.started
+			last := tt.entries
+			if tt.closed {
+				last--
+			}
+			for i := 1; i < last; i++ {
+				l.record(&Entry{RequestID: strconv.Itoa(i)})
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			close(store.release)
+			if err := l.Close(ctx); err != nil {
+				t.Fatal(err)
+			}
+			if tt.closed {
+				l.record(&Entry{RequestID: strconv.Itoa(last)})
+			}
+
+			if got := logs.FilterMessage("access").Len(); got != tt.entries {
+				t.Errorf("logged %d entries, want all %d", got, tt.entries)
+			}
+			want := make([]string, tt.wantStored)
+			for i := range want {
+				want[i] = strconv.Itoa(i)
+			}
+			if !slices.Equal(store.stored, want) {
+				t.Errorf("stored %q, want %q", store.stored, want)
+			}
+		})
+	}
+}
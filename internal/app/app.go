@@ -3,6 +3,7 @@ package app
 import (
 	"context"
 	"errors"
+	"fmt"
 	"log"
 	"os"
 
@@ -11,111 +12,94 @@ import (
 	"github.com/NikoMalik/potoc/internal/logger"
 	"github.com/NikoMalik/potoc/internal/repository"
 	"github.com/NikoMalik/potoc/internal/server"
+	"github.com/NikoMalik/potoc/internal/tracing"
 	"github.com/subosito/gotenv"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	"go.uber.org/zap"
 )
 
 var (
-	_errorInitial = errors.New("failed to initialize app")
+	_errorInitial    = errors.New("failed to initialize app")
+	_errDrainTimeout = errors.New("drain timed out")
 )
 
 type App struct {
 	Config *config.Config
 	DB     *repository.Repositories
 	Server *server.Server
+
+	lifecycle *Lifecycle
+	serveErr  chan error
 }
 
-//	func cjaller(caller zapcore.EntryCaller, enc zapcore.PrimitiveArrayEncoder) {
-//		enc.AppendString(filepath.Base(caller.FullPath()))
-//	}
-//
-//	func getAtomicLevel(config *config.Config) zap.AtomicLevel {
-//		var level zapcore.Level
-//		if err := level.Set(config.LogLevel); err != nil {
-//			log.Fatalf("failed to init log Level: %v", err)
-//		}
-//
-//		return zap.NewAtomicLevelAt(level)
-//	}
-//
-//	func initLog(level zap.AtomicLevel) zapcore.Core {
-//		stdout := zapcore.AddSync(os.Stdout)
-//
-//		logDir := "./logs"
-//		if _, err := os.Stat(logDir); os.IsNotExist(err) {
-//			err := os.MkdirAll(logDir, 0755)
-//			if err != nil {
-//				panic(fmt.Sprintf("failed to create log directory: %v", err))
-//			}
-//		}
-//
-//		logFilePath := logDir + "/app.log"
-//
-//		file, err := os.OpenFile(logFilePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-//		if err != nil {
-//			panic(fmt.Sprintf("failed to open log file: %v", err))
-//		}
-//
-//		if fileInfo, err := file.Stat(); err == nil && fileInfo.Size() > 10000 {
-//			file.Truncate(0)
-//			file.Seek(0, 0)
-//		}
-//
-//		fileSync := zapcore.AddSync(file)
-//
-//		productionConfig := zap.NewProductionConfig()
-//		productionConfig.EncoderConfig.TimeKey = "time"
-//		productionConfig.EncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
-//		productionConfig.EncoderConfig.CallerKey = "caller"
-//		productionConfig.EncoderConfig.EncodeCaller = cjaller
-//		productionConfig.EncoderConfig.EncodeLevel = zapcore.CapitalLevelEncoder
-//
-//		devConfig := zap.NewDevelopmentConfig()
-//		devConfig.EncoderConfig.TimeKey = "time"
-//		devConfig.EncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
-//		devConfig.EncoderConfig.CallerKey = "caller"
-//		devConfig.EncoderConfig.EncodeCaller = zapcore.ShortCallerEncoder
-//		devConfig.EncoderConfig.EncodeLevel = zapcore.CapitalColorLevelEncoder
-//
-//		consoleEncoder := zapcore.NewConsoleEncoder(devConfig.EncoderConfig)
-//		fileEncoder := zapcore.NewJSONEncoder(productionConfig.EncoderConfig)
-//
-//		return zapcore.NewTee(
-//			zapcore.NewCore(consoleEncoder, stdout, level),
-//			zapcore.NewCore(fileEncoder, fileSync, level),
-//		)
-//
-// }
-func NewApp(ctx context.Context) (*App, error) {
-	err := gotenv.Load()
-	if err != nil {
-		panic(err)
+// LoadConfig reads .env and the config file for $ENVIRONMENT. It also
+// initializes the logger, so it is the first thing every command calls.
+func LoadConfig() (*config.Config, error) {
+	if err := loadDotEnv(); err != nil {
+		return nil, err
 	}
-	env := getEnv()
-	config, err := config.NewConfig(env)
-	if err != nil {
-		log.Fatal(err)
+	return config.NewConfig(getEnv())
+}
+
+// ReadConfig is LoadConfig without the logger, for commands that only
+// inspect the config and must not write logs.
+func ReadConfig() (*config.Config, error) {
+	if err := loadDotEnv(); err != nil {
+		return nil, err
 	}
-	// logger.InitLog(initLog(getAtomicLevel(config)), zap.AddCaller(), zap.AddCallerSkip(1))
+	return config.Load(getEnv())
+}
 
-	logger.Info("Init server", zap.String("env", env))
+// loadDotEnv reads .env when there is one; deployments may set every
+// variable in the environment instead.
+func loadDotEnv() error {
+	if err := gotenv.Load(); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
 
-	db := database.NewDB()
+func NewApp(ctx context.Context) (*App, error) {
+	config, err := LoadConfig()
+	if err != nil {
+		return nil, err
+	}
 
-	repos := repository.NewRepositories(db)
-	go func() {
-		err := repos.RandomRepo.GenerateRandomData(ctx)
-		if err != nil {
-			logger.Error("Failed to generate random data", zap.Error(err))
-		}
-	}()
-	server := server.NewServer(config, repos)
+	logger.Info("Init server", zap.String("env", config.Env))
 
 	app := &App{
-		Config: config,
-		DB:     repos,
-		Server: server,
+		Config:    config,
+		lifecycle: &Lifecycle{},
+		serveErr:  make(chan error, 1),
+	}
+
+	app.Register(tracingHook(config.Tracing))
+
+	db, err := database.NewDB(ctx, config.DB)
+	if err != nil {
+		return nil, err
 	}
+	app.Register(Hook{
+		Name: "database",
+		Stop: func(context.Context) error {
+			db.Close()
+			return nil
+		},
+	})
+
+	if config.DB.AutoMigrate {
+		if err := database.Migrate(db, config.DB.MigrationsDir); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+
+	app.DB = repository.NewRepositories(db)
+	app.Register(seederHook(app.DB.RandomRepo))
+
+	app.Server = server.NewServer(config, app.DB)
+	app.Register(app.serverHook())
+	app.Register(app.reloadHook())
 
 	return app, nil
 }
@@ -138,32 +122,128 @@ func getEnv() string {
 
 }
 
+// Register adds a component to the app. Components start in registration
+// order when Run is called and stop in reverse order on Close, so a
+// component must be registered after everything it depends on.
+func (app *App) Register(hook Hook) {
+	app.lifecycle.Append(hook)
+}
+
+// Run starts every component and blocks until the server stops serving.
 func (app *App) Run() error {
 	if app == nil {
 		return _errorInitial
 	}
 
-	return app.Server.Run()
+	if err := app.lifecycle.Start(context.Background()); err != nil {
+		return err
+	}
+
+	return <-app.serveErr
 }
 
+// Close stops every started component in reverse order. Errors from all
+// of them are returned together.
 func (app *App) Close(ctx context.Context) error {
 	if app == nil {
 		return _errorInitial
 	}
-	done := make(chan error, 1)
-	go func() {
-		done <- app.Server.Stop()
-	}()
-	select {
-	case <-ctx.Done():
-		logger.Warn("Shutdown timed out, start panic stop")
-		app.Server.PanicStop()
-		return ctx.Err()
-	case err := <-done:
-		if err != nil {
-			logger.Error("ERROR DURING SERVER SHUTDOWN", zap.Error(err))
-		}
-		logger.Info("Server shutdown gracefully")
-		return nil
+
+	if err := app.lifecycle.Stop(ctx); err != nil {
+		return err
+	}
+	logger.Info("Server shutdown gracefully")
+	return nil
+}
+
+func (app *App) serverHook() Hook {
+	return Hook{
+		Name: "server",
+		Start: func(context.Context) error {
+			go func() {
+				app.serveErr <- app.Server.Run()
+			}()
+			return nil
+		},
+		Stop: func(ctx context.Context) error {
+			drainCtx, cancel := context.WithTimeout(ctx, app.Config.Server.DrainTimeout)
+			defer cancel()
+
+			report, err := app.Server.Drain(drainCtx)
+			if err != nil {
+				return err
+			}
+			if len(report.Aborted) > 0 {
+				return fmt.Errorf("%w: %d stream(s) aborted", _errDrainTimeout, len(report.Aborted))
+			}
+			return nil
+		},
+	}
+}
+
+// reloadHook watches the config file once everything is running and
+// applies log level and limit changes live. Stop ends the watch.
+func (app *App) reloadHook() Hook {
+	var stop func() error
+	return Hook{
+		Name: "config-reload",
+		Start: func(context.Context) (err error) {
+			stop, err = config.Watch(app.Config, func(live *config.Config) {
+				app.Server.SetLimits(live.Limits)
+			})
+			return err
+		},
+		Stop: func(context.Context) error {
+			return stop()
+		},
+	}
+}
+
+// tracingHook installs the tracer provider on Start, so that nothing is
+// left to shut down when the app fails before it runs.
+func tracingHook(cfg *config.Tracing) Hook {
+	var tp *sdktrace.TracerProvider
+	return Hook{
+		Name: "tracing",
+		Start: func(ctx context.Context) (err error) {
+			tp, err = tracing.NewProvider(ctx, cfg)
+			return err
+		},
+		Stop: func(ctx context.Context) error {
+			if tp == nil {
+				return nil
+			}
+			return tp.Shutdown(ctx)
+		},
+	}
+}
+
+// seederHook fills random_data in the background. Stop cancels the run and
+// waits for in-flight inserts.
+func seederHook(repo repository.RandomRepo) Hook {
+	cancel := context.CancelFunc(func() {})
+	done := make(chan struct{})
+
+	return Hook{
+		Name: "seeder",
+		Start: func(ctx context.Context) error {
+			ctx, cancel = context.WithCancel(context.WithoutCancel(ctx))
+			go func() {
+				defer close(done)
+				if err := repo.GenerateRandomData(ctx); err != nil {
+					logger.Error("Failed to generate random data", zap.Error(err))
+				}
+			}()
+			return nil
+		},
+		Stop: func(ctx context.Context) error {
+			cancel()
+			select {
+			case <-done:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		},
 	}
 }
@@ -0,0 +1,94 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/NikoMalik/potoc/internal/logger"
+	"go.uber.org/zap"
+)
+
+// Hook is a component of the app. Start must not block; long running work
+// belongs in a goroutine that Stop ends. Either func may be nil.
+type Hook struct {
+	Name  string
+	Start func(ctx context.Context) error
+	Stop  func(ctx context.Context) error
+}
+
+// Lifecycle starts hooks in the order they were appended and stops the
+// started ones in reverse order.
+type Lifecycle struct {
+	mu      sync.Mutex
+	hooks   []Hook
+	started int
+}
+
+func (l *Lifecycle) Append(hook Hook) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.hooks = append(l.hooks, hook)
+}
+
+// Start runs every start hook. If one fails the hooks started before it are
+// stopped and all errors are returned together.
+func (l *Lifecycle) Start(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for l.started < len(l.hooks) {
+		hook := l.hooks[l.started]
+		if hook.Start != nil {
+			logger.Debug("Starting component", zap.String("component", hook.Name))
+			if err := hook.Start(ctx); err != nil {
+				err = fmt.Errorf("start %s: %w", hook.Name, err)
+				return errors.Join(err, l.stop(ctx))
+			}
+		}
+		l.started++
+	}
+
+	return nil
+}
+
+// Stop runs the stop hooks of all started components in reverse order. It
+// keeps going on failure and returns every error it saw.
+func (l *Lifecycle) Stop(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.stop(ctx)
+}
+
+func (l *Lifecycle) stop(ctx context.Context) error {
+	var errs []error
+	for ; l.started > 0; l.started-- {
+		hook := l.hooks[l.started-1]
+		if hook.Stop == nil {
+			continue
+		}
+		logger.Debug("Stopping component", zap.String("component", hook.Name))
+		if err := stopHook(ctx, hook); err != nil {
+			logger.Error("failed to stop component", zap.String("component", hook.Name), zap.Error(err))
+			errs = append(errs, fmt.Errorf("stop %s: %w", hook.Name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// stopHook runs hook.Stop and gives up on it once ctx is done, so a hook
+// that ignores ctx cannot hang the shutdown.
+func stopHook(ctx context.Context, hook Hook) error {
+	done := make(chan error, 1)
+	go func() { done <- hook.Stop(ctx) }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -1,18 +1,20 @@
 package config
 
 import (
+	"errors"
 	"fmt"
-	"log"
 	"os"
-	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/NikoMalik/potoc/internal/logger"
 	grpcMiddleware "github.com/grpc-ecosystem/go-grpc-middleware"
+	"github.com/mitchellh/mapstructure"
 	"github.com/spf13/viper"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"go.uber.org/zap"
-	"go.uber.org/zap/zapcore"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/keepalive"
 )
 
 const (
@@ -21,16 +23,24 @@ const (
 )
 
 type Config struct {
-	Env      string  `mapstructure:"env"`
-	Server   *Server `mapstructure:"server"`
-	DB       *DB     `mapstructure:"db"`
-	LogLevel string  `mapstructure:"log_level"`
+	Env       string     `mapstructure:"env"`
+	Server    *Server    `mapstructure:"server"`
+	Admin     *Admin     `mapstructure:"admin"`
+	DB        *DB        `mapstructure:"db"`
+	Tracing   *Tracing   `mapstructure:"tracing"`
+	Limits    *Limits    `mapstructure:"limits"`
+	Log       *Log       `mapstructure:"log"`
+	AccessLog *AccessLog `mapstructure:"access_log"`
+	Events    *Events    `mapstructure:"events"`
+	Queue     *Queue     `mapstructure:"queue"`
+	Webhooks  *Webhooks  `mapstructure:"webhooks"`
+	LogLevel  string     `mapstructure:"log_level"`
 }
 
 type Server struct {
 	Host                  string              `mapstructure:"host"`
 	Port                  string              `mapstructure:"port"`
-	Opts                  []grpc.ServerOption `mapstructure:"opts"`
+	Opts                  []grpc.ServerOption `mapstructure:"-"`
 	MaxStreams            uint32              `mapstructure:"max_streams"`
 	WriteBufferSize       int                 `mapstructure:"write_buffer_size"`
 	ReadBufferSize        int                 `mapstructure:"read_buffer_size"`
@@ -38,6 +48,40 @@ type Server struct {
 	InitialConnWindowSize int32               `mapstructure:"initial_conn_window_size"`
 	MaxHeaderListSize     uint32              `mapstructure:"max_header_list_size"`
 	MaxRecvMsgSize        int                 `mapstructure:"max_recv_msg_size"`
+	MaxSendMsgSize        int                 `mapstructure:"max_send_msg_size"`
+	// ConnectionTimeout bounds the connection handshake.
+	ConnectionTimeout time.Duration `mapstructure:"connection_timeout"`
+	// NumStreamWorkers handles streams on a fixed pool of goroutines
+	// instead of one goroutine per stream. Zero disables the pool.
+	NumStreamWorkers uint32     `mapstructure:"num_stream_workers"`
+	Keepalive        *Keepalive `mapstructure:"keepalive"`
+	// Reflection registers the gRPC reflection service for grpcurl and
+	// similar tools. Keep it off in production.
+	Reflection bool `mapstructure:"reflection"`
+	// DrainTimeout bounds how long shutdown waits for open streams.
+	DrainTimeout time.Duration `mapstructure:"drain_timeout"`
+}
+
+// Keepalive holds the server keepalive parameters and the enforcement
+// policy applied to client pings. Zero durations keep the gRPC defaults.
+type Keepalive struct {
+	MaxConnectionIdle     time.Duration `mapstructure:"max_connection_idle"`
+	MaxConnectionAge      time.Duration `mapstructure:"max_connection_age"`
+	MaxConnectionAgeGrace time.Duration `mapstructure:"max_connection_age_grace"`
+	Time                  time.Duration `mapstructure:"time"`
+	Timeout               time.Duration `mapstructure:"timeout"`
+
+	// MinTime is the shortest ping interval a client may use before the
+	// server closes the connection with ENHANCE_YOUR_CALM.
+	MinTime             time.Duration `mapstructure:"min_time"`
+	PermitWithoutStream bool          `mapstructure:"permit_without_stream"`
+}
+
+// Admin is the plain HTTP listener serving /healthz and /readyz.
+type Admin struct {
+	Host                string        `mapstructure:"host"`
+	Port                string        `mapstructure:"port"`
+	HealthCheckInterval time.Duration `mapstructure:"health_check_interval"`
 }
 
 type DB struct {
@@ -47,52 +91,262 @@ type DB struct {
 	Password string `mapstructure:"password"`
 	Name     string `mapstructure:"db_name"`
 	SSLMode  string `mapstructure:"ssl_mode"`
+
+	// AutoMigrate applies pending migrations on start. MigrationsDir
+	// overrides the migrations embedded in the binary.
+	AutoMigrate   bool   `mapstructure:"auto_migrate"`
+	MigrationsDir string `mapstructure:"migrations_dir"`
+
+	// Pool tuning, zero keeps the pgxpool default.
+	MaxConns          int32         `mapstructure:"max_conns"`
+	MinConns          int32         `mapstructure:"min_conns"`
+	MaxConnLifetime   time.Duration `mapstructure:"max_conn_lifetime"`
+	MaxConnIdleTime   time.Duration `mapstructure:"max_conn_idle_time"`
+	HealthCheckPeriod time.Duration `mapstructure:"health_check_period"`
+
+	// Initial connection, retried with exponential backoff.
+	ConnectAttempts int           `mapstructure:"connect_attempts"`
+	ConnectBackoff  time.Duration `mapstructure:"connect_backoff"`
+	ConnectTimeout  time.Duration `mapstructure:"connect_timeout"`
+}
+
+// Tracing configures the OpenTelemetry exporter. Exporter is one of
+// "otlp", "stdout" or "none".
+type Tracing struct {
+	Enabled     bool   `mapstructure:"enabled"`
+	Exporter    string `mapstructure:"exporter"`
+	Endpoint    string `mapstructure:"endpoint"`
+	Insecure    bool   `mapstructure:"insecure"`
+	ServiceName string `mapstructure:"service_name"`
+	// SampleRatio is the share of traces kept, from 0 for none to 1.
+	SampleRatio float64 `mapstructure:"sample_ratio"`
+}
+
+// Events configures Subscribe. The server learns about new events from
+// Postgres notifications and polls every PollInterval in case one is lost.
+type Events struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Buffer is how many events a subscriber may fall behind before its
+	// stream is ended.
+	Buffer       int           `mapstructure:"buffer"`
+	PollInterval time.Duration `mapstructure:"poll_interval"`
+	// Retention is how long events are kept for subscribers resuming from
+	// a sequence number.
+	Retention time.Duration `mapstructure:"retention"`
+}
+
+// Queue configures Consume. Consumers may ask for their own visibility
+// timeout and prefetch up to the maximums.
+type Queue struct {
+	VisibilityTimeout    time.Duration `mapstructure:"visibility_timeout"`
+	MaxVisibilityTimeout time.Duration `mapstructure:"max_visibility_timeout"`
+	Prefetch             int           `mapstructure:"prefetch"`
+	MaxPrefetch          int           `mapstructure:"max_prefetch"`
+	// PollInterval bounds how long a consumer waits for new objects when
+	// no event wakes it first.
+	PollInterval time.Duration `mapstructure:"poll_interval"`
+}
+
+// Webhooks posts object events to HTTP endpoints. Deliveries wait in a
+// Postgres outbox, so they survive restarts, and failed ones are retried
+// with exponential backoff until MaxAttempts, after which they are kept as
+// dead letters. The settings below are the defaults of every endpoint.
+type Webhooks struct {
+	Endpoints []WebhookEndpoint `mapstructure:"endpoints"`
+	// Workers bounds the posts in flight on each server.
+	Workers int `mapstructure:"workers"`
+	// PollInterval bounds how long a due delivery waits when no event
+	// wakes the server first.
+	PollInterval time.Duration `mapstructure:"poll_interval"`
+	Timeout      time.Duration `mapstructure:"timeout"`
+	MaxAttempts  int           `mapstructure:"max_attempts"`
+	MinBackoff   time.Duration `mapstructure:"min_backoff"`
+	MaxBackoff   time.Duration `mapstructure:"max_backoff"`
+}
+
+// WebhookEndpoint receives the events it selects as signed JSON posts.
+// Zero durations and attempts keep the webhooks defaults.
+type WebhookEndpoint struct {
+	// Name identifies the endpoint in the outbox; renaming it leaves its
+	// pending deliveries behind.
+	Name string `mapstructure:"name"`
+	URL  string `mapstructure:"url"`
+	// Secret keys the HMAC-SHA256 signature of every post.
+	Secret string `mapstructure:"secret"`
+	// Kinds are the event kinds posted, all of them when empty.
+	Kinds []string `mapstructure:"kinds"`
+	// Labels are key=value pairs an object must all carry.
+	Labels      []string      `mapstructure:"labels"`
+	Timeout     time.Duration `mapstructure:"timeout"`
+	MaxAttempts int           `mapstructure:"max_attempts"`
+	MinBackoff  time.Duration `mapstructure:"min_backoff"`
+	MaxBackoff  time.Duration `mapstructure:"max_backoff"`
+}
+
+// WithDefaults returns e with its zero settings taken from w.
+func (w *Webhooks) WithDefaults(e WebhookEndpoint) WebhookEndpoint {
+	if e.Timeout == 0 {
+		e.Timeout = w.Timeout
+	}
+	if e.MaxAttempts == 0 {
+		e.MaxAttempts = w.MaxAttempts
+	}
+	if e.MinBackoff == 0 {
+		e.MinBackoff = w.MinBackoff
+	}
+	if e.MaxBackoff == 0 {
+		e.MaxBackoff = w.MaxBackoff
+	}
+	return e
 }
 
+// Limits are per stream limits. They can be changed while the server runs,
+// see Watch. Zero means unlimited.
+type Limits struct {
+	MessagesPerSecond float64 `mapstructure:"messages_per_second"`
+	Burst             int     `mapstructure:"burst"`
+	MaxPayloadBytes   int     `mapstructure:"max_payload_bytes"`
+	// MaxTransactionObjects and MaxTransactionBytes bound a transactional
+	// upload, which the server holds in memory until it commits.
+	MaxTransactionObjects int `mapstructure:"max_transaction_objects"`
+	MaxTransactionBytes   int `mapstructure:"max_transaction_bytes"`
+	// A transactional upload is rolled back when no message arrives for
+	// TransactionIdleTimeout, or when it is still open after
+	// TransactionTimeout.
+	TransactionIdleTimeout time.Duration `mapstructure:"transaction_idle_timeout"`
+	TransactionTimeout     time.Duration `mapstructure:"transaction_timeout"`
+}
+
+// OpenLoad reads configs/<env>.yaml on top of the defaults, expands
+// ${VAR} values from the environment and validates the result. Every
+// problem found is returned in a single *ValidationError.
 func OpenLoad(env string) (*Config, error) {
 	viper.SetConfigName(env)
 	viper.SetConfigType("yaml")
 	viper.AddConfigPath("configs/")
+	setDefaults(viper.GetViper())
 
 	viper.AutomaticEnv()
 	if err := viper.ReadInConfig(); err != nil {
-		return nil, err
+		return nil, fmt.Errorf("read config %q: %w", env, err)
 	}
 
-	for _, k := range viper.AllKeys() {
-		value := viper.GetString(k)
-		if strings.HasPrefix(value, "${") && strings.HasSuffix(value, "}") {
-			viper.Set(k, getEnvOrPanic(strings.TrimSuffix(strings.TrimPrefix(value, "${"), "}")))
+	return decode()
+}
+
+// decode expands ${VAR} references in the settings viper has read, then
+// decodes and validates them.
+func decode() (*Config, error) {
+	var p problems
+	settings := expandEnv("", viper.AllSettings(), &p)
+
+	config := &Config{}
+	var meta mapstructure.Metadata
+	dec, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		DecodeHook: mapstructure.ComposeDecodeHookFunc(
+			mapstructure.StringToTimeDurationHookFunc(),
+			mapstructure.StringToSliceHookFunc(","),
+		),
+		Metadata:         &meta,
+		Result:           config,
+		WeaklyTypedInput: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := dec.Decode(settings); err != nil {
+		var decodeErr *mapstructure.Error
+		if !errors.As(err, &decodeErr) {
+			return nil, fmt.Errorf("unable to decode into struct, %v", err)
+		}
+		for _, e := range decodeErr.Errors {
+			p.add("%s", e)
 		}
 	}
-
-	var config *Config
-	if err := viper.Unmarshal(&config); err != nil {
-		return nil, fmt.Errorf("unable to decode into struct, %v", err)
+	for _, key := range meta.Unused {
+		p.add("%s: unknown key", key)
 	}
 
+	config.validate(&p)
+	if err := p.err(); err != nil {
+		return nil, err
+	}
 	return config, nil
 }
 
-func getEnvOrPanic(env string) string {
-	res := os.Getenv(env)
+// expandEnv returns a copy of settings with every "${VAR}" value replaced
+// by the environment variable. Unset variables are reported to p.
+func expandEnv(prefix string, settings map[string]any, p *problems) map[string]any {
+	out := make(map[string]any, len(settings))
+	for k, v := range settings {
+		key := prefix + k
+		switch v := v.(type) {
+		case map[string]any:
+			out[k] = expandEnv(key+".", v, p)
+		case []any:
+			list := make([]any, len(v))
+			for i, e := range v {
+				if m, ok := e.(map[string]any); ok {
+					e = expandEnv(fmt.Sprintf("%s[%d].", key, i), m, p)
+				}
+				list[i] = e
+			}
+			out[k] = list
+		case string:
+			out[k] = v
+			if strings.HasPrefix(v, "${") && strings.HasSuffix(v, "}") {
+				name := strings.TrimSuffix(strings.TrimPrefix(v, "${"), "}")
+				res, ok := os.LookupEnv(name)
+				if !ok || res == "" {
+					p.addUnset(key, name)
+				}
+				out[k] = res
+			}
+		default:
+			out[k] = v
+		}
+	}
+	return out
+}
 
-	if len(res) <= 0 {
-		panic(fmt.Sprintf("env variable %s not found", env))
+// NewConfig loads the config for env and sets up the logger and the access
+// log from it.
+func NewConfig(env string) (*Config, error) {
+	config, err := Load(env)
+	if err != nil {
+		return nil, err
 	}
 
-	return res
+	var files logFiles
+	core, err := initLog(config.Log, getAtomicLevel(config), &files)
+	if err != nil {
+		return nil, errors.Join(err, files.close())
+	}
+	if config.AccessLog != nil {
+		if config.AccessLog.Logger, err = initAccessLog(config.AccessLog, &files); err != nil {
+			return nil, errors.Join(err, files.close())
+		}
+	}
+
+	logger.InitLog(core, zap.AddCaller(), zap.AddCallerSkip(1))
+	logger.SetPayloadPolicy(payloadPolicy(config.Log))
+	if err := swapLogFiles(files); err != nil {
+		logger.Warn("failed to close previous log files", zap.Error(err))
+	}
+	return config, nil
 }
 
-func NewConfig(env string) (*Config, error) {
+// Load reads and validates the config for env and builds the gRPC server
+// options, without opening any log sink.
+func Load(env string) (*Config, error) {
 	config, err := OpenLoad(env)
 	if err != nil {
 		return nil, err
 	}
 
-	logger.InitLog(initLog(getAtomicLevel(config)), zap.AddCaller(), zap.AddCallerSkip(1))
-
+	s := config.Server
 	ops := []grpc.ServerOption{
+		grpc.StatsHandler(otelgrpc.NewServerHandler()),
 		grpc.StreamInterceptor(
 			grpcMiddleware.ChainStreamServer(
 				logger.StreamConnectionInterceptor,
@@ -102,13 +356,31 @@ func NewConfig(env string) (*Config, error) {
 			grpcMiddleware.ChainUnaryServer(
 				logger.ConnectionInterceptor,
 			),
-		), grpc.MaxConcurrentStreams(config.Server.MaxStreams),
-		grpc.WriteBufferSize(config.Server.WriteBufferSize),
-		grpc.ReadBufferSize(config.Server.ReadBufferSize),
-		grpc.InitialWindowSize(config.Server.InitialConnWindowSize),
-		grpc.InitialConnWindowSize(config.Server.InitialConnWindowSize),
-		grpc.MaxHeaderListSize(config.Server.MaxHeaderListSize),
-		grpc.MaxRecvMsgSize(config.Server.MaxRecvMsgSize),
+		), grpc.MaxConcurrentStreams(s.MaxStreams),
+		grpc.WriteBufferSize(s.WriteBufferSize),
+		grpc.ReadBufferSize(s.ReadBufferSize),
+		grpc.InitialWindowSize(s.InitialWindowSize),
+		grpc.InitialConnWindowSize(s.InitialConnWindowSize),
+		grpc.MaxHeaderListSize(s.MaxHeaderListSize),
+		grpc.MaxRecvMsgSize(s.MaxRecvMsgSize),
+		grpc.MaxSendMsgSize(s.MaxSendMsgSize),
+		grpc.ConnectionTimeout(s.ConnectionTimeout),
+		grpc.NumStreamWorkers(s.NumStreamWorkers),
+	}
+	if ka := s.Keepalive; ka != nil {
+		ops = append(ops,
+			grpc.KeepaliveParams(keepalive.ServerParameters{
+				MaxConnectionIdle:     ka.MaxConnectionIdle,
+				MaxConnectionAge:      ka.MaxConnectionAge,
+				MaxConnectionAgeGrace: ka.MaxConnectionAgeGrace,
+				Time:                  ka.Time,
+				Timeout:               ka.Timeout,
+			}),
+			grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+				MinTime:             ka.MinTime,
+				PermitWithoutStream: ka.PermitWithoutStream,
+			}),
+		)
 	}
 
 	config.Server.Opts = ops
@@ -117,64 +389,77 @@ func NewConfig(env string) (*Config, error) {
 
 }
 
-func cjaller(caller zapcore.EntryCaller, enc zapcore.PrimitiveArrayEncoder) {
-	enc.AppendString(filepath.Base(caller.FullPath()))
-}
-
-func getAtomicLevel(config *Config) zap.AtomicLevel {
-	var level zapcore.Level
-	if err := level.Set(config.LogLevel); err != nil {
-		log.Fatalf("failed to init log Level: %v", err)
+// LogServerOptions prints the gRPC settings the server runs with, after
+// defaults, so the effective values show up in the startup log.
+func LogServerOptions(s *Server) {
+	fields := []zap.Field{
+		zap.Uint32("max_streams", s.MaxStreams),
+		zap.Int("write_buffer_size", s.WriteBufferSize),
+		zap.Int("read_buffer_size", s.ReadBufferSize),
+		zap.Int32("initial_window_size", s.InitialWindowSize),
+		zap.Int32("initial_conn_window_size", s.InitialConnWindowSize),
+		zap.Uint32("max_header_list_size", s.MaxHeaderListSize),
+		zap.Int("max_recv_msg_size", s.MaxRecvMsgSize),
+		zap.Int("max_send_msg_size", s.MaxSendMsgSize),
+		zap.Duration("connection_timeout", s.ConnectionTimeout),
+		zap.Uint32("num_stream_workers", s.NumStreamWorkers),
 	}
-
-	return zap.NewAtomicLevelAt(level)
+	if ka := s.Keepalive; ka != nil {
+		fields = append(fields,
+			zap.Duration("keepalive.max_connection_idle", ka.MaxConnectionIdle),
+			zap.Duration("keepalive.max_connection_age", ka.MaxConnectionAge),
+			zap.Duration("keepalive.max_connection_age_grace", ka.MaxConnectionAgeGrace),
+			zap.Duration("keepalive.time", ka.Time),
+			zap.Duration("keepalive.timeout", ka.Timeout),
+			zap.Duration("keepalive.min_time", ka.MinTime),
+			zap.Bool("keepalive.permit_without_stream", ka.PermitWithoutStream),
+		)
+	}
+	logger.Info("gRPC server options", fields...)
 }
 
-func initLog(level zap.AtomicLevel) zapcore.Core {
-	stdout := zapcore.AddSync(os.Stdout)
+const masked = "********"
 
-	logDir := "./logs"
-	if _, err := os.Stat(logDir); os.IsNotExist(err) {
-		err := os.MkdirAll(logDir, 0755)
-		if err != nil {
-			panic(fmt.Sprintf("failed to create log directory: %v", err))
-		}
-	}
+// secretKeys are substrings of setting names whose values are never printed.
+var secretKeys = []string{"password", "secret", "token", "key"}
 
-	logFilePath := logDir + "/app.json"
+// Settings returns the settings resolved by OpenLoad, after ${VAR}
+// substitution, with every secret masked.
+func Settings() map[string]any {
+	return maskSecrets(expandEnv("", viper.AllSettings(), new(problems)))
+}
 
-	file, err := os.OpenFile(logFilePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		panic(fmt.Sprintf("failed to open log file: %v", err))
+func maskSecrets(settings map[string]any) map[string]any {
+	out := make(map[string]any, len(settings))
+	for k, v := range settings {
+		switch v := v.(type) {
+		case map[string]any:
+			out[k] = maskSecrets(v)
+		case []any:
+			list := make([]any, len(v))
+			for i, e := range v {
+				if m, ok := e.(map[string]any); ok {
+					e = maskSecrets(m)
+				}
+				list[i] = e
+			}
+			out[k] = list
+		default:
+			out[k] = v
+			if isSecret(k) && fmt.Sprint(v) != "" {
+				out[k] = masked
+			}
+		}
 	}
+	return out
+}
 
-	if fileInfo, err := file.Stat(); err == nil && fileInfo.Size() > 10<<20 {
-		file.Truncate(0)
-		file.Seek(0, 0)
+func isSecret(key string) bool {
+	key = strings.ToLower(key)
+	for _, s := range secretKeys {
+		if strings.Contains(key, s) {
+			return true
+		}
 	}
-
-	fileSync := zapcore.AddSync(file)
-
-	productionConfig := zap.NewProductionConfig()
-	productionConfig.EncoderConfig.TimeKey = "time"
-	productionConfig.EncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
-	productionConfig.EncoderConfig.CallerKey = "caller"
-	productionConfig.EncoderConfig.EncodeCaller = cjaller
-	productionConfig.EncoderConfig.EncodeLevel = zapcore.CapitalLevelEncoder
-
-	devConfig := zap.NewDevelopmentConfig()
-	devConfig.EncoderConfig.TimeKey = "time"
-	devConfig.EncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
-	devConfig.EncoderConfig.CallerKey = "caller"
-	devConfig.EncoderConfig.EncodeCaller = zapcore.ShortCallerEncoder
-	devConfig.EncoderConfig.EncodeLevel = zapcore.CapitalColorLevelEncoder
-
-	consoleEncoder := zapcore.NewConsoleEncoder(devConfig.EncoderConfig)
-	fileEncoder := zapcore.NewJSONEncoder(productionConfig.EncoderConfig)
-
-	return zapcore.NewTee(
-		zapcore.NewCore(consoleEncoder, stdout, level),
-		zapcore.NewCore(fileEncoder, fileSync, level),
-	)
-
+	return false
 }
@@ -0,0 +1,133 @@
+package config
+
+import (
+	"math"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// defaults holds a value for every setting, so a config file only needs to
+// list what differs from them.
+var defaults = map[string]any{
+	"env":       Local,
+	"log_level": "info",
+
+	"log.sinks": []any{
+		map[string]any{"type": "stdout", "format": "console"},
+		map[string]any{
+			"type":         "file",
+			"format":       "json",
+			"path":         "./logs/app.json",
+			"max_size_mb":  10,
+			"max_backups":  5,
+			"max_age_days": 14,
+			"compress":     true,
+		},
+	},
+	"log.payloads.mode":          "off",
+	"log.payloads.preview_bytes": 32,
+	"log.sampling.tick":          time.Second,
+	"log.sampling.initial":       100,
+	"log.sampling.thereafter":    100,
+
+	"access_log.enabled": false,
+	"access_log.sinks": []any{
+		map[string]any{
+			"type":         "file",
+			"format":       "json",
+			"path":         "./logs/access.json",
+			"max_size_mb":  100,
+			"max_backups":  10,
+			"max_age_days": 90,
+			"compress":     true,
+		},
+	},
+	"access_log.audit":       false,
+	"access_log.audit_queue": 1024,
+
+	"server.host":                     "localhost",
+	"server.port":                     "50051",
+	"server.max_streams":              100,
+	"server.write_buffer_size":        32 << 10,
+	"server.read_buffer_size":         32 << 10,
+	"server.initial_window_size":      minWindowSize,
+	"server.initial_conn_window_size": minWindowSize,
+	"server.max_header_list_size":     8 << 10,
+	"server.max_recv_msg_size":        4 << 20,
+	"server.max_send_msg_size":        math.MaxInt32,
+	"server.connection_timeout":       120 * time.Second,
+	"server.num_stream_workers":       0,
+
+	"server.keepalive.max_connection_idle":      0,
+	"server.keepalive.max_connection_age":       0,
+	"server.keepalive.max_connection_age_grace": 0,
+	"server.keepalive.time":                     2 * time.Hour,
+	"server.keepalive.timeout":                  20 * time.Second,
+	"server.keepalive.min_time":                 5 * time.Minute,
+	"server.keepalive.permit_without_stream":    false,
+
+	"server.reflection":    false,
+	"server.drain_timeout": 10 * time.Second,
+
+	"admin.host":                  "localhost",
+	"admin.port":                  "8081",
+	"admin.health_check_interval": 5 * time.Second,
+
+	"db.host":                "localhost",
+	"db.port":                5432,
+	"db.user":                "postgres",
+	"db.password":            "",
+	"db.db_name":             "postgres",
+	"db.ssl_mode":            "disable",
+	"db.auto_migrate":        true,
+	"db.migrations_dir":      "",
+	"db.max_conns":           10,
+	"db.min_conns":           0,
+	"db.max_conn_lifetime":   time.Hour,
+	"db.max_conn_idle_time":  30 * time.Minute,
+	"db.health_check_period": time.Minute,
+	"db.connect_attempts":    5,
+	"db.connect_backoff":     500 * time.Millisecond,
+	"db.connect_timeout":     10 * time.Second,
+
+	"limits.messages_per_second":      0,
+	"limits.burst":                    0,
+	"limits.max_payload_bytes":        0,
+	"limits.max_transaction_objects":  10000,
+	"limits.max_transaction_bytes":    64 << 20,
+	"limits.transaction_idle_timeout": 30 * time.Second,
+	"limits.transaction_timeout":      5 * time.Minute,
+
+	"events.enabled":       true,
+	"events.buffer":        256,
+	"events.poll_interval": 5 * time.Second,
+	"events.retention":     24 * time.Hour,
+
+	"queue.visibility_timeout":     30 * time.Second,
+	"queue.max_visibility_timeout": 12 * time.Hour,
+	"queue.prefetch":               10,
+	"queue.max_prefetch":           1000,
+	"queue.poll_interval":          time.Second,
+
+	"webhooks.endpoints":     []any{},
+	"webhooks.workers":       8,
+	"webhooks.poll_interval": 5 * time.Second,
+	"webhooks.timeout":       10 * time.Second,
+	"webhooks.max_attempts":  10,
+	"webhooks.min_backoff":   time.Second,
+	"webhooks.max_backoff":   10 * time.Minute,
+
+	"tracing.enabled":      false,
+	"tracing.exporter":     "stdout",
+	"tracing.endpoint":     "localhost:4317",
+	"tracing.insecure":     false,
+	"tracing.service_name": "potoc",
+	"tracing.sample_ratio": 1.0,
+}
+
+func setDefaults(v *viper.Viper) {
+	for k, val := range defaults {
+		v.SetDefault(k, val)
+	}
+}
@@ -0,0 +1,370 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/NikoMalik/potoc/internal/logger"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+var (
+	sinkTypes    = []string{"stdout", "stderr", "file", "none"}
+	sinkFormats  = []string{"console", "json"}
+	payloadModes = []string{"off", "sizes", "hashed", "preview"}
+)
+
+// Log lists where log entries are written. Every sink receives every entry
+// at or above LogLevel.
+type Log struct {
+	Sinks    []Sink    `mapstructure:"sinks"`
+	Payloads *Payloads `mapstructure:"payloads"`
+	Sampling *Sampling `mapstructure:"sampling"`
+}
+
+// AccessLog is the audit record of every call, kept apart from the
+// application log. Entries are always written at info level, whatever
+// LogLevel says.
+type AccessLog struct {
+	Enabled bool   `mapstructure:"enabled"`
+	Sinks   []Sink `mapstructure:"sinks"`
+	// Audit also stores every entry in the access_log table.
+	Audit bool `mapstructure:"audit"`
+	// AuditQueue is how many entries may wait for the database before new
+	// ones are dropped from the table. They are still written to Sinks.
+	AuditQueue int `mapstructure:"audit_queue"`
+
+	Logger *zap.Logger `mapstructure:"-"`
+}
+
+// Payloads is the redaction policy for message payloads in debug logs.
+type Payloads struct {
+	// Mode is off, sizes, hashed or preview.
+	Mode string `mapstructure:"mode"`
+	// PreviewBytes is how much of each payload preview mode shows.
+	PreviewBytes int `mapstructure:"preview_bytes"`
+}
+
+// Sampling thins out debug entries: within each Tick the first Initial
+// entries with the same message are written, then every Thereafter-th.
+// Entries above debug are never sampled. Initial 0 disables sampling.
+type Sampling struct {
+	Tick       time.Duration `mapstructure:"tick"`
+	Initial    int           `mapstructure:"initial"`
+	Thereafter int           `mapstructure:"thereafter"`
+}
+
+// Sink is one log destination.
+type Sink struct {
+	// Type is stdout, stderr, file or none.
+	Type string `mapstructure:"type"`
+	// Format is console (human readable, colored on terminals) or json.
+	Format string `mapstructure:"format"`
+
+	// The settings below only apply to file sinks.
+	Path string `mapstructure:"path"`
+	// MaxSizeMB rotates the file once it grows past this size.
+	MaxSizeMB int `mapstructure:"max_size_mb"`
+	// RotateInterval also rotates the file on a fixed schedule. Zero
+	// rotates by size only.
+	RotateInterval time.Duration `mapstructure:"rotate_interval"`
+	// MaxBackups and MaxAgeDays bound the rotated files kept on disk. Zero
+	// keeps them all.
+	MaxBackups int  `mapstructure:"max_backups"`
+	MaxAgeDays int  `mapstructure:"max_age_days"`
+	Compress   bool `mapstructure:"compress"`
+}
+
+func (l *Log) validate(p *problems) {
+	for i, s := range l.Sinks {
+		s.validate(p, fmt.Sprintf("log.sinks[%d]", i))
+	}
+	if pl := l.Payloads; pl != nil {
+		if !slices.Contains(payloadModes, pl.Mode) {
+			p.add("log.payloads.mode: %q is not one of %v", pl.Mode, payloadModes)
+		}
+		if pl.Mode == "preview" && pl.PreviewBytes <= 0 {
+			p.add("log.payloads.preview_bytes: must be positive in preview mode, got %d", pl.PreviewBytes)
+		}
+	}
+	if s := l.Sampling; s != nil && s.Initial != 0 {
+		if s.Initial < 0 {
+			p.add("log.sampling.initial: must not be negative, got %d", s.Initial)
+		}
+		if s.Thereafter < 0 {
+			p.add("log.sampling.thereafter: must not be negative, got %d", s.Thereafter)
+		}
+		if s.Tick <= 0 {
+			p.add("log.sampling.tick: must be positive, got %s", s.Tick)
+		}
+	}
+}
+
+func (a *AccessLog) validate(p *problems) {
+	if !a.Enabled {
+		return
+	}
+	for i, s := range a.Sinks {
+		s.validate(p, fmt.Sprintf("access_log.sinks[%d]", i))
+	}
+	if a.Audit && a.AuditQueue <= 0 {
+		p.add("access_log.audit_queue: must be positive, got %d", a.AuditQueue)
+	}
+}
+
+func (s *Sink) validate(p *problems, key string) {
+	if !slices.Contains(sinkTypes, s.Type) {
+		p.add("%s.type: %q is not one of %v", key, s.Type, sinkTypes)
+	}
+	if s.Type == "none" {
+		return
+	}
+	if !slices.Contains(sinkFormats, s.Format) {
+		p.add("%s.format: %q is not one of %v", key, s.Format, sinkFormats)
+	}
+	if s.Type != "file" {
+		return
+	}
+	if s.Path == "" {
+		p.add("%s.path: must be set for a file sink", key)
+	}
+	if s.MaxSizeMB <= 0 {
+		p.add("%s.max_size_mb: must be positive, got %d", key, s.MaxSizeMB)
+	}
+	if s.RotateInterval < 0 {
+		p.add("%s.rotate_interval: must not be negative, got %s", key, s.RotateInterval)
+	}
+	if s.MaxBackups < 0 {
+		p.add("%s.max_backups: must not be negative, got %d", key, s.MaxBackups)
+	}
+	if s.MaxAgeDays < 0 {
+		p.add("%s.max_age_days: must not be negative, got %d", key, s.MaxAgeDays)
+	}
+}
+
+func cjaller(caller zapcore.EntryCaller, enc zapcore.PrimitiveArrayEncoder) {
+	enc.AppendString(filepath.Base(caller.FullPath()))
+}
+
+func getAtomicLevel(config *Config) zap.AtomicLevel {
+	var level zapcore.Level
+	if err := level.Set(config.LogLevel); err != nil {
+		log.Fatalf("failed to init log Level: %v", err)
+	}
+	logLevel.SetLevel(level)
+
+	return logLevel
+}
+
+// initLog builds one core per configured sink. A config without sinks, or
+// with only none sinks, discards every entry.
+func initLog(cfg *Log, level zap.AtomicLevel, files *logFiles) (zapcore.Core, error) {
+	if cfg == nil {
+		return zapcore.NewNopCore(), nil
+	}
+
+	core, err := newSinkCore(cfg.Sinks, level, files)
+	if err != nil {
+		return nil, err
+	}
+	if s := cfg.Sampling; s != nil && s.Initial > 0 {
+		core = &debugSampler{
+			Core:    core,
+			sampled: zapcore.NewSamplerWithOptions(core, s.Tick, s.Initial, s.Thereafter),
+		}
+	}
+	return core, nil
+}
+
+// initAccessLog builds the access logger, nil when the access log is off.
+func initAccessLog(cfg *AccessLog, files *logFiles) (*zap.Logger, error) {
+	if cfg == nil || !cfg.Enabled {
+		return nil, nil
+	}
+	core, err := newSinkCore(cfg.Sinks, zapcore.InfoLevel, files)
+	if err != nil {
+		return nil, err
+	}
+	return zap.New(core), nil
+}
+
+// newSinkCore adds the files it opens to files.
+func newSinkCore(sinks []Sink, level zapcore.LevelEnabler, files *logFiles) (zapcore.Core, error) {
+	cores := make([]zapcore.Core, 0, len(sinks))
+	for _, s := range sinks {
+		var ws zapcore.WriteSyncer
+		switch s.Type {
+		case "none":
+			continue
+		case "stdout":
+			ws = zapcore.Lock(os.Stdout)
+		case "stderr":
+			ws = zapcore.Lock(os.Stderr)
+		case "file":
+			w, err := openLogFile(s)
+			if err != nil {
+				return nil, err
+			}
+			*files = append(*files, w)
+			ws = zapcore.AddSync(w)
+		default:
+			return nil, fmt.Errorf("log sink: unknown type %q", s.Type)
+		}
+		cores = append(cores, zapcore.NewCore(newEncoder(s), ws, level))
+	}
+
+	return zapcore.NewTee(cores...), nil
+}
+
+// payloadPolicy converts the config to the policy the interceptors use.
+func payloadPolicy(cfg *Log) logger.PayloadPolicy {
+	if cfg == nil || cfg.Payloads == nil {
+		return logger.PayloadPolicy{Mode: logger.PayloadOff}
+	}
+	return logger.PayloadPolicy{
+		Mode:         logger.PayloadMode(cfg.Payloads.Mode),
+		PreviewBytes: cfg.Payloads.PreviewBytes,
+	}
+}
+
+// debugSampler routes debug entries through a sampler and writes every
+// other entry unsampled, so bursts of per-message logs cannot crowd out
+// warnings and errors.
+type debugSampler struct {
+	zapcore.Core
+	sampled zapcore.Core
+}
+
+func (c *debugSampler) With(fields []zapcore.Field) zapcore.Core {
+	return &debugSampler{
+		Core:    c.Core.With(fields),
+		sampled: c.sampled.With(fields),
+	}
+}
+
+func (c *debugSampler) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if ent.Level <= zapcore.DebugLevel {
+		return c.sampled.Check(ent, ce)
+	}
+	return c.Core.Check(ent, ce)
+}
+
+func newEncoder(s Sink) zapcore.Encoder {
+	if s.Format == "json" {
+		productionConfig := zap.NewProductionConfig()
+		productionConfig.EncoderConfig.TimeKey = "time"
+		productionConfig.EncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+		productionConfig.EncoderConfig.CallerKey = "caller"
+		productionConfig.EncoderConfig.EncodeCaller = cjaller
+		productionConfig.EncoderConfig.EncodeLevel = zapcore.CapitalLevelEncoder
+
+		return zapcore.NewJSONEncoder(productionConfig.EncoderConfig)
+	}
+
+	devConfig := zap.NewDevelopmentConfig()
+	devConfig.EncoderConfig.TimeKey = "time"
+	devConfig.EncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+	devConfig.EncoderConfig.CallerKey = "caller"
+	devConfig.EncoderConfig.EncodeCaller = zapcore.ShortCallerEncoder
+	devConfig.EncoderConfig.EncodeLevel = zapcore.CapitalLevelEncoder
+	// Colors only help on a terminal; in files they are escape codes.
+	if s.Type != "file" {
+		devConfig.EncoderConfig.EncodeLevel = zapcore.CapitalColorLevelEncoder
+	}
+
+	return zapcore.NewConsoleEncoder(devConfig.EncoderConfig)
+}
+
+// logFile is a log file sink. Close stops its rotation schedule.
+type logFile struct {
+	*lumberjack.Logger
+	stop chan struct{}
+	once sync.Once
+}
+
+func (f *logFile) Close() error {
+	f.once.Do(func() { close(f.stop) })
+	return f.Logger.Close()
+}
+
+// logFiles are the files opened for a set of loggers.
+type logFiles []*logFile
+
+func (fs logFiles) close() error {
+	var errs []error
+	for _, f := range fs {
+		errs = append(errs, f.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// openLogs are the files of the loggers NewConfig set up last.
+var openLogs struct {
+	sync.Mutex
+	files logFiles
+}
+
+// swapLogFiles records files as the ones in use and closes the previous
+// ones.
+func swapLogFiles(files logFiles) error {
+	openLogs.Lock()
+	prev := openLogs.files
+	openLogs.files = files
+	openLogs.Unlock()
+	return prev.close()
+}
+
+// CloseLogs closes the file sinks and stops their rotation. Call it last,
+// once nothing logs anymore.
+func CloseLogs() error {
+	return swapLogFiles(nil)
+}
+
+// openLogFile returns a writer that rotates s.Path by size and, if
+// RotateInterval is set, on a schedule until it is closed. Rotated files
+// are renamed with a timestamp and kept according to MaxBackups and
+// MaxAgeDays.
+func openLogFile(s Sink) (*logFile, error) {
+	if err := os.MkdirAll(filepath.Dir(s.Path), 0755); err != nil {
+		return nil, fmt.Errorf("log sink %s: %w", s.Path, err)
+	}
+
+	w := &logFile{
+		Logger: &lumberjack.Logger{
+			Filename:   s.Path,
+			MaxSize:    s.MaxSizeMB,
+			MaxBackups: s.MaxBackups,
+			MaxAge:     s.MaxAgeDays,
+			Compress:   s.Compress,
+			LocalTime:  true,
+		},
+		stop: make(chan struct{}),
+	}
+
+	if s.RotateInterval > 0 {
+		ticker := time.NewTicker(s.RotateInterval)
+		go func() {
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					if err := w.Rotate(); err != nil {
+						fmt.Fprintf(os.Stderr, "log sink %s: rotate: %v\n", s.Path, err)
+					}
+				case <-w.stop:
+					return
+				}
+			}
+		}()
+	}
+
+	return w, nil
+}
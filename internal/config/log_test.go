@@ -0,0 +1,176 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestNewSinkCore(t *testing.T) {
+	dir := t.TempDir()
+	file := func(path string) Sink {
+		return Sink{Type: "file", Format: "json", Path: filepath.Join(dir, path), MaxSizeMB: 1}
+	}
+
+	tests := []struct {
+		name    string
+		sinks   []Sink
+		wantErr bool
+	}{
+		{name: "none", sinks: []Sink{{Type: "none"}}},
+		{name: "file", sinks: []Sink{file("app.json")}},
+		{name: "file in a new directory", sinks: []Sink{file("a/b/app.json")}},
+		{name: "two files", sinks: []Sink{file("one.json"), {Type: "none"}, file("two.json")}},
+		{name: "unknown type", sinks: []Sink{{Type: "syslog"}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var files logFiles
+			core, err := newSinkCore(tt.sinks, zapcore.InfoLevel, &files)
+			t.Cleanup(func() { files.close() })
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("no error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			zap.New(core).Info("hello", zap.String("sink", tt.name))
+			var paths []string
+			for _, s := range tt.sinks {
+				if s.Type == "file" {
+					paths = append(paths, s.Path)
+				}
+			}
+			if len(files) != len(paths) {
+				t.Fatalf("opened %d files, want %d", len(files), len(paths))
+			}
+			for _, path := range paths {
+				data, err := os.ReadFile(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var entry map[string]any
+				if err := json.Unmarshal(data, &entry); err != nil {
+					t.Fatalf("%s: %v: %s", path, err, data)
+				}
+				if entry["msg"] != "hello" || entry["sink"] != tt.name || entry["level"] != "INFO" {
+					t.Errorf("%s: got entry %v", path, entry)
+				}
+			}
+		})
+	}
+}
+
+func TestLogFileRotation(t *testing.T) {
+	dir := t.TempDir()
+	countFiles := func() int {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(entries)
+	}
+
+	f, err := openLogFile(Sink{Path: filepath.Join(dir, "app.log"), MaxSizeMB: 1, RotateInterval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("entry\n")); err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); countFiles() < 2; {
+		if time.Now().After(deadline) {
+			t.Fatal("file not rotated on schedule")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// A rotation after Close would reopen the file and add another.
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	n := countFiles()
+	time.Sleep(50 * time.Millisecond)
+	if got := countFiles(); got != n {
+		t.Errorf("%d files after Close, then %d: rotation kept running", n, got)
+	}
+}
+
+func TestSwapLogFiles(t *testing.T) {
+	dir := t.TempDir()
+	open := func(name string) logFiles {
+		f, err := openLogFile(Sink{Path: filepath.Join(dir, name), MaxSizeMB: 1, RotateInterval: time.Hour})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return logFiles{f}
+	}
+	stopped := func(f *logFile) bool {
+		select {
+		case <-f.stop:
+			return true
+		default:
+			return false
+		}
+	}
+
+	first, second := open("first.log"), open("second.log")
+	swapLogFiles(first)
+	swapLogFiles(second)
+	if !stopped(first[0]) || stopped(second[0]) {
+		t.Fatal("swapping did not close only the previous files")
+	}
+	if err := CloseLogs(); err != nil {
+		t.Fatal(err)
+	}
+	if !stopped(second[0]) {
+		t.Fatal("CloseLogs left the files open")
+	}
+}
+
+func TestDebugSampler(t *testing.T) {
+	tests := []struct {
+		name  string
+		level zapcore.Level
+		with  bool
+		want  int
+	}{
+		{name: "debug sampled", level: zapcore.DebugLevel, want: 2},
+		{name: "debug sampled with fields", level: zapcore.DebugLevel, with: true, want: 2},
+		{name: "info kept", level: zapcore.InfoLevel, want: 5},
+		{name: "warn kept", level: zapcore.WarnLevel, want: 5},
+		{name: "error kept", level: zapcore.ErrorLevel, want: 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			core, logs := observer.New(zapcore.DebugLevel)
+			log := zap.New(&debugSampler{
+				Core:    core,
+				sampled: zapcore.NewSamplerWithOptions(core, time.Hour, 2, 0),
+			})
+			if tt.with {
+				log = log.With(zap.String("stream", "1"))
+			}
+			for range 5 {
+				if ce := log.Check(tt.level, "message"); ce != nil {
+					ce.Write()
+				}
+			}
+			if got := logs.Len(); got != tt.want {
+				t.Errorf("wrote %d of 5 entries, want %d", got, tt.want)
+			}
+		})
+	}
+}
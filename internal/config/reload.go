@@ -0,0 +1,197 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/NikoMalik/potoc/internal/logger"
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// logLevel is the level shared by every log core, so it can be changed
+// without rebuilding the logger.
+var logLevel = zap.NewAtomicLevel()
+
+// reloadable are the settings Watch applies to a running server. A key
+// ending in "." covers the whole section.
+var reloadable = []string{"log_level", "log.payloads.", "limits."}
+
+// Change is a setting that differs between two configs.
+type Change struct {
+	Key string
+	Old string
+	New string
+}
+
+func (c Change) String() string {
+	return fmt.Sprintf("%s: %s -> %s", c.Key, c.Old, c.New)
+}
+
+// Reloadable reports whether the setting can change without a restart.
+func (c Change) Reloadable() bool {
+	for _, r := range reloadable {
+		if c.Key == r || strings.HasSuffix(r, ".") && strings.HasPrefix(c.Key, r) {
+			return true
+		}
+	}
+	return false
+}
+
+// Diff lists the settings that differ between old and new, secrets masked.
+func Diff(old, new *Config) []Change {
+	var changes []Change
+	diff("", reflect.ValueOf(old), reflect.ValueOf(new), &changes)
+	return changes
+}
+
+func diff(key string, a, b reflect.Value, changes *[]Change) {
+	if a.Kind() == reflect.Pointer {
+		if a.IsNil() || b.IsNil() {
+			if a.IsNil() != b.IsNil() {
+				*changes = append(*changes, Change{Key: strings.TrimSuffix(key, "."), Old: present(a), New: present(b)})
+			}
+			return
+		}
+		a, b = a.Elem(), b.Elem()
+	}
+
+	if a.Kind() != reflect.Struct {
+		if !reflect.DeepEqual(a.Interface(), b.Interface()) {
+			c := Change{Key: key, Old: fmt.Sprint(a.Interface()), New: fmt.Sprint(b.Interface())}
+			if isSecret(key) {
+				c.Old, c.New = masked, masked
+			}
+			*changes = append(*changes, c)
+		}
+		return
+	}
+
+	t := a.Type()
+	for i := 0; i < t.NumField(); i++ {
+		tag := t.Field(i).Tag.Get("mapstructure")
+		if tag == "" || tag == "-" {
+			continue
+		}
+		name := key + tag
+		if f := a.Field(i); f.Kind() == reflect.Pointer || f.Kind() == reflect.Struct {
+			name += "."
+		}
+		diff(name, a.Field(i), b.Field(i), changes)
+	}
+}
+
+func present(v reflect.Value) string {
+	if v.IsNil() {
+		return "<unset>"
+	}
+	return "<set>"
+}
+
+// reload returns current with the reloadable settings of next, and the
+// changes it applied and rejected.
+func reload(current, next *Config) (live *Config, applied, rejected []string) {
+	for _, c := range Diff(current, next) {
+		if c.Reloadable() {
+			applied = append(applied, c.String())
+		} else {
+			rejected = append(rejected, c.String())
+		}
+	}
+
+	l := *current
+	l.LogLevel = next.LogLevel
+	l.Limits = next.Limits
+	if current.Log != nil && next.Log != nil {
+		log := *current.Log
+		log.Payloads = next.Log.Payloads
+		l.Log = &log
+	}
+	return &l, applied, rejected
+}
+
+// Watch reloads the config file whenever it changes, until stop is called.
+// Reloadable settings are applied: the log level directly, everything else
+// by passing apply a copy of current with only those settings updated.
+// Other changes are logged and ignored until restart, as are files that
+// fail validation.
+func Watch(current *Config, apply func(*Config)) (stop func() error, err error) {
+	file := filepath.Clean(viper.ConfigFileUsed())
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	// The directory is watched, so that files saved by rename and replaced
+	// symlinks, e.g. of a k8s ConfigMap, are seen too.
+	if err := watcher.Add(filepath.Dir(file)); err != nil {
+		watcher.Close()
+		return nil, err
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		target, _ := filepath.EvalSymlinks(file)
+		for {
+			select {
+			case e, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				resolved, _ := filepath.EvalSymlinks(file)
+				written := filepath.Clean(e.Name) == file && e.Has(fsnotify.Write|fsnotify.Create)
+				if !written && (resolved == "" || resolved == target) {
+					continue
+				}
+				target = resolved
+				current = reloadFile(current, e.Name, apply)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				logger.Warn("Config watcher failed", zap.Error(err))
+			}
+		}
+	}()
+
+	return func() error {
+		err := watcher.Close()
+		<-done
+		return err
+	}, nil
+}
+
+// reloadFile reads the changed config file and applies it on top of
+// current, see Watch. It returns the config now live.
+func reloadFile(current *Config, name string, apply func(*Config)) *Config {
+	if err := viper.ReadInConfig(); err != nil {
+		logger.Error("Config reload rejected", zap.String("file", name), zap.Error(err))
+		return current
+	}
+	next, err := decode()
+	if err != nil {
+		logger.Error("Config reload rejected", zap.String("file", name), zap.Error(err))
+		return current
+	}
+
+	live, applied, rejected := reload(current, next)
+	if len(rejected) > 0 {
+		logger.Warn("Config changes need a restart, ignored", zap.Strings("changes", rejected))
+	}
+	if len(applied) == 0 {
+		return current
+	}
+
+	if err := logLevel.UnmarshalText([]byte(live.LogLevel)); err != nil {
+		logger.Error("Config reload rejected", zap.Error(err))
+		return current
+	}
+	logger.SetPayloadPolicy(payloadPolicy(live.Log))
+	apply(live)
+
+	logger.Info("Config reloaded", zap.String("file", name), zap.Strings("changes", applied))
+	return live
+}
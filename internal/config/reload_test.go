@@ -0,0 +1,140 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func mustDecode(t *testing.T, doc string) *Config {
+	t.Helper()
+	c, err := decodeYAML(t, doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name     string
+		old, new string
+		want     []string
+	}{
+		{name: "same"},
+		{name: "value", new: "log_level: debug\n", want: []string{"log_level: info -> debug"}},
+		{
+			name: "nested",
+			old:  "limits:\n  burst: 1\n",
+			new:  "limits:\n  burst: 2\nserver:\n  drain_timeout: 1m\n",
+			want: []string{"server.drain_timeout: 10s -> 1m0s", "limits.burst: 1 -> 2"},
+		},
+		{
+			name: "secret masked",
+			old:  "db:\n  password: a\n",
+			new:  "db:\n  password: b\n",
+			want: []string{"db.password: ******** -> ********"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			old, new := mustDecode(t, tt.old), mustDecode(t, tt.new)
+			var got []string
+			for _, c := range Diff(old, new) {
+				got = append(got, c.String())
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReload(t *testing.T) {
+	current := mustDecode(t, "")
+	next := mustDecode(t, `
+log_level: debug
+log:
+  payloads:
+    preview_bytes: 8
+limits:
+  burst: 5
+server:
+  port: "6000"
+`)
+
+	live, applied, rejected := reload(current, next)
+
+	wantApplied := []string{"limits.burst: 0 -> 5", "log.payloads.preview_bytes: 32 -> 8", "log_level: info -> debug"}
+	if !slices.Equal(applied, wantApplied) {
+		t.Errorf("applied %q, want %q", applied, wantApplied)
+	}
+	if want := []string{"server.port: 50051 -> 6000"}; !slices.Equal(rejected, want) {
+		t.Errorf("rejected %q, want %q", rejected, want)
+	}
+
+	if live.LogLevel != "debug" || live.Limits.Burst != 5 || live.Log.Payloads.PreviewBytes != 8 {
+		t.Errorf("reloadable settings not applied: %s, %+v, %+v", live.LogLevel, live.Limits, live.Log.Payloads)
+	}
+	if live.Server.Port != "50051" {
+		t.Errorf("server.port changed to %s without a restart", live.Server.Port)
+	}
+	if current.LogLevel != "info" || current.Limits.Burst != 0 || current.Log.Payloads.PreviewBytes != 32 {
+		t.Error("reload changed the current config")
+	}
+}
+
+func TestWatch(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	setDefaults(viper.GetViper())
+	file := filepath.Join(t.TempDir(), "test.yaml")
+	write := func(doc string) {
+		t.Helper()
+		if err := os.WriteFile(file, []byte(doc), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("limits:\n  burst: 1\n")
+	viper.SetConfigFile(file)
+	if err := viper.ReadInConfig(); err != nil {
+		t.Fatal(err)
+	}
+	current, err := decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	applied := make(chan *Config, 10)
+	stop, err := Watch(current, func(live *Config) { applied <- live })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The file may be seen truncated first, so wait for the new value.
+	write("limits:\n  burst: 2\n")
+	timeout := time.After(5 * time.Second)
+	for burst := 0; burst != 2; {
+		select {
+		case live := <-applied:
+			burst = live.Limits.Burst
+		case <-timeout:
+			t.Fatal("change not applied")
+		}
+	}
+
+	if err := stop(); err != nil {
+		t.Fatal(err)
+	}
+	write("limits:\n  burst: 3\n")
+	select {
+	case live := <-applied:
+		t.Errorf("applied burst %d after stop", live.Limits.Burst)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
@@ -0,0 +1,344 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// minWindowSize is the smallest flow control window gRPC accepts; smaller
+// values are silently ignored by the server.
+const minWindowSize = 65535
+
+var (
+	sslModes     = []string{"disable", "allow", "prefer", "require", "verify-ca", "verify-full"}
+	exporters    = []string{"otlp", "stdout", "none"}
+	webhookKinds = []string{"created", "updated", "deleted"}
+)
+
+// ValidationError lists every problem found while loading a config.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid config (%d problem(s)):\n  - %s", len(e.Problems), strings.Join(e.Problems, "\n  - "))
+}
+
+// problems collects the messages of a validation, each starting with the
+// key it is about.
+type problems struct {
+	list []string
+	// unset are the keys whose environment variable is not set; other
+	// problems with them would only repeat that.
+	unset map[string]bool
+}
+
+func (p *problems) add(format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	if key, _, ok := strings.Cut(msg, ":"); ok && p.unset[key] {
+		return
+	}
+	p.list = append(p.list, msg)
+}
+
+// addUnset reports that key refers to the unset variable name.
+func (p *problems) addUnset(key, name string) {
+	p.add("%s: environment variable %s is not set", key, name)
+	if p.unset == nil {
+		p.unset = map[string]bool{}
+	}
+	p.unset[key] = true
+}
+
+// err returns the problems sorted, so that a config reports the same way
+// on every run.
+func (p *problems) err() error {
+	if len(p.list) == 0 {
+		return nil
+	}
+	list := slices.Clone(p.list)
+	slices.Sort(list)
+	return &ValidationError{Problems: list}
+}
+
+// Validate checks c and reports every problem at once.
+func (c *Config) Validate() error {
+	var p problems
+	c.validate(&p)
+	return p.err()
+}
+
+func (c *Config) validate(p *problems) {
+	if c.Env == "" {
+		p.add("env: must not be empty")
+	}
+	if _, err := zapcore.ParseLevel(c.LogLevel); err != nil {
+		p.add("log_level: %q is not one of debug, info, warn, error, dpanic, panic, fatal", c.LogLevel)
+	}
+
+	if c.Server == nil {
+		p.add("server: section is missing")
+	} else {
+		c.Server.validate(p)
+	}
+	if c.Admin != nil {
+		c.Admin.validate(p)
+	}
+	if c.DB == nil {
+		p.add("db: section is missing")
+	} else {
+		c.DB.validate(p)
+	}
+	if c.Tracing != nil {
+		c.Tracing.validate(p)
+	}
+	if c.Limits != nil {
+		c.Limits.validate(p)
+	}
+	if c.Log != nil {
+		c.Log.validate(p)
+	}
+	if c.AccessLog != nil {
+		c.AccessLog.validate(p)
+	}
+	if c.Events != nil {
+		c.Events.validate(p)
+	}
+	if c.Queue != nil {
+		c.Queue.validate(p)
+	}
+	if c.Webhooks != nil {
+		c.Webhooks.validate(p)
+	}
+}
+
+func (s *Server) validate(p *problems) {
+	validatePort(p, "server.port", s.Port, true)
+	if s.MaxStreams == 0 {
+		p.add("server.max_streams: must be at least 1")
+	}
+	if s.WriteBufferSize < 0 {
+		p.add("server.write_buffer_size: must not be negative, got %d", s.WriteBufferSize)
+	}
+	if s.ReadBufferSize < 0 {
+		p.add("server.read_buffer_size: must not be negative, got %d", s.ReadBufferSize)
+	}
+	if s.InitialWindowSize < minWindowSize {
+		p.add("server.initial_window_size: must be at least %d, got %d", minWindowSize, s.InitialWindowSize)
+	}
+	if s.InitialConnWindowSize < minWindowSize {
+		p.add("server.initial_conn_window_size: must be at least %d, got %d", minWindowSize, s.InitialConnWindowSize)
+	}
+	if s.MaxHeaderListSize == 0 {
+		p.add("server.max_header_list_size: must be at least 1")
+	}
+	if s.MaxRecvMsgSize <= 0 {
+		p.add("server.max_recv_msg_size: must be positive, got %d", s.MaxRecvMsgSize)
+	}
+	if s.MaxSendMsgSize <= 0 {
+		p.add("server.max_send_msg_size: must be positive, got %d", s.MaxSendMsgSize)
+	}
+	if s.ConnectionTimeout <= 0 {
+		p.add("server.connection_timeout: must be positive, got %s", s.ConnectionTimeout)
+	}
+	if s.Keepalive != nil {
+		s.Keepalive.validate(p)
+	}
+	if s.DrainTimeout <= 0 {
+		p.add("server.drain_timeout: must be positive, got %s", s.DrainTimeout)
+	}
+}
+
+func (k *Keepalive) validate(p *problems) {
+	durations := []struct {
+		key string
+		d   time.Duration
+	}{
+		{"max_connection_idle", k.MaxConnectionIdle},
+		{"max_connection_age", k.MaxConnectionAge},
+		{"max_connection_age_grace", k.MaxConnectionAgeGrace},
+		{"time", k.Time},
+		{"timeout", k.Timeout},
+		{"min_time", k.MinTime},
+	}
+	for _, d := range durations {
+		if d.d < 0 {
+			p.add("server.keepalive.%s: must not be negative, got %s", d.key, d.d)
+		}
+	}
+	if k.Time > 0 && k.Time < time.Second {
+		p.add("server.keepalive.time: must be at least 1s, got %s", k.Time)
+	}
+}
+
+func (a *Admin) validate(p *problems) {
+	validatePort(p, "admin.port", a.Port, false)
+	if a.HealthCheckInterval <= 0 {
+		p.add("admin.health_check_interval: must be positive, got %s", a.HealthCheckInterval)
+	}
+}
+
+func (d *DB) validate(p *problems) {
+	if d.Host == "" {
+		p.add("db.host: must not be empty")
+	}
+	if d.Port < 1 || d.Port > 65535 {
+		p.add("db.port: must be between 1 and 65535, got %d", d.Port)
+	}
+	if d.User == "" {
+		p.add("db.user: must not be empty")
+	}
+	if d.Name == "" {
+		p.add("db.db_name: must not be empty")
+	}
+	if !slices.Contains(sslModes, d.SSLMode) {
+		p.add("db.ssl_mode: %q is not one of %s", d.SSLMode, strings.Join(sslModes, ", "))
+	}
+	if d.MaxConns < 1 {
+		p.add("db.max_conns: must be at least 1, got %d", d.MaxConns)
+	}
+	if d.MinConns < 0 || d.MinConns > d.MaxConns {
+		p.add("db.min_conns: must be between 0 and max_conns (%d), got %d", d.MaxConns, d.MinConns)
+	}
+	if d.MaxConnLifetime < 0 || d.MaxConnIdleTime < 0 || d.HealthCheckPeriod < 0 {
+		p.add("db: max_conn_lifetime, max_conn_idle_time and health_check_period must not be negative")
+	}
+	if d.ConnectAttempts < 1 {
+		p.add("db.connect_attempts: must be at least 1, got %d", d.ConnectAttempts)
+	}
+	if d.ConnectBackoff < 0 {
+		p.add("db.connect_backoff: must not be negative, got %s", d.ConnectBackoff)
+	}
+	if d.ConnectTimeout <= 0 {
+		p.add("db.connect_timeout: must be positive, got %s", d.ConnectTimeout)
+	}
+}
+
+func (t *Tracing) validate(p *problems) {
+	if !slices.Contains(exporters, t.Exporter) {
+		p.add("tracing.exporter: %q is not one of %s", t.Exporter, strings.Join(exporters, ", "))
+	}
+	if t.Enabled && t.Exporter == "otlp" && t.Endpoint == "" {
+		p.add("tracing.endpoint: required by the otlp exporter")
+	}
+	if t.SampleRatio < 0 || t.SampleRatio > 1 {
+		p.add("tracing.sample_ratio: must be between 0 and 1, got %g", t.SampleRatio)
+	}
+}
+
+func (l *Limits) validate(p *problems) {
+	if l.MessagesPerSecond < 0 {
+		p.add("limits.messages_per_second: must not be negative, got %g", l.MessagesPerSecond)
+	}
+	if l.Burst < 0 {
+		p.add("limits.burst: must not be negative, got %d", l.Burst)
+	}
+	if l.MaxPayloadBytes < 0 {
+		p.add("limits.max_payload_bytes: must not be negative, got %d", l.MaxPayloadBytes)
+	}
+	if l.MaxTransactionObjects < 0 {
+		p.add("limits.max_transaction_objects: must not be negative, got %d", l.MaxTransactionObjects)
+	}
+	if l.MaxTransactionBytes < 0 {
+		p.add("limits.max_transaction_bytes: must not be negative, got %d", l.MaxTransactionBytes)
+	}
+	if l.TransactionIdleTimeout < 0 {
+		p.add("limits.transaction_idle_timeout: must not be negative, got %s", l.TransactionIdleTimeout)
+	}
+	if l.TransactionTimeout < 0 {
+		p.add("limits.transaction_timeout: must not be negative, got %s", l.TransactionTimeout)
+	}
+}
+
+func (e *Events) validate(p *problems) {
+	if e.Buffer < 1 {
+		p.add("events.buffer: must be at least 1, got %d", e.Buffer)
+	}
+	if e.PollInterval <= 0 {
+		p.add("events.poll_interval: must be positive, got %s", e.PollInterval)
+	}
+	if e.Retention <= 0 {
+		p.add("events.retention: must be positive, got %s", e.Retention)
+	}
+}
+
+func (q *Queue) validate(p *problems) {
+	if q.VisibilityTimeout <= 0 || q.VisibilityTimeout > q.MaxVisibilityTimeout {
+		p.add("queue.visibility_timeout: must be between 0 and max_visibility_timeout (%s), got %s", q.MaxVisibilityTimeout, q.VisibilityTimeout)
+	}
+	if q.Prefetch < 1 || q.Prefetch > q.MaxPrefetch {
+		p.add("queue.prefetch: must be between 1 and max_prefetch (%d), got %d", q.MaxPrefetch, q.Prefetch)
+	}
+	if q.PollInterval <= 0 {
+		p.add("queue.poll_interval: must be positive, got %s", q.PollInterval)
+	}
+}
+
+func (w *Webhooks) validate(p *problems) {
+	if w.Workers < 1 {
+		p.add("webhooks.workers: must be at least 1, got %d", w.Workers)
+	}
+	if w.PollInterval <= 0 {
+		p.add("webhooks.poll_interval: must be positive, got %s", w.PollInterval)
+	}
+	if w.Timeout <= 0 {
+		p.add("webhooks.timeout: must be positive, got %s", w.Timeout)
+	}
+	if w.MaxAttempts < 1 {
+		p.add("webhooks.max_attempts: must be at least 1, got %d", w.MaxAttempts)
+	}
+	if w.MinBackoff <= 0 || w.MinBackoff > w.MaxBackoff {
+		p.add("webhooks.min_backoff: must be between 0 and max_backoff (%s), got %s", w.MaxBackoff, w.MinBackoff)
+	}
+
+	names := make(map[string]bool, len(w.Endpoints))
+	for i, e := range w.Endpoints {
+		key := fmt.Sprintf("webhooks.endpoints[%d]", i)
+		if e.Name == "" || strings.Trim(e.Name, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_.") != "" {
+			p.add("%s.name: %q must be non-empty and hold only letters, digits, '-', '_' and '.'", key, e.Name)
+		} else if names[e.Name] {
+			p.add("%s.name: %q is used by another endpoint", key, e.Name)
+		}
+		names[e.Name] = true
+
+		if u, err := url.Parse(e.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			p.add("%s.url: %q is not an http or https URL", key, e.URL)
+		}
+		if e.Secret == "" {
+			p.add("%s.secret: must not be empty", key)
+		}
+		for _, k := range e.Kinds {
+			if !slices.Contains(webhookKinds, k) {
+				p.add("%s.kinds: %q is not one of %s", key, k, strings.Join(webhookKinds, ", "))
+			}
+		}
+		for _, l := range e.Labels {
+			if k, _, ok := strings.Cut(l, "="); !ok || k == "" {
+				p.add("%s.labels: %q is not key=value", key, l)
+			}
+		}
+		if e.Timeout < 0 || e.MaxAttempts < 0 || e.MinBackoff < 0 || e.MaxBackoff < 0 {
+			p.add("%s: timeout, max_attempts, min_backoff and max_backoff must not be negative", key)
+		} else if r := w.WithDefaults(e); r.MinBackoff > r.MaxBackoff {
+			p.add("%s.min_backoff: must not exceed max_backoff (%s), got %s", key, r.MaxBackoff, r.MinBackoff)
+		}
+	}
+}
+
+// validatePort checks that port is a TCP port number. An empty port is
+// accepted when the listener is optional.
+func validatePort(p *problems, key, port string, required bool) {
+	if port == "" && !required {
+		return
+	}
+	n, err := strconv.Atoi(port)
+	if err != nil || n < 1 || n > 65535 {
+		p.add("%s: must be a port between 1 and 65535, got %q", key, port)
+	}
+}
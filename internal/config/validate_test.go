@@ -0,0 +1,101 @@
+package config
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+// decodeYAML decodes doc on top of the defaults, like OpenLoad does with a
+// config file.
+func decodeYAML(t *testing.T, doc string) (*Config, error) {
+	t.Helper()
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	setDefaults(viper.GetViper())
+	viper.SetConfigType("yaml")
+	if err := viper.ReadConfig(strings.NewReader(doc)); err != nil {
+		t.Fatal(err)
+	}
+	return decode()
+}
+
+func TestValidate(t *testing.T) {
+	t.Setenv("POTOC_TEST_PORT", "")
+
+	tests := []struct {
+		name string
+		doc  string
+		// want are the problems reported, each given by its start.
+		want []string
+	}{
+		{name: "defaults"},
+		{
+			name: "every problem at once, sorted",
+			doc: `
+log_level: loud
+server:
+  max_streams: 0
+db:
+  port: 0
+  ssl_mode: sometimes
+`,
+			want: []string{
+				`db.port: must be between 1 and 65535, got 0`,
+				`db.ssl_mode: "sometimes" is not one of`,
+				`log_level: "loud" is not one of`,
+				`server.max_streams: must be at least 1`,
+			},
+		},
+		{
+			name: "unknown key",
+			doc:  "server:\n  prot: 1\n",
+			want: []string{"server.prot: unknown key"},
+		},
+		{
+			name: "wrong type",
+			doc:  "tracing:\n  sample_ratio: many\n",
+			want: []string{"cannot parse 'tracing.sample_ratio' as float"},
+		},
+		{
+			name: "unset variable reported once",
+			doc:  "server:\n  port: ${POTOC_TEST_PORT}\n",
+			want: []string{"server.port: environment variable POTOC_TEST_PORT is not set"},
+		},
+		{
+			name: "transaction limits",
+			doc:  "limits:\n  max_transaction_bytes: -1\n  transaction_timeout: -1s\n",
+			want: []string{
+				"limits.max_transaction_bytes: must not be negative, got -1",
+				"limits.transaction_timeout: must not be negative, got -1s",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := decodeYAML(t, tt.doc)
+			if tt.want == nil {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			var verr *ValidationError
+			if !errors.As(err, &verr) {
+				t.Fatalf("got %v, want a ValidationError", err)
+			}
+			if len(verr.Problems) != len(tt.want) {
+				t.Fatalf("got problems %q, want %q", verr.Problems, tt.want)
+			}
+			for i, want := range tt.want {
+				if !strings.HasPrefix(verr.Problems[i], want) {
+					t.Errorf("problem %d: got %q, want %q", i, verr.Problems[i], want)
+				}
+			}
+		})
+	}
+}
@@ -2,75 +2,194 @@ package database
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
-	"log"
 	"time"
 
+	"github.com/NikoMalik/potoc/internal/config"
+	"github.com/NikoMalik/potoc/internal/logger"
+	"github.com/NikoMalik/potoc/internal/migrations"
 	"github.com/golang-migrate/migrate/v4"
 	"github.com/golang-migrate/migrate/v4/database/postgres"
-	_ "github.com/golang-migrate/migrate/v4/source/file"
+	"github.com/golang-migrate/migrate/v4/source"
+	"github.com/golang-migrate/migrate/v4/source/file"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+	"go.uber.org/zap"
 
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/jackc/pgx/v5/stdlib"
 	_ "github.com/lib/pq"
-	"github.com/spf13/viper"
 )
 
-func NewDB() *pgxpool.Pool {
-	log.Println("connecting to database")
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	db, err := pgxpool.New(ctx, getDSN())
-	defer cancel()
-	if err != nil {
-		log.Fatal(err)
+const (
+	defaultConnectAttempts = 5
+	defaultConnectBackoff  = 500 * time.Millisecond
+	defaultConnectTimeout  = 10 * time.Second
+	maxConnectBackoff      = 30 * time.Second
+)
 
+var _errNoConfig = errors.New("database config is missing")
+
+// NewDB opens a pool to the database described by cfg and pings it. The
+// first connection is retried with exponential backoff, so potoc can start
+// before Postgres is up. The caller owns Close of the returned pool.
+func NewDB(ctx context.Context, cfg *config.DB) (*pgxpool.Pool, error) {
+	if cfg == nil {
+		return nil, _errNoConfig
 	}
-	err = db.Ping(ctx)
+
+	poolConfig, err := pgxpool.ParseConfig(DSN(cfg))
 	if err != nil {
-		log.Panic(err)
+		return nil, fmt.Errorf("parse database config: %w", err)
+	}
+	if cfg.MaxConns > 0 {
+		poolConfig.MaxConns = cfg.MaxConns
+	}
+	if cfg.MinConns > 0 {
+		poolConfig.MinConns = cfg.MinConns
+	}
+	if cfg.MaxConnLifetime > 0 {
+		poolConfig.MaxConnLifetime = cfg.MaxConnLifetime
+	}
+	if cfg.MaxConnIdleTime > 0 {
+		poolConfig.MaxConnIdleTime = cfg.MaxConnIdleTime
+	}
+	if cfg.HealthCheckPeriod > 0 {
+		poolConfig.HealthCheckPeriod = cfg.HealthCheckPeriod
+	}
 
+	attempts := cfg.ConnectAttempts
+	if attempts <= 0 {
+		attempts = defaultConnectAttempts
+	}
+	backoff := cfg.ConnectBackoff
+	if backoff <= 0 {
+		backoff = defaultConnectBackoff
+	}
+	timeout := cfg.ConnectTimeout
+	if timeout <= 0 {
+		timeout = defaultConnectTimeout
 	}
 
-	dr := stdlib.OpenDBFromPool(db)
+	for attempt := 1; ; attempt++ {
+		db, err := connect(ctx, poolConfig, timeout)
+		if err == nil {
+			logger.Info("Database successfully connected",
+				zap.String("host", cfg.Host),
+				zap.Int("port", cfg.Port),
+				zap.String("db_name", cfg.Name),
+			)
+			return db, nil
+		}
+		if attempt >= attempts {
+			return nil, fmt.Errorf("connect to database after %d attempts: %w", attempt, err)
+		}
+
+		logger.Warn("Database not reachable, retrying",
+			zap.Int("attempt", attempt),
+			zap.Duration("backoff", backoff),
+			zap.Error(err),
+		)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxConnectBackoff)
+	}
+}
 
-	driver, err := postgres.WithInstance(dr, &postgres.Config{})
-	if err != nil {
-		log.Fatal(err)
+func connect(ctx context.Context, poolConfig *pgxpool.Config, timeout time.Duration) (*pgxpool.Pool, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
 
+	db, err := pgxpool.NewWithConfig(ctx, poolConfig)
+	if err != nil {
+		return nil, err
 	}
+	if err := db.Ping(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// Migrator applies schema migrations through the pool it was created for.
+type Migrator struct {
+	*migrate.Migrate
+	db *sql.DB
+}
 
-	m, err := migrate.NewWithDatabaseInstance(
-		"file://internal/migrations/",
-		"postgres",
-		driver,
+// NewMigrator reads migrations from dir, or from the ones embedded in the
+// binary when dir is empty.
+func NewMigrator(pool *pgxpool.Pool, dir string) (*Migrator, error) {
+	var (
+		src source.Driver
+		err error
 	)
+	if dir == "" {
+		src, err = iofs.New(migrations.FS, ".")
+	} else {
+		src, err = (&file.File{}).Open("file://" + dir)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("load migrations: %w", err)
+	}
+
+	db := stdlib.OpenDBFromPool(pool)
+	driver, err := postgres.WithInstance(db, &postgres.Config{})
 	if err != nil {
-		log.Panic(err)
+		src.Close()
+		db.Close()
+		return nil, fmt.Errorf("open migration driver: %w", err)
+	}
 
+	m, err := migrate.NewWithInstance("migrations", src, "postgres", driver)
+	if err != nil {
+		driver.Close()
+		src.Close()
+		return nil, fmt.Errorf("init migrations: %w", err)
 	}
 
-	if err := m.Up(); err != nil && err != migrate.ErrNoChange {
-		log.Panic(err)
+	return &Migrator{Migrate: m, db: db}, nil
+}
+
+// Close releases the migration connection back to the pool. The pool itself
+// stays open.
+func (m *Migrator) Close() error {
+	srcErr, dbErr := m.Migrate.Close()
+	return errors.Join(srcErr, dbErr, m.db.Close())
+}
 
+// Migrate applies every pending migration, see NewMigrator for dir.
+func Migrate(pool *pgxpool.Pool, dir string) error {
+	m, err := NewMigrator(pool, dir)
+	if err != nil {
+		return err
 	}
+	defer m.Close()
 
-	version, dirty, _ := m.Version()
-	log.Printf("Loaded migrations: version=%d dirty=%t", version, dirty)
-	log.Printf("Dirty: %t", dirty)
+	if err := m.Up(); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		return fmt.Errorf("apply migrations: %w", err)
+	}
 
-	log.Println("Database successfully connected")
+	version, dirty, _ := m.Version()
+	logger.Info("Loaded migrations", zap.Uint("version", version), zap.Bool("dirty", dirty))
 
-	return db
+	return nil
 }
 
-func getDSN() string {
+// DSN renders cfg as a libpq keyword/value connection string.
+func DSN(cfg *config.DB) string {
 	return fmt.Sprintf("host=%s port=%d user=%s "+
 		"password=%s dbname=%s sslmode=%s ",
-		viper.GetString("DB_HOST"),
-		viper.GetInt("DB_PORT"),
-		viper.GetString("DB_USER"),
-		viper.GetString("DB_PASSWORD"),
-		viper.GetString("DB_NAME"),
-		viper.GetString("DB_SSLMODE"),
+		cfg.Host,
+		cfg.Port,
+		cfg.User,
+		cfg.Password,
+		cfg.Name,
+		cfg.SSLMode,
 	)
 }
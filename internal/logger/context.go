@@ -0,0 +1,108 @@
+package logger
+
+import (
+	"context"
+	"crypto/x509"
+
+	"github.com/NikoMalik/uuid"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// RequestIDKey is the metadata key a client may set to pick the request ID.
+// The server echoes the ID back in the response header under the same key.
+const RequestIDKey = "x-request-id"
+
+type (
+	loggerKey  struct{}
+	requestKey struct{}
+)
+
+// Request identifies the call a context belongs to.
+type Request struct {
+	ID   string
+	Peer string
+	// Principal is the subject of the verified client certificate, empty
+	// without mutual TLS.
+	Principal string
+}
+
+// RequestFromContext returns the request the interceptors attached to ctx.
+func RequestFromContext(ctx context.Context) (Request, bool) {
+	r, ok := ctx.Value(requestKey{}).(Request)
+	return r, ok
+}
+
+// FromContext returns the logger the interceptors attached to ctx, or the
+// global logger outside a request.
+func FromContext(ctx context.Context) *zap.Logger {
+	if l, ok := ctx.Value(loggerKey{}).(*zap.Logger); ok {
+		return l
+	}
+	// The global logger skips one frame for the package level helpers.
+	return log.WithOptions(zap.AddCallerSkip(-1))
+}
+
+// WithContext returns a copy of ctx that carries l.
+func WithContext(ctx context.Context, l *zap.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+// newRequestContext attaches a child logger carrying the request ID, peer
+// address and, on mutual TLS connections, the client principal. It also
+// sends the request ID back to the client.
+func newRequestContext(ctx context.Context, setHeader func(metadata.MD) error) context.Context {
+	req := Request{ID: requestID(ctx)}
+	fields := []zap.Field{zap.String("request_id", req.ID)}
+
+	if p, ok := peer.FromContext(ctx); ok {
+		req.Peer = p.Addr.String()
+		req.Principal = principal(p)
+		fields = append(fields, zap.String("peer", req.Peer))
+		if req.Principal != "" {
+			fields = append(fields, zap.String("principal", req.Principal))
+		}
+	}
+
+	if err := setHeader(metadata.Pairs(RequestIDKey, req.ID)); err != nil {
+		Debug("failed to set request id header", zap.Error(err))
+	}
+
+	ctx = context.WithValue(ctx, requestKey{}, req)
+	return WithContext(ctx, FromContext(ctx).With(fields...))
+}
+
+// requestID returns the ID sent by the client, or a new one.
+func requestID(ctx context.Context) string {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ids := md.Get(RequestIDKey); len(ids) > 0 && ids[0] != "" {
+			return ids[0]
+		}
+	}
+	return uuid.New().String()
+}
+
+// principal is the subject of the verified client certificate, if any.
+func principal(p *peer.Peer) string {
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(tlsInfo.State.VerifiedChains) == 0 || len(tlsInfo.State.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	return commonName(tlsInfo.State.VerifiedChains[0][0])
+}
+
+func commonName(cert *x509.Certificate) string {
+	if cert.Subject.CommonName != "" {
+		return cert.Subject.CommonName
+	}
+	return cert.Subject.String()
+}
+
+func unaryHeader(ctx context.Context) func(metadata.MD) error {
+	return func(md metadata.MD) error {
+		return grpc.SetHeader(ctx, md)
+	}
+}
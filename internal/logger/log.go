@@ -5,7 +5,9 @@ import (
 	"go.uber.org/zap/zapcore"
 )
 
-var log *zap.Logger
+// log discards everything until InitLog is called, so packages can log
+// safely when potoc is embedded.
+var log = zap.NewNop()
 
 //	func MyCaller(caller zapcore.EntryCaller, enc zapcore.PrimitiveArrayEncoder) {
 //		enc.AppendString(filepath.Base(caller.FullPath()))
@@ -0,0 +1,86 @@
+package logger
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/NikoMalik/potoc/pkg/proto"
+	"go.uber.org/zap"
+	protobuf "google.golang.org/protobuf/proto"
+)
+
+// PayloadMode decides how much of a message payload the interceptors log.
+type PayloadMode string
+
+const (
+	// PayloadOff logs nothing about payloads.
+	PayloadOff PayloadMode = "off"
+	// PayloadSizes logs the payload size only.
+	PayloadSizes PayloadMode = "sizes"
+	// PayloadHashed logs the size and a SHA-256 of the payload, enough to
+	// match messages across services without revealing them.
+	PayloadHashed PayloadMode = "hashed"
+	// PayloadPreview logs the size and the first PreviewBytes of the
+	// payload. It leaks user data and is meant for local debugging.
+	PayloadPreview PayloadMode = "preview"
+)
+
+// PayloadPolicy is the redaction applied to every payload the interceptors
+// log.
+type PayloadPolicy struct {
+	Mode         PayloadMode
+	PreviewBytes int
+}
+
+var payloadPolicy atomic.Pointer[PayloadPolicy]
+
+// SetPayloadPolicy replaces the policy. It is safe to call while serving.
+func SetPayloadPolicy(p PayloadPolicy) {
+	payloadPolicy.Store(&p)
+}
+
+// payloadFields describes m according to the current policy. ok is false
+// when the policy is off and nothing should be logged.
+func payloadFields(m any) (_ []zap.Field, ok bool) {
+	p := payloadPolicy.Load()
+	if p == nil || p.Mode == PayloadOff || p.Mode == "" {
+		return nil, false
+	}
+
+	data, known := payload(m)
+	if !known {
+		// Only the payload fields of our own messages are known to be
+		// safe to redact, so anything else is reduced to type and size.
+		fields := []zap.Field{zap.String("type", fmt.Sprintf("%T", m))}
+		if pm, ok := m.(protobuf.Message); ok {
+			fields = append(fields, zap.Int("size", protobuf.Size(pm)))
+		}
+		return fields, true
+	}
+
+	fields := []zap.Field{zap.Int("size", len(data))}
+	switch p.Mode {
+	case PayloadHashed:
+		sum := sha256.Sum256(data)
+		fields = append(fields, zap.String("sha256", hex.EncodeToString(sum[:])))
+	case PayloadPreview:
+		n := min(len(data), p.PreviewBytes)
+		fields = append(fields, zap.ByteString("preview", data[:n]), zap.Bool("truncated", n < len(data)))
+	}
+	return fields, true
+}
+
+func payload(m any) ([]byte, bool) {
+	switch msg := m.(type) {
+	case []byte:
+		return msg, true
+	case *proto.DataRequest:
+		return msg.GetEncodedData(), true
+	case *proto.DataResponse:
+		return msg.GetData(), true
+	default:
+		return nil, false
+	}
+}
@@ -0,0 +1,77 @@
+package logger
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"reflect"
+	"testing"
+
+	"github.com/NikoMalik/potoc/pkg/proto"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestPayloadFields(t *testing.T) {
+	hello := &proto.DataRequest{EncodedData: []byte("hello")}
+	sum := sha256.Sum256([]byte("hello"))
+
+	tests := []struct {
+		name   string
+		policy PayloadPolicy
+		msg    any
+		// want are the logged fields, nil when nothing is logged.
+		want map[string]any
+	}{
+		{name: "off", policy: PayloadPolicy{Mode: PayloadOff}, msg: hello},
+		{name: "unset", msg: hello},
+		{name: "sizes", policy: PayloadPolicy{Mode: PayloadSizes}, msg: hello, want: map[string]any{"size": int64(5)}},
+		{
+			name:   "hashed",
+			policy: PayloadPolicy{Mode: PayloadHashed},
+			msg:    &proto.DataResponse{Data: []byte("hello")},
+			want:   map[string]any{"size": int64(5), "sha256": hex.EncodeToString(sum[:])},
+		},
+		{
+			name:   "preview truncated",
+			policy: PayloadPolicy{Mode: PayloadPreview, PreviewBytes: 3},
+			msg:    hello,
+			want:   map[string]any{"size": int64(5), "preview": "hel", "truncated": true},
+		},
+		{
+			name:   "preview whole",
+			policy: PayloadPolicy{Mode: PayloadPreview, PreviewBytes: 8},
+			msg:    []byte("hello"),
+			want:   map[string]any{"size": int64(5), "preview": "hello", "truncated": false},
+		},
+		{
+			name:   "unknown message",
+			policy: PayloadPolicy{Mode: PayloadPreview, PreviewBytes: 8},
+			msg:    &proto.ServerInfo{Version: "secret"},
+			want:   map[string]any{"type": "*proto.ServerInfo", "size": int64(8)},
+		},
+	}
+
+	t.Cleanup(func() { payloadPolicy.Store(nil) })
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payloadPolicy.Store(nil)
+			if tt.policy.Mode != "" {
+				SetPayloadPolicy(tt.policy)
+			}
+
+			fields, ok := payloadFields(tt.msg)
+			if ok != (tt.want != nil) {
+				t.Fatalf("logged %v, want %v", ok, tt.want != nil)
+			}
+			if !ok {
+				return
+			}
+			enc := zapcore.NewMapObjectEncoder()
+			for _, f := range fields {
+				f.AddTo(enc)
+			}
+			if !reflect.DeepEqual(enc.Fields, tt.want) {
+				t.Errorf("got fields %v, want %v", enc.Fields, tt.want)
+			}
+		})
+	}
+}
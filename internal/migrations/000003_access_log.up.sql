@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS access_log (
+    id BIGSERIAL PRIMARY KEY,
+    request_id TEXT NOT NULL,
+    method TEXT NOT NULL,
+    kind TEXT NOT NULL, -- unary | stream
+    peer TEXT NOT NULL,
+    principal TEXT NOT NULL, -- subject of the client certificate, empty without mTLS
+    started_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    finished_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    msgs_in BIGINT NOT NULL,
+    msgs_out BIGINT NOT NULL,
+    bytes_in BIGINT NOT NULL,
+    bytes_out BIGINT NOT NULL,
+    object_ids TEXT[] NOT NULL, -- socket_data ids read or written
+    code TEXT NOT NULL -- final gRPC status code
+);
+
+CREATE INDEX IF NOT EXISTS access_log_started_at_idx ON access_log (started_at);
+CREATE INDEX IF NOT EXISTS access_log_request_id_idx ON access_log (request_id);
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS socket_data_labels_idx;
+
+ALTER TABLE socket_data
+    DROP COLUMN IF EXISTS labels,
+    DROP COLUMN IF EXISTS created_at;
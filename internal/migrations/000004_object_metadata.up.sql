@@ -0,0 +1,5 @@
+ALTER TABLE socket_data
+    ADD COLUMN IF NOT EXISTS labels JSONB NOT NULL DEFAULT '{}', -- user supplied key/value labels
+    ADD COLUMN IF NOT EXISTS created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS socket_data_labels_idx ON socket_data USING GIN (labels);
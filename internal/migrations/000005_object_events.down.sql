@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS socket_data_events ON socket_data;
+DROP FUNCTION IF EXISTS record_object_event();
+DROP TABLE IF EXISTS object_events;
@@ -0,0 +1,45 @@
+CREATE TABLE IF NOT EXISTS object_events (
+    seq BIGSERIAL PRIMARY KEY, -- taken in insert order, commits may land out of it
+    kind TEXT NOT NULL, -- created | updated | deleted
+    object_id UUID NOT NULL,
+    size BIGINT NOT NULL,
+    labels JSONB NOT NULL,
+    object_created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    occurred_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    recorded_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT clock_timestamp() -- when seq was taken, see eventRepo.After
+);
+
+CREATE INDEX IF NOT EXISTS object_events_occurred_at_idx ON object_events (occurred_at);
+
+-- record_object_event stores a change to socket_data and wakes the servers
+-- listening on the object_events channel.
+CREATE OR REPLACE FUNCTION record_object_event() RETURNS trigger AS $$
+DECLARE
+    obj socket_data;
+    event_kind TEXT;
+    event_seq BIGINT;
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        obj := OLD;
+        event_kind := 'deleted';
+    ELSIF TG_OP = 'UPDATE' THEN
+        obj := NEW;
+        event_kind := 'updated';
+    ELSE
+        obj := NEW;
+        event_kind := 'created';
+    END IF;
+
+    INSERT INTO object_events (kind, object_id, size, labels, object_created_at)
+    VALUES (event_kind, obj.id, octet_length(obj.data), obj.labels, obj.created_at)
+    RETURNING seq INTO event_seq;
+
+    PERFORM pg_notify('object_events', event_seq::text);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS socket_data_events ON socket_data;
+CREATE TRIGGER socket_data_events
+    AFTER INSERT OR UPDATE OR DELETE ON socket_data
+    FOR EACH ROW EXECUTE FUNCTION record_object_event();
@@ -0,0 +1,14 @@
+DROP TRIGGER IF EXISTS socket_data_events ON socket_data;
+CREATE TRIGGER socket_data_events
+    AFTER INSERT OR UPDATE OR DELETE ON socket_data
+    FOR EACH ROW EXECUTE FUNCTION record_object_event();
+
+DROP INDEX IF EXISTS socket_data_leased_by_idx;
+DROP INDEX IF EXISTS socket_data_queue_idx;
+
+ALTER TABLE socket_data
+    DROP COLUMN IF EXISTS deliveries,
+    DROP COLUMN IF EXISTS lease_until,
+    DROP COLUMN IF EXISTS leased_by,
+    DROP COLUMN IF EXISTS lease_id,
+    DROP COLUMN IF EXISTS queue;
@@ -0,0 +1,15 @@
+ALTER TABLE socket_data
+    ADD COLUMN IF NOT EXISTS queue TEXT, -- NULL unless put in a queue for Consume
+    ADD COLUMN IF NOT EXISTS lease_id UUID, -- current delivery, proves it to Ack and Nack
+    ADD COLUMN IF NOT EXISTS leased_by UUID, -- Consume stream holding the lease
+    ADD COLUMN IF NOT EXISTS lease_until TIMESTAMP WITH TIME ZONE, -- hidden from consumers until then
+    ADD COLUMN IF NOT EXISTS deliveries INT NOT NULL DEFAULT 0;
+
+CREATE INDEX IF NOT EXISTS socket_data_queue_idx ON socket_data (queue, created_at) WHERE queue IS NOT NULL;
+CREATE INDEX IF NOT EXISTS socket_data_leased_by_idx ON socket_data (leased_by) WHERE leased_by IS NOT NULL;
+
+-- Leasing an object is not a change worth an event.
+DROP TRIGGER IF EXISTS socket_data_events ON socket_data;
+CREATE TRIGGER socket_data_events
+    AFTER INSERT OR UPDATE OF data, labels OR DELETE ON socket_data
+    FOR EACH ROW EXECUTE FUNCTION record_object_event();
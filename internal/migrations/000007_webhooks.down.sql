@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS webhook_cursor;
+DROP TABLE IF EXISTS webhook_outbox;
@@ -0,0 +1,31 @@
+CREATE TABLE IF NOT EXISTS webhook_outbox (
+    id BIGSERIAL PRIMARY KEY,
+    endpoint TEXT NOT NULL, -- name of the endpoint in the webhooks config
+    seq BIGINT NOT NULL, -- object_events.seq, copied so the event outlives its retention
+    kind TEXT NOT NULL,
+    object_id UUID NOT NULL,
+    size BIGINT NOT NULL,
+    labels JSONB NOT NULL,
+    object_created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    occurred_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    attempts INT NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP, -- also holds off other servers while one is posting
+    last_error TEXT NOT NULL DEFAULT '',
+    dead_at TIMESTAMP WITH TIME ZONE, -- set once the attempts ran out
+    UNIQUE (endpoint, seq)
+);
+
+CREATE INDEX IF NOT EXISTS webhook_outbox_due_idx ON webhook_outbox (next_attempt_at) WHERE dead_at IS NULL;
+CREATE INDEX IF NOT EXISTS webhook_outbox_dead_idx ON webhook_outbox (endpoint, id) WHERE dead_at IS NOT NULL;
+
+-- webhook_cursor is the seq of the last event copied to the outbox. Its
+-- single row is locked while copying, so only one server copies at a time.
+-- Events recorded before webhooks existed are not posted.
+CREATE TABLE IF NOT EXISTS webhook_cursor (
+    id BOOLEAN PRIMARY KEY DEFAULT TRUE CHECK (id),
+    seq BIGINT NOT NULL
+);
+
+INSERT INTO webhook_cursor (seq)
+SELECT COALESCE(MAX(seq), 0) FROM object_events
+ON CONFLICT DO NOTHING;
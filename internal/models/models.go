@@ -7,8 +7,19 @@ import (
 )
 
 type SocketData struct {
-	ID   *uuid.UUID
-	Data []byte
+	ID     *uuid.UUID
+	Data   []byte
+	Labels map[string]string
+	Queue  string
+}
+
+// ObjectInfo describes a stored object without its data.
+type ObjectInfo struct {
+	ID        string
+	Size      int64
+	Labels    map[string]string
+	CreatedAt time.Time
+	Queue     string
 }
 
 type RandomData struct {
@@ -18,3 +29,43 @@ type RandomData struct {
 	Value       int
 	CreatedAt   time.Time
 }
+
+// Kinds of ObjectEvent.
+const (
+	EventCreated = "created"
+	EventUpdated = "updated"
+	EventDeleted = "deleted"
+)
+
+// ObjectEvent is a committed change to a stored object. Events are read
+// in the order of Seq.
+type ObjectEvent struct {
+	Seq    int64
+	Kind   string
+	Object ObjectInfo
+	Time   time.Time
+}
+
+// Lease is an object delivered to a consumer of its queue. It stays hidden
+// from other consumers until Until unless acked or nacked with LeaseID.
+type Lease struct {
+	Object  ObjectInfo
+	Data    []byte
+	LeaseID string
+	Until   time.Time
+	// Attempt counts the deliveries of the object, this one included.
+	Attempt int
+}
+
+// WebhookDelivery is an event waiting in the outbox to be posted to a
+// webhook endpoint, or a dead letter once DeadAt is set.
+type WebhookDelivery struct {
+	ID       int64
+	Endpoint string
+	Event    ObjectEvent
+	// Attempts counts the posts so far, the one in progress included.
+	Attempts    int
+	LastError   string
+	NextAttempt time.Time
+	DeadAt      time.Time
+}
@@ -0,0 +1,39 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/NikoMalik/potoc/internal/accesslog"
+	"github.com/NikoMalik/potoc/internal/tracing"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var _ AccessLogRepo = (*accessLogRepo)(nil)
+
+const accessLogTable = "access_log"
+
+type accessLogRepo struct {
+	db *pgxpool.Pool
+}
+
+func NewAccessLogRepo(db *pgxpool.Pool) AccessLogRepo {
+	return &accessLogRepo{db: db}
+}
+
+func (a *accessLogRepo) Insert(ctx context.Context, e *accesslog.Entry) (err error) {
+	ctx, span := tracing.StartDB(ctx, "accessLogRepo.Insert", "INSERT", accessLogTable)
+	defer func() { tracing.End(span, err) }()
+
+	objects := e.Objects
+	if objects == nil {
+		objects = []string{}
+	}
+	_, err = a.db.Exec(ctx, `INSERT INTO access_log
+		(request_id, method, kind, peer, principal, started_at, finished_at,
+		 msgs_in, msgs_out, bytes_in, bytes_out, object_ids, code)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)`,
+		e.RequestID, e.Method, e.Kind, e.Peer, e.Principal, e.Start, e.End,
+		e.MsgsIn, e.MsgsOut, e.BytesIn, e.BytesOut, objects, e.Code,
+	)
+	return err
+}
@@ -0,0 +1,131 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/NikoMalik/potoc/internal/logger"
+	"github.com/NikoMalik/potoc/internal/models"
+	"github.com/NikoMalik/potoc/internal/tracing"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var _ EventRepo = (*eventRepo)(nil)
+
+const (
+	objectEventsTable = "object_events"
+	// objectEventsChannel is notified with the seq of every new event.
+	objectEventsChannel = "object_events"
+)
+
+// eventGapGrace is how long readers wait for a missing seq. Writers do not
+// wait for each other, so an event may commit after one with a bigger seq
+// and until then leaves a gap. A rolled back write leaves one for good, so
+// a gap older than this is skipped.
+const eventGapGrace = 10 * time.Second
+
+// settledEventsSQL selects up to $2 events with a seq above $1, oldest
+// first, stopping before a gap younger than $3 milliseconds.
+const settledEventsSQL = `WITH next AS (
+		SELECT seq, kind, object_id, size, labels, object_created_at, occurred_at,
+			seq > lag(seq, 1, $1) OVER (ORDER BY seq) + 1
+				AND recorded_at > clock_timestamp() - $3 * interval '1 millisecond' AS held
+		FROM object_events WHERE seq > $1 ORDER BY seq LIMIT $2
+	)
+	SELECT seq, kind, object_id::text, size, labels, object_created_at, occurred_at FROM next
+	WHERE NOT EXISTS (SELECT 1 FROM next g WHERE g.held AND g.seq <= next.seq)
+	ORDER BY seq`
+
+type eventRepo struct {
+	db *pgxpool.Pool
+}
+
+func NewEventRepo(db *pgxpool.Pool) EventRepo {
+	return &eventRepo{db: db}
+}
+
+// After returns up to limit events with a seq above after, oldest first.
+// It stops before a seq that may still be committed, see eventGapGrace.
+func (e *eventRepo) After(ctx context.Context, after int64, limit int) (_ []models.ObjectEvent, err error) {
+	ctx, span := tracing.StartDB(ctx, "eventRepo.After", "SELECT", objectEventsTable)
+	defer func() { tracing.End(span, err) }()
+
+	rows, err := e.db.Query(ctx, settledEventsSQL, after, limit, eventGapGrace.Milliseconds())
+	if err != nil {
+		logger.FromContext(ctx).Error(err.Error())
+		return nil, err
+	}
+	events, err := pgx.CollectRows(rows, scanObjectEvent)
+	if err != nil {
+		logger.FromContext(ctx).Error(err.Error())
+		return nil, err
+	}
+	return events, nil
+}
+
+// Bounds returns the seq of the oldest retained event and of the newest
+// one that After reaches, both zero when there are none.
+func (e *eventRepo) Bounds(ctx context.Context) (first, last int64, err error) {
+	ctx, span := tracing.StartDB(ctx, "eventRepo.Bounds", "SELECT", objectEventsTable)
+	defer func() { tracing.End(span, err) }()
+
+	err = e.db.QueryRow(ctx, `WITH gap AS (
+			SELECT MIN(e.seq) AS seq FROM object_events e
+			WHERE e.seq > 1 AND e.recorded_at > clock_timestamp() - $1 * interval '1 millisecond'
+				AND NOT EXISTS (SELECT 1 FROM object_events p WHERE p.seq = e.seq - 1)
+		)
+		SELECT COALESCE(MIN(o.seq), 0), COALESCE(MAX(o.seq) FILTER (WHERE gap.seq IS NULL OR o.seq < gap.seq), 0)
+		FROM object_events o, gap`, eventGapGrace.Milliseconds()).Scan(&first, &last)
+	if err != nil {
+		logger.FromContext(ctx).Error(err.Error())
+		return 0, 0, err
+	}
+	return first, last, nil
+}
+
+// Prune deletes the events that occurred before t, keeping the newest one
+// so that Bounds still knows where the sequence stands.
+func (e *eventRepo) Prune(ctx context.Context, t time.Time) (_ int64, err error) {
+	ctx, span := tracing.StartDB(ctx, "eventRepo.Prune", "DELETE", objectEventsTable)
+	defer func() { tracing.End(span, err) }()
+
+	tag, err := e.db.Exec(ctx, `DELETE FROM object_events
+		WHERE occurred_at < $1 AND seq < (SELECT MAX(seq) FROM object_events)`, t)
+	if err != nil {
+		logger.FromContext(ctx).Error(err.Error())
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// Listen calls wake once listening and then on every notification of a new
+// event, until ctx is done or the connection fails. It holds a connection
+// of its own for the whole time.
+func (e *eventRepo) Listen(ctx context.Context, wake func()) error {
+	conn, err := e.db.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	// A connection left listening must not go back to the pool.
+	pgConn := conn.Hijack()
+	defer pgConn.Close(context.Background())
+
+	if _, err := pgConn.Exec(ctx, "LISTEN "+objectEventsChannel); err != nil {
+		return err
+	}
+	wake()
+
+	for {
+		if _, err := pgConn.WaitForNotification(ctx); err != nil {
+			return err
+		}
+		wake()
+	}
+}
+
+func scanObjectEvent(row pgx.CollectableRow) (models.ObjectEvent, error) {
+	var ev models.ObjectEvent
+	err := row.Scan(&ev.Seq, &ev.Kind, &ev.Object.ID, &ev.Object.Size, &ev.Object.Labels, &ev.Object.CreatedAt, &ev.Time)
+	return ev, err
+}
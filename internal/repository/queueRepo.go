@@ -0,0 +1,151 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/NikoMalik/potoc/internal/logger"
+	"github.com/NikoMalik/potoc/internal/models"
+	"github.com/NikoMalik/potoc/internal/tracing"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var _ QueueRepo = (*queueRepo)(nil)
+
+// ErrLeaseLost is returned by Ack and Nack when the lease expired or the
+// object was delivered again since.
+var ErrLeaseLost = errors.New("lease expired or lost")
+
+type queueRepo struct {
+	db *pgxpool.Pool
+}
+
+func NewQueueRepo(db *pgxpool.Pool) QueueRepo {
+	return &queueRepo{db: db}
+}
+
+// Claim leases up to n visible objects of queue to consumer, oldest first.
+// Rows locked by a concurrent Claim are skipped rather than waited for, so
+// consumers on any server never receive the same object at once.
+func (q *queueRepo) Claim(ctx context.Context, queue, consumer string, n int, visibility time.Duration) (_ []models.Lease, err error) {
+	ctx, span := tracing.StartDB(ctx, "queueRepo.Claim", "UPDATE", socketDataTable)
+	defer func() { tracing.End(span, err) }()
+
+	rows, err := q.db.Query(ctx, `WITH next AS (
+			SELECT id FROM socket_data
+			WHERE queue = $1 AND (lease_until IS NULL OR lease_until <= now())
+			ORDER BY created_at, id
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)
+		UPDATE socket_data s
+		SET lease_id = gen_random_uuid(), leased_by = $3,
+			lease_until = now() + $4 * interval '1 millisecond', deliveries = s.deliveries + 1
+		FROM next WHERE s.id = next.id
+		RETURNING s.id::text, octet_length(s.data), s.labels, s.created_at, s.queue,
+			s.data, s.lease_id::text, s.lease_until, s.deliveries`,
+		queue, n, consumer, visibility.Milliseconds())
+	if err != nil {
+		logger.FromContext(ctx).Error(err.Error())
+		return nil, err
+	}
+
+	leases, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (models.Lease, error) {
+		var l models.Lease
+		o := &l.Object
+		err := row.Scan(&o.ID, &o.Size, &o.Labels, &o.CreatedAt, &o.Queue, &l.Data, &l.LeaseID, &l.Until, &l.Attempt)
+		return l, err
+	})
+	if err != nil {
+		logger.FromContext(ctx).Error(err.Error())
+		return nil, err
+	}
+	// UPDATE ... RETURNING does not keep the order of the subquery.
+	sort.Slice(leases, func(i, j int) bool {
+		a, b := leases[i].Object, leases[j].Object
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.Before(b.CreatedAt)
+		}
+		return a.ID < b.ID
+	})
+	return leases, nil
+}
+
+// InFlight counts the unexpired leases held by consumer.
+func (q *queueRepo) InFlight(ctx context.Context, consumer string) (_ int, err error) {
+	ctx, span := tracing.StartDB(ctx, "queueRepo.InFlight", "SELECT", socketDataTable)
+	defer func() { tracing.End(span, err) }()
+
+	var n int
+	err = q.db.QueryRow(ctx, "SELECT COUNT(*) FROM socket_data WHERE leased_by = $1 AND lease_until > now()", consumer).Scan(&n)
+	if err != nil {
+		logger.FromContext(ctx).Error(err.Error())
+		return 0, err
+	}
+	return n, nil
+}
+
+// Ack deletes the object if leaseID still holds it.
+func (q *queueRepo) Ack(ctx context.Context, id, leaseID string) (err error) {
+	ctx, span := tracing.StartDB(ctx, "queueRepo.Ack", "DELETE", socketDataTable)
+	defer func() { tracing.End(span, err) }()
+
+	tag, err := q.db.Exec(ctx, "DELETE FROM socket_data WHERE id = $1 AND lease_id = $2 AND lease_until > now()", id, leaseID)
+	if err != nil {
+		logger.FromContext(ctx).Error(err.Error())
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return q.leaseError(ctx, id)
+	}
+	return nil
+}
+
+// Nack makes the object visible again after delay if leaseID still holds
+// it.
+func (q *queueRepo) Nack(ctx context.Context, id, leaseID string, delay time.Duration) (err error) {
+	ctx, span := tracing.StartDB(ctx, "queueRepo.Nack", "UPDATE", socketDataTable)
+	defer func() { tracing.End(span, err) }()
+
+	tag, err := q.db.Exec(ctx, `UPDATE socket_data
+		SET lease_id = NULL, leased_by = NULL, lease_until = now() + $3 * interval '1 millisecond'
+		WHERE id = $1 AND lease_id = $2 AND lease_until > now()`, id, leaseID, delay.Milliseconds())
+	if err != nil {
+		logger.FromContext(ctx).Error(err.Error())
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return q.leaseError(ctx, id)
+	}
+	return nil
+}
+
+// Release makes the objects leased by consumer visible again at once.
+func (q *queueRepo) Release(ctx context.Context, consumer string) (_ int64, err error) {
+	ctx, span := tracing.StartDB(ctx, "queueRepo.Release", "UPDATE", socketDataTable)
+	defer func() { tracing.End(span, err) }()
+
+	tag, err := q.db.Exec(ctx, `UPDATE socket_data SET lease_id = NULL, leased_by = NULL, lease_until = NULL
+		WHERE leased_by = $1 AND lease_until > now()`, consumer)
+	if err != nil {
+		logger.FromContext(ctx).Error(err.Error())
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// leaseError tells a missing object from a lost lease.
+func (q *queueRepo) leaseError(ctx context.Context, id string) error {
+	var exists bool
+	if err := q.db.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM socket_data WHERE id = $1)", id).Scan(&exists); err != nil {
+		logger.FromContext(ctx).Error(err.Error())
+		return err
+	}
+	if !exists {
+		return ErrNotFound
+	}
+	return ErrLeaseLost
+}
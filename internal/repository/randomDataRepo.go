@@ -38,8 +38,16 @@ func (r *randomRepo) GenerateRandomData(ctx context.Context) error {
 			return
 		}
 		logger.Info("Generating random data...")
+		var wg sync.WaitGroup
+		defer wg.Wait()
 		for i := 0; i < 5000; i++ {
+			if ctx.Err() != nil {
+				logger.Info("Random data generation cancelled")
+				return
+			}
+			wg.Add(1)
 			go func(i int) {
+				defer wg.Done()
 
 				randomData := &models.RandomData{
 					Name:        "Name_" + strconv.Itoa(i),
@@ -2,7 +2,9 @@ package repository
 
 import (
 	"context"
+	"time"
 
+	"github.com/NikoMalik/potoc/internal/accesslog"
 	"github.com/NikoMalik/potoc/internal/models"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
@@ -11,24 +13,107 @@ type SocketRepo interface {
 	Create(context.Context, *models.SocketData) (string, error)
 	Get(context.Context, string) (*models.SocketData, error)
 	Delete(context.Context, string) error
+	List(context.Context, ListFilter) ([]models.ObjectInfo, error)
+	Stat(context.Context, string) (*models.ObjectInfo, error)
 	DeleteAll(context.Context) error
 	Count(context.Context) (int, error)
 	Update(context.Context, string) (*models.SocketData, error)
 }
 
+// ListFilter selects the objects SocketRepo.List returns.
+type ListFilter struct {
+	// After is the id to continue after, empty to start from the beginning.
+	After string
+	Limit int
+	// Labels must all be present on an object with the same values.
+	Labels map[string]string
+}
+
+// TxRepo stores objects in transactions.
+type TxRepo interface {
+	// CreateAll stores either all of the objects or none of them.
+	CreateAll(context.Context, []*models.SocketData) error
+}
+
 type RandomRepo interface {
 	GenerateRandomData(context.Context) error
 	CheckIfExists(context.Context) (bool, error)
 }
 
+// EventRepo reads the changes to stored objects that the database records
+// in object_events.
+type EventRepo interface {
+	After(ctx context.Context, after int64, limit int) ([]models.ObjectEvent, error)
+	Bounds(ctx context.Context) (first, last int64, err error)
+	Prune(ctx context.Context, before time.Time) (int64, error)
+	Listen(ctx context.Context, wake func()) error
+}
+
+// QueueRepo leases the objects of a queue to consumers. consumer identifies
+// a Consume stream.
+type QueueRepo interface {
+	Claim(ctx context.Context, queue, consumer string, n int, visibility time.Duration) ([]models.Lease, error)
+	InFlight(ctx context.Context, consumer string) (int, error)
+	Ack(ctx context.Context, id, leaseID string) error
+	Nack(ctx context.Context, id, leaseID string, delay time.Duration) error
+	Release(ctx context.Context, consumer string) (int64, error)
+}
+
+// WebhookRepo keeps the webhook outbox. Enqueue copies new events into it,
+// one row per endpoint that route returns for the event. Delivered, Retry
+// and Dead record the outcome of the attempt a claim counted; they do
+// nothing once the delivery was claimed again.
+type WebhookRepo interface {
+	Enqueue(ctx context.Context, limit int, route func(*models.ObjectEvent) []string) (int, error)
+	Claim(ctx context.Context, endpoints []string, n int, lease time.Duration) ([]models.WebhookDelivery, error)
+	Delivered(ctx context.Context, id int64, attempt int) error
+	Retry(ctx context.Context, id int64, attempt int, at time.Time, reason string) error
+	Dead(ctx context.Context, id int64, attempt int, reason string) error
+	DeadLetters(ctx context.Context, f DeadLetterFilter) ([]models.WebhookDelivery, error)
+	Redrive(ctx context.Context, ids []int64) (int64, error)
+}
+
+// DeadLetterFilter selects the dead letters WebhookRepo.DeadLetters
+// returns.
+type DeadLetterFilter struct {
+	// Endpoint limits the list to one endpoint, empty for all of them.
+	Endpoint string
+	// After is the id to continue after, zero to start from the beginning.
+	After int64
+	Limit int
+}
+
+// AccessLogRepo stores access log entries in the audit table.
+type AccessLogRepo interface {
+	accesslog.Store
+}
+
 type Repositories struct {
-	SocketRepo SocketRepo
-	RandomRepo RandomRepo
+	SocketRepo    SocketRepo
+	TxRepo        TxRepo
+	RandomRepo    RandomRepo
+	AccessLogRepo AccessLogRepo
+	EventRepo     EventRepo
+	QueueRepo     QueueRepo
+	WebhookRepo   WebhookRepo
+
+	db *pgxpool.Pool
 }
 
 func NewRepositories(db *pgxpool.Pool) *Repositories {
 	return &Repositories{
-		SocketRepo: NewSocketRepo(db),
-		RandomRepo: NewRandomRepo(db),
+		SocketRepo:    NewSocketRepo(db),
+		TxRepo:        NewTxRepo(db),
+		RandomRepo:    NewRandomRepo(db),
+		AccessLogRepo: NewAccessLogRepo(db),
+		EventRepo:     NewEventRepo(db),
+		QueueRepo:     NewQueueRepo(db),
+		WebhookRepo:   NewWebhookRepo(db),
+		db:            db,
 	}
 }
+
+// Ping checks that the database behind the repositories is reachable.
+func (r *Repositories) Ping(ctx context.Context) error {
+	return r.db.Ping(ctx)
+}
@@ -3,18 +3,30 @@ package repository
 import (
 	"context"
 	"errors"
+	"fmt"
+	"strings"
 	"sync"
 
 	"github.com/NikoMalik/potoc/internal/logger"
 	"github.com/NikoMalik/potoc/internal/models"
+	"github.com/NikoMalik/potoc/internal/tracing"
 	"go.uber.org/zap"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 var _ SocketRepo = (*socketRepo)(nil)
 
+const socketDataTable = "socket_data"
+
+// objectInfoColumns are scanned into models.ObjectInfo.
+const objectInfoColumns = "id::text, octet_length(data), labels, created_at, COALESCE(queue, '')"
+
+// ErrNotFound is returned when no object has the requested id.
+var ErrNotFound = errors.New("object not found")
+
 var socketDataPool = &sync.Pool{
 	New: func() interface{} {
 		return new(models.SocketData)
@@ -29,73 +41,176 @@ func NewSocketRepo(db *pgxpool.Pool) SocketRepo {
 	return &socketRepo{db: db}
 }
 
-func (s *socketRepo) Create(ctx context.Context, data *models.SocketData) (string, error) {
-	_, err := s.db.Query(ctx, "INSERT INTO socket_data (id, data) VALUES ($1, $2) RETURNING id", data.ID, data.Data)
+func (s *socketRepo) Create(ctx context.Context, data *models.SocketData) (_ string, err error) {
+	ctx, span := tracing.StartDB(ctx, "socketRepo.Create", "INSERT", socketDataTable)
+	defer func() { tracing.End(span, err) }()
+
+	err = insertObject(ctx, s.db, data)
 	if err != nil {
 		if errors.Is(err, context.Canceled) {
 			return data.ID.String(), nil
 		}
-		logger.Error(err.Error())
+		logger.FromContext(ctx).Error(err.Error())
 		return "", err
 	}
 	return data.ID.String(), nil
 }
 
-func (s *socketRepo) Get(ctx context.Context, id string) (*models.SocketData, error) {
+// execer is a pool or a transaction.
+type execer interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+}
+
+const insertObjectSQL = "INSERT INTO socket_data (id, data, labels, queue) VALUES ($1, $2, $3, $4)"
+
+// insertObject adds data to socket_data through db.
+func insertObject(ctx context.Context, db execer, data *models.SocketData) error {
+	_, err := db.Exec(ctx, insertObjectSQL, objectArgs(data)...)
+	return err
+}
+
+// objectArgs are the arguments of insertObjectSQL for data.
+func objectArgs(data *models.SocketData) []any {
+	labels := data.Labels
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	var queue *string
+	if data.Queue != "" {
+		queue = &data.Queue
+	}
+	return []any{data.ID, data.Data, labels, queue}
+}
+
+func (s *socketRepo) Get(ctx context.Context, id string) (_ *models.SocketData, err error) {
+	ctx, span := tracing.StartDB(ctx, "socketRepo.Get", "SELECT", socketDataTable)
+	defer func() { tracing.End(span, err) }()
+
 	var data = socketDataPool.Get().(*models.SocketData)
-	err := s.db.QueryRow(ctx, "SELECT id, data FROM socket_data WHERE id = $1", id).Scan(&data.ID, &data.Data)
+	err = s.db.QueryRow(ctx, "SELECT id, data FROM socket_data WHERE id = $1", id).Scan(&data.ID, &data.Data)
 	if err != nil {
+		socketDataPool.Put(data)
 		if errors.Is(err, pgx.ErrNoRows) {
-			logger.Warn("No rows found for ID", zap.String("id", id))
-			socketDataPool.Put(data)
-
-			return nil, nil
+			logger.FromContext(ctx).Warn("No rows found for ID", zap.String("id", id))
+			return nil, ErrNotFound
 		}
-		socketDataPool.Put(data)
-		logger.Error(err.Error())
+		logger.FromContext(ctx).Error(err.Error())
 		return nil, err
 	}
-	socketDataPool.Put(data)
 
 	return data, nil
 }
 
-func (s *socketRepo) Delete(ctx context.Context, id string) error {
-	_, err := s.db.Exec(ctx, "DELETE FROM socket_data WHERE id = $1", id)
+func (s *socketRepo) Delete(ctx context.Context, id string) (err error) {
+	ctx, span := tracing.StartDB(ctx, "socketRepo.Delete", "DELETE", socketDataTable)
+	defer func() { tracing.End(span, err) }()
+
+	tag, err := s.db.Exec(ctx, "DELETE FROM socket_data WHERE id = $1", id)
 	if err != nil {
-		logger.Error(err.Error())
+		logger.FromContext(ctx).Error(err.Error())
 		return err
 	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
 	return nil
 }
 
-func (s *socketRepo) DeleteAll(ctx context.Context) error {
-	_, err := s.db.Exec(ctx, "DELETE FROM socket_data")
+// List returns up to f.Limit objects matching f, ordered by id.
+func (s *socketRepo) List(ctx context.Context, f ListFilter) (_ []models.ObjectInfo, err error) {
+	ctx, span := tracing.StartDB(ctx, "socketRepo.List", "SELECT", socketDataTable)
+	defer func() { tracing.End(span, err) }()
+
+	var (
+		where []string
+		args  []any
+	)
+	if f.After != "" {
+		args = append(args, f.After)
+		where = append(where, fmt.Sprintf("id > $%d", len(args)))
+	}
+	if len(f.Labels) > 0 {
+		args = append(args, f.Labels)
+		where = append(where, fmt.Sprintf("labels @> $%d", len(args)))
+	}
+	query := "SELECT " + objectInfoColumns + " FROM socket_data"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	args = append(args, f.Limit)
+	query += fmt.Sprintf(" ORDER BY id LIMIT $%d", len(args))
+
+	rows, err := s.db.Query(ctx, query, args...)
+	if err != nil {
+		logger.FromContext(ctx).Error(err.Error())
+		return nil, err
+	}
+
+	objects, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (models.ObjectInfo, error) {
+		var o models.ObjectInfo
+		err := row.Scan(&o.ID, &o.Size, &o.Labels, &o.CreatedAt, &o.Queue)
+		return o, err
+	})
+	if err != nil {
+		logger.FromContext(ctx).Error(err.Error())
+		return nil, err
+	}
+	return objects, nil
+}
+
+// Stat describes the object with the given id without reading its data.
+func (s *socketRepo) Stat(ctx context.Context, id string) (_ *models.ObjectInfo, err error) {
+	ctx, span := tracing.StartDB(ctx, "socketRepo.Stat", "SELECT", socketDataTable)
+	defer func() { tracing.End(span, err) }()
+
+	var o models.ObjectInfo
+	err = s.db.QueryRow(ctx, "SELECT "+objectInfoColumns+" FROM socket_data WHERE id = $1", id).
+		Scan(&o.ID, &o.Size, &o.Labels, &o.CreatedAt, &o.Queue)
 	if err != nil {
-		logger.Error(err.Error())
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		logger.FromContext(ctx).Error(err.Error())
+		return nil, err
+	}
+	return &o, nil
+}
+
+func (s *socketRepo) DeleteAll(ctx context.Context) (err error) {
+	ctx, span := tracing.StartDB(ctx, "socketRepo.DeleteAll", "DELETE", socketDataTable)
+	defer func() { tracing.End(span, err) }()
+
+	_, err = s.db.Exec(ctx, "DELETE FROM socket_data")
+	if err != nil {
+		logger.FromContext(ctx).Error(err.Error())
 		return err
 	}
 	return nil
 }
 
-func (s *socketRepo) Update(ctx context.Context, id string) (*models.SocketData, error) {
+func (s *socketRepo) Update(ctx context.Context, id string) (_ *models.SocketData, err error) {
+	ctx, span := tracing.StartDB(ctx, "socketRepo.Update", "SELECT", socketDataTable)
+	defer func() { tracing.End(span, err) }()
+
 	var data = socketDataPool.Get().(*models.SocketData)
-	err := s.db.QueryRow(ctx, "SELECT id, data FROM socket_data WHERE id = $1", id).Scan(&data.ID, &data.Data)
+	err = s.db.QueryRow(ctx, "SELECT id, data FROM socket_data WHERE id = $1", id).Scan(&data.ID, &data.Data)
 	if err != nil {
 		socketDataPool.Put(data)
-		logger.Error(err.Error())
+		logger.FromContext(ctx).Error(err.Error())
 		return nil, err
 	}
 
-	socketDataPool.Put(data)
 	return data, nil
 }
 
-func (s *socketRepo) Count(ctx context.Context) (int, error) {
+func (s *socketRepo) Count(ctx context.Context) (_ int, err error) {
+	ctx, span := tracing.StartDB(ctx, "socketRepo.Count", "SELECT", socketDataTable)
+	defer func() { tracing.End(span, err) }()
+
 	var count int
-	err := s.db.QueryRow(ctx, "SELECT COUNT(*) FROM socket_data").Scan(&count)
+	err = s.db.QueryRow(ctx, "SELECT COUNT(*) FROM socket_data").Scan(&count)
 	if err != nil {
-		logger.Error(err.Error())
+		logger.FromContext(ctx).Error(err.Error())
 		return 0, err
 	}
 	return count, nil
@@ -0,0 +1,42 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/NikoMalik/potoc/internal/logger"
+	"github.com/NikoMalik/potoc/internal/models"
+	"github.com/NikoMalik/potoc/internal/tracing"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var _ TxRepo = (*txRepo)(nil)
+
+type txRepo struct {
+	db *pgxpool.Pool
+}
+
+func NewTxRepo(db *pgxpool.Pool) TxRepo {
+	return &txRepo{db: db}
+}
+
+// CreateAll inserts objects in one batch and one transaction. The event
+// trigger locks out other writers until it commits, so the transaction
+// holds nothing but the inserts.
+func (r *txRepo) CreateAll(ctx context.Context, objects []*models.SocketData) (err error) {
+	ctx, span := tracing.StartDB(ctx, "txRepo.CreateAll", "INSERT", socketDataTable)
+	defer func() { tracing.End(span, err) }()
+
+	batch := &pgx.Batch{}
+	for _, data := range objects {
+		batch.Queue(insertObjectSQL, objectArgs(data)...)
+	}
+	err = pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		return tx.SendBatch(ctx, batch).Close()
+	})
+	if err != nil {
+		logger.FromContext(ctx).Error(err.Error())
+		return err
+	}
+	return nil
+}
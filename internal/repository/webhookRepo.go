@@ -0,0 +1,217 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/NikoMalik/potoc/internal/logger"
+	"github.com/NikoMalik/potoc/internal/models"
+	"github.com/NikoMalik/potoc/internal/tracing"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var _ WebhookRepo = (*webhookRepo)(nil)
+
+const webhookOutboxTable = "webhook_outbox"
+
+// webhookColumns are scanned by scanWebhookDelivery, see also Claim.
+const webhookColumns = `id, endpoint, seq, kind, object_id::text, size, labels, object_created_at,
+	occurred_at, attempts, next_attempt_at, last_error, dead_at`
+
+type webhookRepo struct {
+	db *pgxpool.Pool
+}
+
+func NewWebhookRepo(db *pgxpool.Pool) WebhookRepo {
+	return &webhookRepo{db: db}
+}
+
+// Enqueue copies up to limit events after the cursor into the outbox and
+// moves the cursor past them. Like eventRepo.After it stops at a gap that
+// may still be filled. It returns how many events it read.
+func (w *webhookRepo) Enqueue(ctx context.Context, limit int, route func(*models.ObjectEvent) []string) (_ int, err error) {
+	ctx, span := tracing.StartDB(ctx, "webhookRepo.Enqueue", "INSERT", webhookOutboxTable)
+	defer func() { tracing.End(span, err) }()
+
+	tx, err := w.db.Begin(ctx)
+	if err != nil {
+		logger.FromContext(ctx).Error(err.Error())
+		return 0, err
+	}
+	defer tx.Rollback(context.Background())
+
+	var cursor int64
+	if err := tx.QueryRow(ctx, "SELECT seq FROM webhook_cursor FOR UPDATE").Scan(&cursor); err != nil {
+		logger.FromContext(ctx).Error(err.Error())
+		return 0, err
+	}
+
+	rows, err := tx.Query(ctx, settledEventsSQL, cursor, limit, eventGapGrace.Milliseconds())
+	if err != nil {
+		logger.FromContext(ctx).Error(err.Error())
+		return 0, err
+	}
+	events, err := pgx.CollectRows(rows, scanObjectEvent)
+	if err != nil {
+		logger.FromContext(ctx).Error(err.Error())
+		return 0, err
+	}
+	if len(events) == 0 {
+		return 0, nil
+	}
+
+	batch := &pgx.Batch{}
+	for i := range events {
+		ev := &events[i]
+		for _, endpoint := range route(ev) {
+			batch.Queue(`INSERT INTO webhook_outbox
+				(endpoint, seq, kind, object_id, size, labels, object_created_at, occurred_at)
+				VALUES ($1, $2, $3, $4, $5, $6, $7, $8) ON CONFLICT (endpoint, seq) DO NOTHING`,
+				endpoint, ev.Seq, ev.Kind, ev.Object.ID, ev.Object.Size, ev.Object.Labels, ev.Object.CreatedAt, ev.Time)
+		}
+	}
+	batch.Queue("UPDATE webhook_cursor SET seq = $1", events[len(events)-1].Seq)
+	if err := tx.SendBatch(ctx, batch).Close(); err != nil {
+		logger.FromContext(ctx).Error(err.Error())
+		return 0, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		logger.FromContext(ctx).Error(err.Error())
+		return 0, err
+	}
+	return len(events), nil
+}
+
+// Claim takes up to n due deliveries to endpoints, oldest first, and hides
+// them from other servers for lease while they are posted. Each claim
+// counts as an attempt.
+func (w *webhookRepo) Claim(ctx context.Context, endpoints []string, n int, lease time.Duration) (_ []models.WebhookDelivery, err error) {
+	ctx, span := tracing.StartDB(ctx, "webhookRepo.Claim", "UPDATE", webhookOutboxTable)
+	defer func() { tracing.End(span, err) }()
+
+	rows, err := w.db.Query(ctx, `WITH next AS (
+			SELECT id FROM webhook_outbox
+			WHERE dead_at IS NULL AND next_attempt_at <= now() AND endpoint = ANY($1)
+			ORDER BY next_attempt_at, id
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)
+		UPDATE webhook_outbox o
+		SET attempts = o.attempts + 1, next_attempt_at = now() + $3 * interval '1 millisecond'
+		FROM next WHERE o.id = next.id
+		RETURNING o.id, o.endpoint, o.seq, o.kind, o.object_id::text, o.size, o.labels, o.object_created_at,
+			o.occurred_at, o.attempts, o.next_attempt_at, o.last_error, o.dead_at`,
+		endpoints, n, lease.Milliseconds())
+	if err != nil {
+		logger.FromContext(ctx).Error(err.Error())
+		return nil, err
+	}
+	deliveries, err := pgx.CollectRows(rows, scanWebhookDelivery)
+	if err != nil {
+		logger.FromContext(ctx).Error(err.Error())
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// Delivered removes a delivery the endpoint accepted.
+func (w *webhookRepo) Delivered(ctx context.Context, id int64, attempt int) (err error) {
+	ctx, span := tracing.StartDB(ctx, "webhookRepo.Delivered", "DELETE", webhookOutboxTable)
+	defer func() { tracing.End(span, err) }()
+
+	if _, err := w.db.Exec(ctx, "DELETE FROM webhook_outbox WHERE id = $1 AND attempts = $2", id, attempt); err != nil {
+		logger.FromContext(ctx).Error(err.Error())
+		return err
+	}
+	return nil
+}
+
+// Retry schedules the next attempt of a delivery that failed for reason.
+func (w *webhookRepo) Retry(ctx context.Context, id int64, attempt int, at time.Time, reason string) (err error) {
+	ctx, span := tracing.StartDB(ctx, "webhookRepo.Retry", "UPDATE", webhookOutboxTable)
+	defer func() { tracing.End(span, err) }()
+
+	_, err = w.db.Exec(ctx, `UPDATE webhook_outbox SET next_attempt_at = $3, last_error = $4
+		WHERE id = $1 AND attempts = $2`, id, attempt, at, reason)
+	if err != nil {
+		logger.FromContext(ctx).Error(err.Error())
+		return err
+	}
+	return nil
+}
+
+// Dead turns a delivery that failed for reason into a dead letter.
+func (w *webhookRepo) Dead(ctx context.Context, id int64, attempt int, reason string) (err error) {
+	ctx, span := tracing.StartDB(ctx, "webhookRepo.Dead", "UPDATE", webhookOutboxTable)
+	defer func() { tracing.End(span, err) }()
+
+	_, err = w.db.Exec(ctx, `UPDATE webhook_outbox SET dead_at = now(), last_error = $3
+		WHERE id = $1 AND attempts = $2`, id, attempt, reason)
+	if err != nil {
+		logger.FromContext(ctx).Error(err.Error())
+		return err
+	}
+	return nil
+}
+
+// DeadLetters returns up to f.Limit dead letters matching f, ordered by id.
+func (w *webhookRepo) DeadLetters(ctx context.Context, f DeadLetterFilter) (_ []models.WebhookDelivery, err error) {
+	ctx, span := tracing.StartDB(ctx, "webhookRepo.DeadLetters", "SELECT", webhookOutboxTable)
+	defer func() { tracing.End(span, err) }()
+
+	where := []string{"dead_at IS NOT NULL", "id > $1"}
+	args := []any{f.After}
+	if f.Endpoint != "" {
+		args = append(args, f.Endpoint)
+		where = append(where, fmt.Sprintf("endpoint = $%d", len(args)))
+	}
+	args = append(args, f.Limit)
+	query := fmt.Sprintf("SELECT %s FROM webhook_outbox WHERE %s ORDER BY id LIMIT $%d",
+		webhookColumns, strings.Join(where, " AND "), len(args))
+
+	rows, err := w.db.Query(ctx, query, args...)
+	if err != nil {
+		logger.FromContext(ctx).Error(err.Error())
+		return nil, err
+	}
+	deliveries, err := pgx.CollectRows(rows, scanWebhookDelivery)
+	if err != nil {
+		logger.FromContext(ctx).Error(err.Error())
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// Redrive queues the dead letters with the given ids for delivery again,
+// with their attempts reset. It returns how many it found.
+func (w *webhookRepo) Redrive(ctx context.Context, ids []int64) (_ int64, err error) {
+	ctx, span := tracing.StartDB(ctx, "webhookRepo.Redrive", "UPDATE", webhookOutboxTable)
+	defer func() { tracing.End(span, err) }()
+
+	tag, err := w.db.Exec(ctx, `UPDATE webhook_outbox
+		SET dead_at = NULL, attempts = 0, next_attempt_at = now()
+		WHERE id = ANY($1) AND dead_at IS NOT NULL`, ids)
+	if err != nil {
+		logger.FromContext(ctx).Error(err.Error())
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+func scanWebhookDelivery(row pgx.CollectableRow) (models.WebhookDelivery, error) {
+	var (
+		d      models.WebhookDelivery
+		deadAt *time.Time
+	)
+	ev := &d.Event
+	err := row.Scan(&d.ID, &d.Endpoint, &ev.Seq, &ev.Kind, &ev.Object.ID, &ev.Object.Size, &ev.Object.Labels,
+		&ev.Object.CreatedAt, &ev.Time, &d.Attempts, &d.NextAttempt, &d.LastError, &deadAt)
+	if deadAt != nil {
+		d.DeadAt = *deadAt
+	}
+	return d, err
+}
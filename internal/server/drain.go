@@ -0,0 +1,176 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/NikoMalik/potoc/internal/logger"
+	"github.com/NikoMalik/potoc/pkg/proto"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	statusDraining = "draining"
+	msgDraining    = "server draining, finish sending and close the stream"
+)
+
+var _errDraining = status.Error(codes.Unavailable, "server is draining")
+
+// StreamInfo describes a stream that was open when the server drained.
+type StreamInfo struct {
+	ID      uint64
+	Method  string
+	Peer    string
+	Started time.Time
+}
+
+// DrainReport is the outcome of GRPC.Drain.
+type DrainReport struct {
+	// Drained is the number of streams that finished on their own.
+	Drained int
+	// Aborted lists the streams that were still open at the deadline and
+	// were cut off.
+	Aborted []StreamInfo
+}
+
+type trackedStream struct {
+	StreamInfo
+	drain chan struct{}
+}
+
+// streamTracker keeps the set of open streams so that shutdown can notify
+// them and wait for them to finish.
+type streamTracker struct {
+	mu       sync.Mutex
+	streams  map[uint64]*trackedStream
+	nextID   uint64
+	draining bool
+	done     chan struct{}
+}
+
+func newStreamTracker() *streamTracker {
+	return &streamTracker{
+		streams: make(map[uint64]*trackedStream),
+	}
+}
+
+type drainKey struct{}
+
+// drainSignal returns a channel that is closed when the server starts
+// draining. It is nil, and blocks forever, outside a tracked stream.
+func drainSignal(ctx context.Context) <-chan struct{} {
+	if ts, ok := ctx.Value(drainKey{}).(*trackedStream); ok {
+		return ts.drain
+	}
+	return nil
+}
+
+// StreamInterceptor refuses new streams once draining has begun and
+// registers the others until their handler returns.
+func (t *streamTracker) StreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ts, ok := t.add(ss.Context(), info.FullMethod)
+	if !ok {
+		return _errDraining
+	}
+	defer t.remove(ts.ID)
+
+	return handler(srv, &drainServerStream{
+		ServerStream: ss,
+		ctx:          context.WithValue(ss.Context(), drainKey{}, ts),
+	})
+}
+
+func (t *streamTracker) add(ctx context.Context, method string) (*trackedStream, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.draining {
+		return nil, false
+	}
+
+	t.nextID++
+	ts := &trackedStream{
+		StreamInfo: StreamInfo{
+			ID:      t.nextID,
+			Method:  method,
+			Started: time.Now(),
+		},
+		drain: make(chan struct{}),
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		ts.Peer = p.Addr.String()
+	}
+	t.streams[ts.ID] = ts
+
+	return ts, true
+}
+
+func (t *streamTracker) remove(id uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.streams, id)
+	if t.draining && len(t.streams) == 0 {
+		close(t.done)
+	}
+}
+
+// drain notifies every open stream and waits until they have all returned
+// or ctx is done. It returns the number of streams that finished and the
+// ones still open.
+func (t *streamTracker) drain(ctx context.Context) (int, []StreamInfo) {
+	t.mu.Lock()
+	if t.draining {
+		t.mu.Unlock()
+		return 0, nil
+	}
+	t.draining = true
+	t.done = make(chan struct{})
+	open := len(t.streams)
+	if open == 0 {
+		close(t.done)
+	}
+	for _, ts := range t.streams {
+		close(ts.drain)
+	}
+	t.mu.Unlock()
+
+	logger.Info("Draining streams", zap.Int("open", open))
+
+	select {
+	case <-t.done:
+		return open, nil
+	case <-ctx.Done():
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	aborted := make([]StreamInfo, 0, len(t.streams))
+	for _, ts := range t.streams {
+		aborted = append(aborted, ts.StreamInfo)
+	}
+	return open - len(aborted), aborted
+}
+
+type drainServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *drainServerStream) Context() context.Context {
+	return s.ctx
+}
+
+// drainNotice is sent on open streams when the server starts draining.
+func drainNotice() *proto.DataResponse {
+	return &proto.DataResponse{
+		Status: statusDraining,
+		Msg:    msgDraining,
+	}
+}
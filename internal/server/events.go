@@ -0,0 +1,470 @@
+package server
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/NikoMalik/potoc/internal/config"
+	"github.com/NikoMalik/potoc/internal/logger"
+	"github.com/NikoMalik/potoc/internal/models"
+	"github.com/NikoMalik/potoc/internal/repository"
+	"github.com/NikoMalik/potoc/pkg/proto"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// EventSeqHeader is sent in the Subscribe response header once the
+// subscription is registered. Its value is the seq the live events follow.
+const EventSeqHeader = "potoc-event-seq"
+
+const (
+	eventBatch        = 500
+	eventQueryTimeout = 10 * time.Second
+	maxPruneInterval  = time.Hour
+
+	listenBackoff    = time.Second
+	maxListenBackoff = 30 * time.Second
+)
+
+var (
+	_errEventsDisabled = status.Error(codes.Unimplemented, "events are disabled on this server")
+	_errStopping       = status.Error(codes.Unavailable, "server is shutting down, resume on another one")
+)
+
+// subscriber receives every event the hub fetches, unless paused while it
+// replays stored events. gone is closed, with err set, when the hub drops
+// it.
+type subscriber struct {
+	events chan models.ObjectEvent
+	gone   chan struct{}
+	err    error
+	paused bool
+}
+
+// eventHub fetches new events from the database once per server and fans
+// them out to the Subscribe streams. It wakes on Postgres notifications,
+// which reach every replica, and polls in case one is missed.
+type eventHub struct {
+	repo repository.EventRepo
+	cfg  *config.Events
+
+	wake  chan struct{}
+	ready chan struct{}
+	done  chan struct{}
+	once  sync.Once
+
+	mu   sync.Mutex
+	last int64
+	subs map[*subscriber]struct{}
+	// published is notified of every event, for queue consumers.
+	published *broadcast
+}
+
+// newEventHub returns nil when events are disabled or repo is nil.
+func newEventHub(cfg *config.Events, repo repository.EventRepo) *eventHub {
+	if cfg == nil || !cfg.Enabled || repo == nil {
+		return nil
+	}
+	return &eventHub{
+		repo:  repo,
+		cfg:   cfg,
+		wake:  make(chan struct{}, 1),
+		ready: make(chan struct{}),
+		done:  make(chan struct{}),
+		subs:  make(map[*subscriber]struct{}),
+
+		published: newBroadcast(),
+	}
+}
+
+// Run fetches and publishes events until Stop.
+func (h *eventHub) Run() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-h.done
+		cancel()
+	}()
+
+	if !h.start(ctx) {
+		return
+	}
+	go h.listen(ctx)
+
+	poll := time.NewTicker(h.cfg.PollInterval)
+	defer poll.Stop()
+	prune := time.NewTicker(min(h.cfg.Retention, maxPruneInterval))
+	defer prune.Stop()
+
+	for {
+		select {
+		case <-h.done:
+			return
+		case <-h.wake:
+			h.fetch(ctx)
+		case <-poll.C:
+			h.fetch(ctx)
+		case <-prune.C:
+			h.prune(ctx)
+		}
+	}
+}
+
+// start finds the newest event, retrying until the database answers, so
+// that only events after it are published.
+func (h *eventHub) start(ctx context.Context) bool {
+	for {
+		qctx, cancel := context.WithTimeout(ctx, eventQueryTimeout)
+		_, last, err := h.repo.Bounds(qctx)
+		cancel()
+		if err == nil {
+			h.mu.Lock()
+			h.last = last
+			h.mu.Unlock()
+			close(h.ready)
+			return true
+		}
+		logger.Warn("failed to read event position, retrying", zap.Error(err))
+
+		select {
+		case <-h.done:
+			return false
+		case <-time.After(h.cfg.PollInterval):
+		}
+	}
+}
+
+// listen keeps a LISTEN connection open, reconnecting with backoff.
+func (h *eventHub) listen(ctx context.Context) {
+	backoff := listenBackoff
+	for {
+		started := time.Now()
+		err := h.repo.Listen(ctx, h.notify)
+		if ctx.Err() != nil {
+			return
+		}
+		if time.Since(started) > maxListenBackoff {
+			backoff = listenBackoff
+		}
+		logger.Warn("event listener stopped, reconnecting", zap.Error(err), zap.Duration("backoff", backoff))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, maxListenBackoff)
+	}
+}
+
+// notify asks Run to fetch. It never blocks.
+func (h *eventHub) notify() {
+	select {
+	case h.wake <- struct{}{}:
+	default:
+	}
+}
+
+// fetch publishes every event after the last one published.
+func (h *eventHub) fetch(ctx context.Context) {
+	for {
+		h.mu.Lock()
+		after := h.last
+		h.mu.Unlock()
+
+		qctx, cancel := context.WithTimeout(ctx, eventQueryTimeout)
+		events, err := h.repo.After(qctx, after, eventBatch)
+		cancel()
+		if err != nil {
+			logger.Warn("failed to fetch events", zap.Error(err))
+			return
+		}
+		for _, ev := range events {
+			h.publish(ev)
+		}
+		if len(events) < eventBatch {
+			return
+		}
+	}
+}
+
+func (h *eventHub) publish(ev models.ObjectEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.last = ev.Seq
+	h.published.notify()
+	for sub := range h.subs {
+		if sub.paused {
+			continue
+		}
+		select {
+		case sub.events <- ev:
+		default:
+			h.drop(sub, status.Errorf(codes.ResourceExhausted,
+				"subscriber fell more than %d events behind, resume from the last seq received", h.cfg.Buffer))
+		}
+	}
+}
+
+func (h *eventHub) prune(ctx context.Context) {
+	qctx, cancel := context.WithTimeout(ctx, eventQueryTimeout)
+	defer cancel()
+
+	n, err := h.repo.Prune(qctx, time.Now().Add(-h.cfg.Retention))
+	if err != nil {
+		logger.Warn("failed to prune events", zap.Error(err))
+		return
+	}
+	if n > 0 {
+		logger.Debug("Pruned events", zap.Int64("count", n))
+	}
+}
+
+// changed returns a channel closed on the next event, or nil, which never
+// fires, when h is nil.
+func (h *eventHub) changed() <-chan struct{} {
+	if h == nil {
+		return nil
+	}
+	return h.published.wait()
+}
+
+// subscribe registers a subscriber once the hub knows its position and
+// returns it with the seq of the last event published before it. A paused
+// subscriber gets no events until resumed.
+func (h *eventHub) subscribe(ctx context.Context, paused bool) (*subscriber, int64, error) {
+	select {
+	case <-h.ready:
+	case <-h.done:
+		return nil, 0, _errStopping
+	case <-ctx.Done():
+		return nil, 0, status.FromContextError(ctx.Err()).Err()
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	select {
+	case <-h.done:
+		return nil, 0, _errStopping
+	default:
+	}
+
+	sub := &subscriber{
+		events: make(chan models.ObjectEvent, h.cfg.Buffer),
+		gone:   make(chan struct{}),
+		paused: paused,
+	}
+	h.subs[sub] = struct{}{}
+	return sub, h.last, nil
+}
+
+// resume unpauses sub if no event after sent was published and returns
+// the seq of the last event published.
+func (h *eventHub) resume(sub *subscriber, sent int64) int64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.last <= sent {
+		sub.paused = false
+	}
+	return h.last
+}
+
+func (h *eventHub) unsubscribe(sub *subscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.subs, sub)
+}
+
+// drop ends a subscription with err. h.mu must be held.
+func (h *eventHub) drop(sub *subscriber, err error) {
+	delete(h.subs, sub)
+	sub.err = err
+	close(sub.gone)
+}
+
+// Stop ends Run and every subscription.
+func (h *eventHub) Stop() {
+	h.once.Do(func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		close(h.done)
+		for sub := range h.subs {
+			h.drop(sub, _errStopping)
+		}
+	})
+}
+
+// eventFilter selects the events a subscriber asked for.
+type eventFilter struct {
+	labels map[string]string
+	kinds  map[string]bool
+}
+
+func newEventFilter(req *proto.SubscribeRequest) (*eventFilter, error) {
+	if err := checkLabels(req.GetLabels()); err != nil {
+		return nil, err
+	}
+	f := &eventFilter{labels: req.GetLabels()}
+	for _, k := range req.GetKinds() {
+		kind, ok := eventKinds[k]
+		if !ok {
+			return nil, status.Errorf(codes.InvalidArgument, "unknown event kind %v", k)
+		}
+		if f.kinds == nil {
+			f.kinds = make(map[string]bool)
+		}
+		f.kinds[kind] = true
+	}
+	return f, nil
+}
+
+func (f *eventFilter) match(ev *models.ObjectEvent) bool {
+	if f.kinds != nil && !f.kinds[ev.Kind] {
+		return false
+	}
+	for k, v := range f.labels {
+		if got, ok := ev.Object.Labels[k]; !ok || got != v {
+			return false
+		}
+	}
+	return true
+}
+
+var eventKinds = map[proto.EventKind]string{
+	proto.EventKind_EVENT_KIND_CREATED: models.EventCreated,
+	proto.EventKind_EVENT_KIND_UPDATED: models.EventUpdated,
+	proto.EventKind_EVENT_KIND_DELETED: models.EventDeleted,
+}
+
+func eventKind(kind string) proto.EventKind {
+	for k, v := range eventKinds {
+		if v == kind {
+			return k
+		}
+	}
+	return proto.EventKind_EVENT_KIND_UNSPECIFIED
+}
+
+func objectEvent(ev *models.ObjectEvent) *proto.ObjectEvent {
+	return &proto.ObjectEvent{
+		Seq:    ev.Seq,
+		Kind:   eventKind(ev.Kind),
+		Object: objectInfo(&ev.Object),
+		Time:   ev.Time.UnixNano(),
+	}
+}
+
+func (d *dataTransferServer) Subscribe(req *proto.SubscribeRequest, stream grpc.ServerStreamingServer[proto.ObjectEvent]) error {
+	if d.events == nil {
+		return _errEventsDisabled
+	}
+	ctx := stream.Context()
+	filter, err := newEventFilter(req)
+	if err != nil {
+		return err
+	}
+	if req.AfterSeq != nil && req.GetAfterSeq() < 0 {
+		return status.Errorf(codes.InvalidArgument, "negative after_seq %d", req.GetAfterSeq())
+	}
+
+	// Live events wait until the replay has caught up, so that a long
+	// replay does not overflow the buffer.
+	sub, pos, err := d.events.subscribe(ctx, req.AfterSeq != nil)
+	if err != nil {
+		return err
+	}
+	defer d.events.unsubscribe(sub)
+
+	if err := stream.SendHeader(metadata.Pairs(EventSeqHeader, strconv.FormatInt(pos, 10))); err != nil {
+		return err
+	}
+
+	sent := pos
+	if req.AfterSeq != nil {
+		if sent, err = d.catchUp(ctx, stream, filter, sub, req.GetAfterSeq(), pos); err != nil {
+			return err
+		}
+	}
+
+	drain := drainSignal(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		case <-drain:
+			return _errDraining
+		case <-sub.gone:
+			return sub.err
+		case ev := <-sub.events:
+			// Events up to sent were replayed already.
+			if ev.Seq <= sent {
+				continue
+			}
+			sent = ev.Seq
+			if !filter.match(&ev) {
+				continue
+			}
+			if err := stream.Send(objectEvent(&ev)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// catchUp replays the stored events after seq after while sub is paused,
+// until it has sent every event the hub published, then resumes sub. It
+// returns the seq live events must follow.
+func (d *dataTransferServer) catchUp(ctx context.Context, stream grpc.ServerStreamingServer[proto.ObjectEvent], filter *eventFilter, sub *subscriber, after, pos int64) (int64, error) {
+	first, _, err := d.events.repo.Bounds(ctx)
+	if err != nil {
+		logger.FromContext(ctx).Error("failed to read event bounds", zap.Error(err))
+		return 0, status.Error(codes.Internal, "failed to read events")
+	}
+	if first > 0 && after < first-1 {
+		return 0, status.Errorf(codes.OutOfRange, "events after seq %d were pruned, the oldest kept is %d", after, first)
+	}
+
+	for {
+		if after, err = d.replay(ctx, stream, filter, after, pos); err != nil {
+			return 0, err
+		}
+		if pos = d.events.resume(sub, after); pos <= after {
+			return after, nil
+		}
+	}
+}
+
+// replay sends the stored events after seq after up to pos and returns the
+// seq it got to.
+func (d *dataTransferServer) replay(ctx context.Context, stream grpc.ServerStreamingServer[proto.ObjectEvent], filter *eventFilter, after, pos int64) (int64, error) {
+	for after < pos {
+		events, err := d.events.repo.After(ctx, after, eventBatch)
+		if err != nil {
+			logger.FromContext(ctx).Error("failed to replay events", zap.Error(err))
+			return 0, status.Error(codes.Internal, "failed to read events")
+		}
+		if len(events) == 0 {
+			break
+		}
+		for i := range events {
+			ev := &events[i]
+			if ev.Seq > pos {
+				return pos, nil
+			}
+			after = ev.Seq
+			if !filter.match(ev) {
+				continue
+			}
+			if err := stream.Send(objectEvent(ev)); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return max(after, pos), nil
+}
@@ -0,0 +1,333 @@
+package server_test
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/NikoMalik/potoc/internal/config"
+	"github.com/NikoMalik/potoc/internal/server"
+	"github.com/NikoMalik/potoc/internal/servertest"
+	"github.com/NikoMalik/potoc/pkg/proto"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type eventStream = grpc.ServerStreamingClient[proto.ObjectEvent]
+
+// subscribe opens a subscription and waits until the server has
+// registered it. It returns the seq live events follow.
+func subscribe(t *testing.T, s *servertest.Server, req *proto.SubscribeRequest) (eventStream, int64) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+
+	stream, err := s.API.Subscribe(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	md, err := stream.Header()
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := md.Get(server.EventSeqHeader)
+	if len(values) == 0 {
+		_, err := stream.Recv()
+		t.Fatalf("no %s header: %v", server.EventSeqHeader, err)
+	}
+	pos, err := strconv.ParseInt(values[0], 10, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stream, pos
+}
+
+func recvEvents(t *testing.T, stream eventStream, n int) []*proto.ObjectEvent {
+	t.Helper()
+	events := make([]*proto.ObjectEvent, n)
+	for i := range events {
+		ev, err := stream.Recv()
+		if err != nil {
+			t.Fatalf("event %d: %v", i, err)
+		}
+		events[i] = ev
+	}
+	return events
+}
+
+func TestSubscribe(t *testing.T) {
+	s := servertest.Start(t)
+	ctx := context.Background()
+
+	run := runLabel()
+	all, _ := subscribe(t, s, &proto.SubscribeRequest{Labels: run})
+	deletes, _ := subscribe(t, s, &proto.SubscribeRequest{
+		Labels: run,
+		Kinds:  []proto.EventKind{proto.EventKind_EVENT_KIND_DELETED},
+	})
+
+	put(t, s, "other run", nil)
+	a := put(t, s, "a", run)
+	b := put(t, s, "bb", run)
+	if err := s.Client.Delete(ctx, a); err != nil {
+		t.Fatal(err)
+	}
+
+	events := recvEvents(t, all, 3)
+	want := []struct {
+		kind proto.EventKind
+		id   string
+	}{
+		{proto.EventKind_EVENT_KIND_CREATED, a},
+		{proto.EventKind_EVENT_KIND_CREATED, b},
+		{proto.EventKind_EVENT_KIND_DELETED, a},
+	}
+	for i, ev := range events {
+		if ev.GetKind() != want[i].kind || ev.GetObject().GetSocketId() != want[i].id {
+			t.Errorf("event %d: got %v %s, want %v %s", i, ev.GetKind(), ev.GetObject().GetSocketId(), want[i].kind, want[i].id)
+		}
+		if i > 0 && ev.GetSeq() <= events[i-1].GetSeq() {
+			t.Errorf("event %d: seq %d after %d", i, ev.GetSeq(), events[i-1].GetSeq())
+		}
+	}
+	if size := events[1].GetObject().GetSize(); size != 2 {
+		t.Errorf("created event size %d, want 2", size)
+	}
+
+	ev := recvEvents(t, deletes, 1)[0]
+	if ev.GetKind() != proto.EventKind_EVENT_KIND_DELETED || ev.GetObject().GetSocketId() != a {
+		t.Errorf("kind filter: got %v %s", ev.GetKind(), ev.GetObject().GetSocketId())
+	}
+
+	// Resuming after the first event replays the other two in order.
+	after := events[0].GetSeq()
+	resumed, _ := subscribe(t, s, &proto.SubscribeRequest{Labels: run, AfterSeq: &after})
+	for i, ev := range recvEvents(t, resumed, 2) {
+		if ev.GetSeq() != events[i+1].GetSeq() {
+			t.Errorf("replayed event %d: seq %d, want %d", i, ev.GetSeq(), events[i+1].GetSeq())
+		}
+	}
+
+	// And then carries on with live events, without repeating any.
+	c := put(t, s, "c", run)
+	if ev := recvEvents(t, resumed, 1)[0]; ev.GetObject().GetSocketId() != c {
+		t.Errorf("live event after replay: got %s, want %s", ev.GetObject().GetSocketId(), c)
+	}
+}
+
+func TestSubscribeErrors(t *testing.T) {
+	negative := int64(-1)
+	tests := []struct {
+		name     string
+		opts     []servertest.Option
+		req      *proto.SubscribeRequest
+		wantCode codes.Code
+	}{
+		{
+			name:     "bad label",
+			req:      &proto.SubscribeRequest{Labels: map[string]string{"": "v"}},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name:     "unknown kind",
+			req:      &proto.SubscribeRequest{Kinds: []proto.EventKind{proto.EventKind_EVENT_KIND_UNSPECIFIED}},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name:     "negative after_seq",
+			req:      &proto.SubscribeRequest{AfterSeq: &negative},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name: "events disabled",
+			opts: []servertest.Option{servertest.WithConfig(&config.Config{
+				Server: &config.Server{},
+				Limits: &config.Limits{},
+			})},
+			req:      &proto.SubscribeRequest{},
+			wantCode: codes.Unimplemented,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := servertest.Start(t, tt.opts...)
+			stream, err := s.API.Subscribe(context.Background(), tt.req)
+			if err == nil {
+				_, err = stream.Recv()
+			}
+			if code := status.Code(err); code != tt.wantCode {
+				t.Fatalf("got code %v (%v), want %v", code, err, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestSubscribePruned(t *testing.T) {
+	repo := servertest.NewMemoryRepo()
+	s := servertest.Start(t, servertest.WithRepo(repo))
+	ctx := context.Background()
+
+	for i := range 3 {
+		put(t, s, strings.Repeat("x", i), nil)
+	}
+	if _, err := repo.Prune(ctx, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	after := int64(0)
+	stream, err := s.API.Subscribe(ctx, &proto.SubscribeRequest{AfterSeq: &after})
+	if err == nil {
+		_, err = stream.Recv()
+	}
+	if code := status.Code(err); code != codes.OutOfRange {
+		t.Fatalf("got code %v (%v), want OutOfRange", code, err)
+	}
+}
+
+func TestSubscribeFallsBehind(t *testing.T) {
+	cfg := &config.Config{
+		Server: &config.Server{},
+		Limits: &config.Limits{},
+		Events: &config.Events{Enabled: true, Buffer: 2, PollInterval: time.Second, Retention: time.Hour},
+	}
+	s := servertest.Start(t, servertest.WithConfig(cfg))
+
+	// Large labels fill the stream's flow control window, so the server
+	// blocks sending while the subscriber reads nothing and its buffer
+	// overflows. It then finds its stream ended.
+	labels := map[string]string{}
+	for i := range 30 {
+		labels[strconv.Itoa(i)] = strings.Repeat("v", 255)
+	}
+	stream, _ := subscribe(t, s, &proto.SubscribeRequest{})
+	for range 50 {
+		put(t, s, "x", labels)
+	}
+
+	for {
+		_, err := stream.Recv()
+		if err == nil {
+			continue
+		}
+		if code := status.Code(err); code != codes.ResourceExhausted {
+			t.Fatalf("got code %v (%v), want ResourceExhausted", code, err)
+		}
+		return
+	}
+}
+
+func TestSubscribeReplayOutlastsBuffer(t *testing.T) {
+	cfg := &config.Config{
+		Server: &config.Server{},
+		Limits: &config.Limits{},
+		Events: &config.Events{Enabled: true, Buffer: 4, PollInterval: time.Second, Retention: time.Hour},
+	}
+	s := servertest.Start(t, servertest.WithConfig(cfg))
+
+	// Large labels fill the stream's flow control window, so the replay
+	// stalls while the subscriber reads nothing.
+	run := runLabel()
+	big := map[string]string{}
+	for k, v := range run {
+		big[k] = v
+	}
+	for i := range 30 {
+		big[strconv.Itoa(i)] = strings.Repeat("v", 255)
+	}
+	var ids []string
+	for range 60 {
+		ids = append(ids, put(t, s, "x", big))
+	}
+
+	after := int64(0)
+	resumed, _ := subscribe(t, s, &proto.SubscribeRequest{Labels: run, AfterSeq: &after})
+
+	// Meanwhile more events than its buffer holds are published one by one,
+	// as the live subscriber sees.
+	live, _ := subscribe(t, s, &proto.SubscribeRequest{Labels: run})
+	for range 10 {
+		id := put(t, s, "x", run)
+		if ev := recvEvents(t, live, 1)[0]; ev.GetObject().GetSocketId() != id {
+			t.Fatalf("live event for %s, want %s", ev.GetObject().GetSocketId(), id)
+		}
+		ids = append(ids, id)
+	}
+
+	// The resumed subscription gets all of them, then live events again.
+	ids = append(ids, "")
+	for i, ev := range recvEvents(t, resumed, len(ids)-1) {
+		if got := ev.GetObject().GetSocketId(); got != ids[i] {
+			t.Fatalf("event %d for %s, want %s", i, got, ids[i])
+		}
+	}
+	ids[len(ids)-1] = put(t, s, "x", run)
+	if ev := recvEvents(t, resumed, 1)[0]; ev.GetObject().GetSocketId() != ids[len(ids)-1] {
+		t.Errorf("live event after replay for %s, want %s", ev.GetObject().GetSocketId(), ids[len(ids)-1])
+	}
+}
+
+func TestSubscribeWaitsForEarlierWrite(t *testing.T) {
+	url := os.Getenv(servertest.DatabaseURLEnv)
+	if url == "" {
+		t.Skipf("%s is not set", servertest.DatabaseURLEnv)
+	}
+	s := servertest.Start(t)
+	ctx := context.Background()
+	db, err := pgxpool.New(ctx, url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(db.Close)
+
+	run := runLabel()
+	stream, _ := subscribe(t, s, &proto.SubscribeRequest{Labels: run})
+
+	// The first write takes its seq and stays open while a second commits.
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback(ctx)
+	var first string
+	err = tx.QueryRow(ctx, "INSERT INTO socket_data (id, data, labels) VALUES (gen_random_uuid(), 'a', $1) RETURNING id::text",
+		run).Scan(&first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second := put(t, s, "b", run)
+	time.Sleep(1500 * time.Millisecond) // past a poll of the hub
+	if err := tx.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, ev := range recvEvents(t, stream, 2) {
+		if want := []string{first, second}[i]; ev.GetObject().GetSocketId() != want {
+			t.Errorf("event %d for %s, want %s", i, ev.GetObject().GetSocketId(), want)
+		}
+	}
+}
+
+func TestSubscribeStop(t *testing.T) {
+	s := servertest.Start(t)
+	stream, _ := subscribe(t, s, &proto.SubscribeRequest{})
+
+	done := make(chan struct{})
+	go func() {
+		s.GRPC.Stop()
+		close(done)
+	}()
+	if _, err := stream.Recv(); status.Code(err) != codes.Unavailable {
+		t.Fatalf("got %v, want Unavailable", err)
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stop waited for the subscription")
+	}
+}
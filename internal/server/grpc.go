@@ -4,19 +4,30 @@ import (
 	"context"
 	"encoding/base64"
 	"errors"
-	"fmt"
 	"io"
 	"net"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	lowlevelfunctions "github.com/NikoMalik/low-level-functions"
+	"github.com/NikoMalik/potoc/internal/accesslog"
 	"github.com/NikoMalik/potoc/internal/config"
 	"github.com/NikoMalik/potoc/internal/logger"
 	"github.com/NikoMalik/potoc/internal/models"
 	"github.com/NikoMalik/potoc/internal/repository"
+	"github.com/NikoMalik/potoc/internal/tracing"
 	"github.com/NikoMalik/potoc/pkg/proto"
 	"github.com/NikoMalik/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
 )
 
 var (
@@ -24,23 +35,89 @@ var (
 	_errServerNotInit     = errors.New("server not init")
 )
 
+// accessLogFlushTimeout bounds how long stopping waits for queued access
+// log entries to reach the audit table.
+const accessLogFlushTimeout = 5 * time.Second
+
 var _ proto.DataTranferServer = (*dataTransferServer)(nil)
 
 type GRPC struct {
 	grpc               *grpc.Server
 	dataTransferServer *dataTransferServer
+	health             *Health
+	streams            *streamTracker
+	access             *accesslog.Log
+	events             *eventHub
+	webhooks           *webhookDispatcher
 }
 
 func NewGRPC(config *config.Config, repo *repository.Repositories) *GRPC {
-	grpc := grpc.NewServer(config.Server.Opts...)
-
-	dataTrans := NewTransfer(repo.SocketRepo)
+	streams := newStreamTracker()
+	opts := append([]grpc.ServerOption{}, config.Server.Opts...)
+
+	access := newAccessLog(config.AccessLog, repo)
+	if access != nil {
+		opts = append(opts,
+			grpc.ChainUnaryInterceptor(access.UnaryInterceptor),
+			grpc.ChainStreamInterceptor(access.StreamInterceptor),
+		)
+	}
+	opts = append(opts, grpc.ChainStreamInterceptor(streams.StreamInterceptor))
+	grpc := grpc.NewServer(opts...)
+
+	dataTrans := NewTransfer(repo.SocketRepo, newServerInfo(config))
+	dataTrans.txRepo = repo.TxRepo
+	dataTrans.SetLimits(config.Limits)
+	events := newEventHub(config.Events, repo.EventRepo)
+	dataTrans.events = events
+	dataTrans.queue, dataTrans.queueCfg = repo.QueueRepo, config.Queue
+	webhooks := newWebhookDispatcher(config.Webhooks, repo.WebhookRepo, events.changed)
+	dataTrans.webhookRepo, dataTrans.webhooks = repo.WebhookRepo, webhooks
+
+	var interval time.Duration
+	if config.Admin != nil {
+		interval = config.Admin.HealthCheckInterval
+	}
+	health := NewHealth(repo, interval)
 
 	proto.RegisterDataTranferServer(grpc, dataTrans)
+	healthpb.RegisterHealthServer(grpc, health.server)
+	if config.Server.Reflection {
+		reflection.Register(grpc)
+	}
 
 	return &GRPC{
 		grpc:               grpc,
 		dataTransferServer: dataTrans,
+		health:             health,
+		streams:            streams,
+		access:             access,
+		events:             events,
+		webhooks:           webhooks,
+	}
+}
+
+// newAccessLog returns nil when the access log is disabled.
+func newAccessLog(cfg *config.AccessLog, repo *repository.Repositories) *accesslog.Log {
+	if cfg == nil || cfg.Logger == nil {
+		return nil
+	}
+	var store accesslog.Store
+	if cfg.Audit {
+		store = repo.AccessLogRepo
+	}
+	return accesslog.New(cfg.Logger, store, cfg.AuditQueue)
+}
+
+// closeAccessLog flushes the access log once no more calls can finish.
+func (s *GRPC) closeAccessLog() {
+	if s.access == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), accessLogFlushTimeout)
+	defer cancel()
+	if err := s.access.Close(ctx); err != nil {
+		logger.Error("failed to flush access log", zap.Error(err))
 	}
 }
 
@@ -48,39 +125,164 @@ func (s *GRPC) Run(ln net.Listener) error {
 	if s.grpc == nil {
 		return _errServerNotInit
 	}
+	if s.events != nil {
+		go s.events.Run()
+	}
+	if s.webhooks != nil {
+		go s.webhooks.Run()
+	}
 	return s.grpc.Serve(ln)
 }
 
 func (s *GRPC) Stop() {
+	s.health.Shutdown()
+	s.stopStreams()
 	s.grpc.GracefulStop()
+	s.closeAccessLog()
+}
+
+// Drain stops accepting new streams, asks the open ones to finish and waits
+// for them until ctx is done. Streams still open at that point are cut off
+// and listed in the report.
+func (s *GRPC) Drain(ctx context.Context) *DrainReport {
+	s.health.Shutdown()
+
+	drained, aborted := s.streams.drain(ctx)
+	if len(aborted) > 0 {
+		s.grpc.Stop()
+	} else {
+		done := make(chan struct{})
+		go func() {
+			s.grpc.GracefulStop()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-ctx.Done():
+			s.grpc.Stop()
+			<-done
+		}
+	}
+
+	s.stopStreams()
+	s.closeAccessLog()
+
+	return &DrainReport{
+		Drained: drained,
+		Aborted: aborted,
+	}
+}
+
+// stopStreams ends the subscriptions and consumers, which would otherwise
+// keep a graceful stop waiting, and the webhook posts fed by the events.
+func (s *GRPC) stopStreams() {
+	if s.events != nil {
+		s.events.Stop()
+	}
+	if s.webhooks != nil {
+		s.webhooks.Stop()
+	}
+	s.dataTransferServer.stop()
+}
+
+// SetLimits applies new per stream limits to the running server.
+func (s *GRPC) SetLimits(limits *config.Limits) {
+	s.dataTransferServer.SetLimits(limits)
 }
 
 func (s *GRPC) PanicStop() {
+	s.health.Shutdown()
+	s.stopStreams()
 	s.grpc.Stop()
+	s.closeAccessLog()
 }
 
 type dataTransferServer struct {
 	proto.UnimplementedDataTranferServer
-	repo repository.SocketRepo
+	repo   repository.SocketRepo
+	txRepo repository.TxRepo
+	info   *proto.ServerInfo
+	limits atomic.Pointer[config.Limits]
+	events *eventHub
+
+	queue    repository.QueueRepo
+	queueCfg *config.Queue
+	// queued wakes the consumers of this server when an object may have
+	// become visible here. Other servers are heard of through events.
+	queued *broadcast
+
+	webhookRepo repository.WebhookRepo
+	webhooks    *webhookDispatcher
+
+	stopping chan struct{}
+	stopOnce sync.Once
 }
 
-func NewTransfer(repo repository.SocketRepo) *dataTransferServer {
+func NewTransfer(repo repository.SocketRepo, info *proto.ServerInfo) *dataTransferServer {
 	return &dataTransferServer{
-		repo: repo,
+		repo:     repo,
+		info:     info,
+		queued:   newBroadcast(),
+		stopping: make(chan struct{}),
 	}
 }
 
-func (d *dataTransferServer) GetData(stream proto.DataTranfer_GetDataServer) error {
+// stop ends the open Consume streams.
+func (d *dataTransferServer) stop() {
+	d.stopOnce.Do(func() { close(d.stopping) })
+}
+
+// SetLimits replaces the per stream limits, including for open streams.
+func (d *dataTransferServer) SetLimits(limits *config.Limits) {
+	d.limits.Store(limits)
+}
+
+// message is a request in flight between the receiving and the sending
+// goroutine of a stream, together with the span that covers it.
+type message struct {
+	ctx  context.Context
+	span trace.Span
+	data *models.SocketData
+	// begin and commit mark the first and the last message of a
+	// transactional upload, a commit carries no data.
+	begin, commit bool
+}
+
+// nextMessage returns the next message queued by the receiving goroutine.
+// When the server starts draining it first tells the client with a notice
+// and clears *drain. A nil message means the stream is done; err is then
+// the reason, _errTxExpired once expired fires, or nil once dataChannel
+// is closed.
+func nextMessage(stream grpc.BidiStreamingServer[proto.DataRequest, proto.DataResponse], drain *<-chan struct{}, dataChannel <-chan *message, expired <-chan time.Time) (*message, error) {
+	for {
+		select {
+		case <-expired:
+			return nil, _errTxExpired
+		case <-*drain:
+			*drain = nil
+			if err := stream.Send(drainNotice()); err != nil {
+				return nil, err
+			}
+		case msg, ok := <-dataChannel:
+			if !ok {
+				return nil, nil
+			}
+			return msg, nil
+		}
+	}
+}
 
-	dataChannel := make(chan *models.SocketData)
-	errChannel := make(chan error, 1)
+func (d *dataTransferServer) GetData(stream proto.DataTranfer_GetDataServer) error {
+	log := logger.FromContext(stream.Context())
+	dataChannel := make(chan *message)
+	errChannel := make(chan error, 2)
 
 	go func() {
+		defer close(dataChannel)
+		limiter := newStreamLimiter(&d.limits)
 		for {
 			req, err := stream.Recv()
 			if err == io.EOF {
-				close(dataChannel)
-				errChannel <- nil
 				return
 			}
 
@@ -88,38 +290,134 @@ func (d *dataTransferServer) GetData(stream proto.DataTranfer_GetDataServer) err
 				errChannel <- err
 				return
 			}
+			if err := limiter.wait(stream.Context()); err != nil {
+				errChannel <- err
+				return
+			}
+			ctx, span := tracing.Tracer().Start(stream.Context(), "DataTranfer.GetData/message",
+				trace.WithAttributes(attribute.Int("potoc.encoded_size", len(req.GetEncodedData()))),
+			)
+			_, decodeSpan := tracing.Tracer().Start(ctx, "base64.Decode")
 			decodedData, err := base64.StdEncoding.DecodeString(lowlevelfunctions.String(req.GetEncodedData()))
+			tracing.End(decodeSpan, err)
 			if err != nil {
-				errChannel <- fmt.Errorf("Faildef to decode base64: " + err.Error() + ", Input:" + lowlevelfunctions.String(req.GetEncodedData()))
+				tracing.End(span, err)
+				errChannel <- status.Errorf(codes.InvalidArgument, "failed to decode base64: %v", err)
+				return
+			}
+			if err := limiter.checkPayload(len(decodedData)); err != nil {
+				tracing.End(span, err)
+				errChannel <- err
 				return
 			}
 
-			socketData := &models.SocketData{
-				ID:   uuid.New(),
-				Data: decodedData,
+			if err := checkLabels(req.GetLabels()); err != nil {
+				tracing.End(span, err)
+				errChannel <- err
+				return
+			}
+			if err := checkQueue(req.GetQueue(), false); err != nil {
+				tracing.End(span, err)
+				errChannel <- err
+				return
+			}
+			if err := checkCommit(req); err != nil {
+				tracing.End(span, err)
+				errChannel <- err
+				return
+			}
+			msg := &message{ctx: ctx, span: span, begin: req.GetTransaction(), commit: req.GetCommit()}
+			if !msg.commit {
+				msg.data = &models.SocketData{
+					ID:     uuid.New(),
+					Data:   decodedData,
+					Labels: req.GetLabels(),
+					Queue:  req.GetQueue(),
+				}
 			}
 
-			dataChannel <- socketData
+			select {
+			case dataChannel <- msg:
+			case <-stream.Context().Done():
+				tracing.End(span, stream.Context().Err())
+				return
+			}
 
 		}
 	}()
 
 	go func() {
+		drain := drainSignal(stream.Context())
+		var tx *uploadTx
+		defer func() { tx.rollback() }()
+		for first := true; ; first = false {
+			msg, err := nextMessage(stream, &drain, dataChannel, tx.expired())
+			if msg == nil {
+				if err == nil && tx.open() {
+					err = _errTxNotCommitted
+				}
+				if errors.Is(err, _errTxExpired) {
+					err = tx.expiry
+				}
+				errChannel <- err
+				return
+			}
+
+			if msg.begin {
+				begun, err := d.beginUpload(msg.ctx, first)
+				if err != nil {
+					tracing.End(msg.span, err)
+					errChannel <- err
+					return
+				}
+				tx = begun
+			}
+			if msg.commit {
+				resp, err := d.commit(msg.ctx, tx)
+				if err == nil {
+					if tx.queued {
+						d.queued.notify()
+					}
+					log.Debug("Transaction committed", zap.Int("objects", tx.stored))
+					err = stream.Send(resp)
+				}
+				tracing.End(msg.span, err)
+				if err != nil {
+					errChannel <- err
+					return
+				}
+				continue
+			}
 
-		for socketData := range dataChannel {
+			socketData := msg.data
+			msg.span.SetAttributes(attribute.String("potoc.object_id", socketData.ID.String()))
 
-			_, err := d.repo.Create(stream.Context(), socketData)
+			if tx != nil {
+				err = tx.create(socketData, d.limits.Load())
+				tx.arm(d.limits.Load())
+			} else {
+				_, err = d.repo.Create(msg.ctx, socketData)
+			}
 			if err != nil {
+				tracing.End(msg.span, err)
 				errChannel <- err
 				return
 			}
 
-			logger.Debug("Data received and saved with ID: " + socketData.ID.String())
-			if err = stream.Send(&proto.DataResponse{
+			if tx == nil && socketData.Queue != "" {
+				d.queued.notify()
+			}
+			accesslog.Touch(msg.ctx, socketData.ID.String())
+			log.Debug("Data received and saved with ID: " + socketData.ID.String())
+			_, sendSpan := tracing.Tracer().Start(msg.ctx, "stream.Send")
+			err = stream.Send(&proto.DataResponse{
 				Status: "ok",
-				Msg:    "Data received and saved",
+				Msg:    tx.savedMsg(),
 				Data:   lowlevelfunctions.StringToBytes(socketData.ID.String()),
-			}); err != nil {
+			})
+			tracing.End(sendSpan, err)
+			tracing.End(msg.span, err)
+			if err != nil {
 				errChannel <- err
 				return
 			}
@@ -129,10 +427,10 @@ func (d *dataTransferServer) GetData(stream proto.DataTranfer_GetDataServer) err
 
 	if err := <-errChannel; err != nil {
 		if errors.Is(err, context.Canceled) || strings.Contains(err.Error(), _errCancelContextConn.Error()) {
-			logger.Info("Client calcel connection")
+			log.Info("Client calcel connection")
 			return nil
 		}
-		logger.Error(err.Error())
+		log.Error(err.Error())
 		return err
 	}
 
@@ -140,66 +438,93 @@ func (d *dataTransferServer) GetData(stream proto.DataTranfer_GetDataServer) err
 }
 
 func (d *dataTransferServer) FetchData(stream proto.DataTranfer_FetchDataServer) error {
-	dataChannel := make(chan *models.SocketData)
-	errChannel := make(chan error, 1)
+	log := logger.FromContext(stream.Context())
+	dataChannel := make(chan *message)
+	errChannel := make(chan error, 2)
 
 	go func() {
+		defer close(dataChannel)
+		limiter := newStreamLimiter(&d.limits)
 		for {
 			req, err := stream.Recv()
 			if err == io.EOF {
-				close(dataChannel)
-				errChannel <- nil
 				return
 			}
 			if err != nil {
 				errChannel <- err
 				return
 			}
+			if err := limiter.wait(stream.Context()); err != nil {
+				errChannel <- err
+				return
+			}
 
 			socketID := req.GetSocketId()
 			if err := stream.Context().Err(); err != nil {
-				logger.Info("Client disconnected before fetching data")
+				log.Info("Client disconnected before fetching data")
 				return
 			}
-			if socketID == "" {
-				errChannel <- errors.New("empty SocketId")
+			if err := checkID(socketID); err != nil {
+				errChannel <- err
 				return
 			}
-			socketData, err := d.repo.Get(stream.Context(), socketID)
+			ctx, span := tracing.Tracer().Start(stream.Context(), "DataTranfer.FetchData/message",
+				trace.WithAttributes(attribute.String("potoc.object_id", socketID)),
+			)
+			socketData, err := d.repo.Get(ctx, socketID)
 			if err != nil {
-				errChannel <- fmt.Errorf("Error fetching data for ID:" + socketID)
+				tracing.End(span, err)
+				errChannel <- objectError(socketID, err)
 				return
 			}
 
-			dataChannel <- socketData
+			select {
+			case dataChannel <- &message{ctx: ctx, span: span, data: socketData}:
+			case <-stream.Context().Done():
+				tracing.End(span, stream.Context().Err())
+				return
+			}
 		}
 	}()
 
 	go func() {
+		drain := drainSignal(stream.Context())
+		for {
+			msg, err := nextMessage(stream, &drain, dataChannel, nil)
+			if msg == nil {
+				errChannel <- err
+				return
+			}
 
-		for socketData := range dataChannel {
+			socketData := msg.data
+			_, encodeSpan := tracing.Tracer().Start(msg.ctx, "base64.Encode")
 			encodedData := base64.StdEncoding.EncodeToString(socketData.Data)
+			encodeSpan.End()
 
-			err := stream.Send(&proto.DataResponse{
+			_, sendSpan := tracing.Tracer().Start(msg.ctx, "stream.Send")
+			err = stream.Send(&proto.DataResponse{
 				Status: "ok",
 				Msg:    "success fetched",
 				Data:   []byte(encodedData),
 			})
+			tracing.End(sendSpan, err)
+			tracing.End(msg.span, err)
 			if err != nil {
 				errChannel <- err
 				return
 			}
 
-			logger.Debug("Data sent to client with ID: " + socketData.ID.String())
+			accesslog.Touch(msg.ctx, socketData.ID.String())
+			log.Debug("Data sent to client with ID: " + socketData.ID.String())
 		}
 	}()
 
 	if err := <-errChannel; err != nil {
 		if errors.Is(err, context.Canceled) || strings.Contains(err.Error(), _errCancelContextConn.Error()) {
-			logger.Info("Client calcel connection")
+			log.Info("Client calcel connection")
 			return nil
 		}
-		logger.Error(err.Error())
+		log.Error(err.Error())
 		return err
 	}
 
@@ -0,0 +1,356 @@
+package server_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/NikoMalik/potoc/internal/config"
+	"github.com/NikoMalik/potoc/internal/servertest"
+	"github.com/NikoMalik/potoc/pkg/proto"
+	"github.com/NikoMalik/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func encode(s string) []byte {
+	return []byte(base64.StdEncoding.EncodeToString([]byte(s)))
+}
+
+func decode(t testing.TB, b []byte) string {
+	t.Helper()
+	data, err := base64.StdEncoding.DecodeString(string(b))
+	if err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	return string(data)
+}
+
+// roundTrips sends each request on stream and waits for its response. It
+// stops at the first error and returns the responses received before it.
+func roundTrips(stream interface {
+	Send(*proto.DataRequest) error
+	Recv() (*proto.DataResponse, error)
+}, reqs []*proto.DataRequest) ([]*proto.DataResponse, error) {
+	var resps []*proto.DataResponse
+	for _, req := range reqs {
+		if err := stream.Send(req); err != nil && err != io.EOF {
+			return resps, err
+		}
+		resp, err := stream.Recv()
+		if err != nil {
+			return resps, err
+		}
+		resps = append(resps, resp)
+	}
+	return resps, nil
+}
+
+func TestGetData(t *testing.T) {
+	tests := []struct {
+		name     string
+		limits   *config.Limits
+		reqs     []*proto.DataRequest
+		want     []string
+		wantCode codes.Code
+	}{
+		{
+			name: "one object",
+			reqs: []*proto.DataRequest{{EncodedData: encode("hello")}},
+			want: []string{"hello"},
+		},
+		{
+			name: "several objects",
+			reqs: []*proto.DataRequest{{EncodedData: encode("a")}, {EncodedData: encode("b")}, {EncodedData: encode("c")}},
+			want: []string{"a", "b", "c"},
+		},
+		{
+			name: "empty payload",
+			reqs: []*proto.DataRequest{{}},
+			want: []string{""},
+		},
+		{
+			name: "labels",
+			reqs: []*proto.DataRequest{{EncodedData: encode("x"), Labels: map[string]string{"env": "test"}}},
+			want: []string{"x"},
+		},
+		{
+			name:     "bad base64",
+			reqs:     []*proto.DataRequest{{EncodedData: []byte("not base64!")}},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name:     "bad base64 after a good message",
+			reqs:     []*proto.DataRequest{{EncodedData: encode("ok")}, {EncodedData: []byte("%%%")}},
+			want:     []string{"ok"},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name:     "empty label key",
+			reqs:     []*proto.DataRequest{{EncodedData: encode("x"), Labels: map[string]string{"": "v"}}},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name:   "payload at the limit",
+			limits: &config.Limits{MaxPayloadBytes: 4},
+			reqs:   []*proto.DataRequest{{EncodedData: encode("abcd")}},
+			want:   []string{"abcd"},
+		},
+		{
+			name:     "payload over the limit",
+			limits:   &config.Limits{MaxPayloadBytes: 4},
+			reqs:     []*proto.DataRequest{{EncodedData: encode("hello world")}},
+			wantCode: codes.ResourceExhausted,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var opts []servertest.Option
+			if tt.limits != nil {
+				opts = append(opts, servertest.WithLimits(tt.limits))
+			}
+			s := servertest.Start(t, opts...)
+			ctx := context.Background()
+
+			stream, err := s.API.GetData(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resps, err := roundTrips(stream, tt.reqs)
+			if code := status.Code(err); code != tt.wantCode {
+				t.Fatalf("got code %v (%v), want %v", code, err, tt.wantCode)
+			}
+			if err == nil {
+				stream.CloseSend()
+				if _, err := stream.Recv(); err != io.EOF {
+					t.Fatalf("stream did not end cleanly: %v", err)
+				}
+			}
+
+			if len(resps) != len(tt.want) {
+				t.Fatalf("got %d responses, want %d", len(resps), len(tt.want))
+			}
+			for i, resp := range resps {
+				if resp.GetStatus() != "ok" {
+					t.Errorf("response %d: status %q", i, resp.GetStatus())
+				}
+				obj, err := s.Repos.SocketRepo.Get(ctx, string(resp.GetData()))
+				if err != nil {
+					t.Fatalf("object %d not stored: %v", i, err)
+				}
+				if string(obj.Data) != tt.want[i] {
+					t.Errorf("object %d: stored %q, want %q", i, obj.Data, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestGetDataCancelled(t *testing.T) {
+	s := servertest.Start(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := s.API.GetData(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := roundTrips(stream, []*proto.DataRequest{{EncodedData: encode("before")}}); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	if _, err := stream.Recv(); status.Code(err) != codes.Canceled {
+		t.Fatalf("got %v, want Canceled", err)
+	}
+
+	// The server carries on with other streams.
+	stream, err = s.API.GetData(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := roundTrips(stream, []*proto.DataRequest{{EncodedData: encode("after")}}); err != nil {
+		t.Fatalf("stream after a cancelled one: %v", err)
+	}
+	stream.CloseSend()
+}
+
+func TestFetchData(t *testing.T) {
+	s := servertest.Start(t)
+	ctx := context.Background()
+
+	id, err := s.Client.Put(ctx, bytes.NewReader([]byte("hello")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	missing := uuid.New().String()
+
+	tests := []struct {
+		name     string
+		ids      []string
+		want     []string
+		wantCode codes.Code
+	}{
+		{name: "existing", ids: []string{id}, want: []string{"hello"}},
+		{name: "same id twice", ids: []string{id, id}, want: []string{"hello", "hello"}},
+		{name: "empty id", ids: []string{""}, wantCode: codes.InvalidArgument},
+		{name: "not a uuid", ids: []string{"nope"}, wantCode: codes.InvalidArgument},
+		{name: "missing id", ids: []string{missing}, wantCode: codes.NotFound},
+		{name: "missing after existing", ids: []string{id, missing}, want: []string{"hello"}, wantCode: codes.NotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stream, err := s.API.FetchData(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			reqs := make([]*proto.DataRequest, len(tt.ids))
+			for i, id := range tt.ids {
+				reqs[i] = &proto.DataRequest{SocketId: id}
+			}
+
+			resps, err := roundTrips(stream, reqs)
+			if code := status.Code(err); code != tt.wantCode {
+				t.Fatalf("got code %v (%v), want %v", code, err, tt.wantCode)
+			}
+			if err == nil {
+				stream.CloseSend()
+			}
+			if len(resps) != len(tt.want) {
+				t.Fatalf("got %d responses, want %d", len(resps), len(tt.want))
+			}
+			for i, resp := range resps {
+				if got := decode(t, resp.GetData()); got != tt.want[i] {
+					t.Errorf("response %d: got %q, want %q", i, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestFetchDataCancelled(t *testing.T) {
+	s := servertest.Start(t)
+
+	id, err := s.Client.Put(context.Background(), bytes.NewReader([]byte("hello")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := s.API.FetchData(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := roundTrips(stream, []*proto.DataRequest{{SocketId: id}}); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	if _, err := stream.Recv(); status.Code(err) != codes.Canceled {
+		t.Fatalf("got %v, want Canceled", err)
+	}
+}
+
+var benchSizes = []int{1 << 10, 64 << 10, 512 << 10}
+
+func sizeName(n int) string {
+	if n >= 1<<10 {
+		return fmt.Sprintf("%dKiB", n>>10)
+	}
+	return fmt.Sprintf("%dB", n)
+}
+
+func randomPayload(b *testing.B, n int) []byte {
+	data := make([]byte, n)
+	if _, err := rand.Read(data); err != nil {
+		b.Fatal(err)
+	}
+	return data
+}
+
+// BenchmarkGetData measures one stream storing messages back to back.
+func BenchmarkGetData(b *testing.B) {
+	for _, size := range benchSizes {
+		b.Run(sizeName(size), func(b *testing.B) {
+			s := servertest.Start(b)
+			stream, err := s.API.GetData(context.Background())
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer stream.CloseSend()
+			req := &proto.DataRequest{EncodedData: []byte(base64.StdEncoding.EncodeToString(randomPayload(b, size)))}
+
+			b.SetBytes(int64(size))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := stream.Send(req); err != nil {
+					b.Fatal(err)
+				}
+				if _, err := stream.Recv(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkGetDataParallel measures streams storing messages concurrently,
+// one per goroutine.
+func BenchmarkGetDataParallel(b *testing.B) {
+	s := servertest.Start(b)
+	req := &proto.DataRequest{EncodedData: []byte(base64.StdEncoding.EncodeToString(randomPayload(b, 4<<10)))}
+
+	b.SetBytes(4 << 10)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		stream, err := s.API.GetData(context.Background())
+		if err != nil {
+			b.Error(err)
+			return
+		}
+		defer stream.CloseSend()
+		for pb.Next() {
+			if err := stream.Send(req); err != nil {
+				b.Error(err)
+				return
+			}
+			if _, err := stream.Recv(); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+// BenchmarkFetchData measures one stream fetching the same object.
+func BenchmarkFetchData(b *testing.B) {
+	for _, size := range benchSizes {
+		b.Run(sizeName(size), func(b *testing.B) {
+			s := servertest.Start(b)
+			id, err := s.Client.Put(context.Background(), bytes.NewReader(randomPayload(b, size)))
+			if err != nil {
+				b.Fatal(err)
+			}
+			stream, err := s.API.FetchData(context.Background())
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer stream.CloseSend()
+			req := &proto.DataRequest{SocketId: id}
+
+			b.SetBytes(int64(size))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := stream.Send(req); err != nil {
+					b.Fatal(err)
+				}
+				if _, err := stream.Recv(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
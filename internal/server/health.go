@@ -0,0 +1,135 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/NikoMalik/potoc/internal/logger"
+	"github.com/NikoMalik/potoc/pkg/proto"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+const (
+	defaultHealthCheckInterval = 5 * time.Second
+	healthCheckTimeout         = 2 * time.Second
+)
+
+// Pinger reports whether a dependency the server needs is reachable.
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// Health drives the grpc.health.v1 service and the admin readiness probe
+// from periodic database pings. Once Shutdown is called it reports
+// NOT_SERVING for good.
+type Health struct {
+	server   *health.Server
+	pinger   Pinger
+	interval time.Duration
+
+	ready    atomic.Bool
+	draining atomic.Bool
+	done     chan struct{}
+	once     sync.Once
+}
+
+func NewHealth(pinger Pinger, interval time.Duration) *Health {
+	if interval <= 0 {
+		interval = defaultHealthCheckInterval
+	}
+	h := &Health{
+		server:   health.NewServer(),
+		pinger:   pinger,
+		interval: interval,
+		done:     make(chan struct{}),
+	}
+	h.set(false)
+
+	return h
+}
+
+// Run pings the database until Shutdown, flipping readiness on every
+// change.
+func (h *Health) Run() {
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+
+	for {
+		h.check()
+		select {
+		case <-h.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (h *Health) check() {
+	ctx, cancel := context.WithTimeout(context.Background(), healthCheckTimeout)
+	defer cancel()
+
+	err := h.pinger.Ping(ctx)
+	if ready := err == nil; ready != h.ready.Load() {
+		if ready {
+			logger.Info("Database reachable, server is ready")
+		} else {
+			logger.Warn("Database unreachable, server is not ready", zap.Error(err))
+		}
+		h.set(ready)
+	}
+}
+
+func (h *Health) set(ready bool) {
+	if h.draining.Load() {
+		return
+	}
+	h.ready.Store(ready)
+
+	status := healthpb.HealthCheckResponse_NOT_SERVING
+	if ready {
+		status = healthpb.HealthCheckResponse_SERVING
+	}
+	h.server.SetServingStatus("", status)
+	h.server.SetServingStatus(proto.DataTranfer_ServiceDesc.ServiceName, status)
+}
+
+// Shutdown marks the server as draining. Health watchers are told
+// NOT_SERVING and later pings are ignored.
+func (h *Health) Shutdown() {
+	h.once.Do(func() {
+		h.draining.Store(true)
+		h.ready.Store(false)
+		h.server.Shutdown()
+		close(h.done)
+	})
+}
+
+// Ready reports whether the server should receive traffic.
+func (h *Health) Ready() bool {
+	return h.ready.Load() && !h.draining.Load()
+}
+
+// Handler serves /healthz (liveness) and /readyz (readiness).
+func (h *Health) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("ok"))
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if !h.Ready() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte("not ready"))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("ready"))
+	})
+
+	return mux
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"context"
+
+	"github.com/NikoMalik/potoc/internal/config"
+	"github.com/NikoMalik/potoc/internal/version"
+	"github.com/NikoMalik/potoc/pkg/proto"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/encoding/gzip"
+)
+
+const (
+	featureHealth     = "health"
+	featureReflection = "reflection"
+	featureTracing    = "tracing"
+
+	encodingBase64 = "base64"
+)
+
+// knownCompressors are the compressors potoc may have registered; only the
+// ones actually present in the encoding registry are reported.
+var knownCompressors = []string{gzip.Name}
+
+func newServerInfo(config *config.Config) *proto.ServerInfo {
+	build := version.ReadBuild()
+
+	info := &proto.ServerInfo{
+		Version: version.Version,
+		Build: &proto.BuildInfo{
+			GoVersion: build.GoVersion,
+			Commit:    build.Commit,
+			BuildTime: build.Time,
+			Modified:  build.Modified,
+		},
+		Encodings: []string{encodingBase64},
+		Limits: &proto.Limits{
+			MaxRecvMsgSize:        int64(config.Server.MaxRecvMsgSize),
+			MaxConcurrentStreams:  config.Server.MaxStreams,
+			InitialWindowSize:     config.Server.InitialWindowSize,
+			InitialConnWindowSize: config.Server.InitialConnWindowSize,
+			MaxHeaderListSize:     config.Server.MaxHeaderListSize,
+		},
+		Features: []string{featureHealth},
+	}
+
+	for _, name := range knownCompressors {
+		if encoding.GetCompressor(name) != nil {
+			info.Compression = append(info.Compression, name)
+		}
+	}
+	if config.Server.Reflection {
+		info.Features = append(info.Features, featureReflection)
+	}
+	if config.Tracing != nil && config.Tracing.Enabled {
+		info.Features = append(info.Features, featureTracing)
+	}
+
+	return info
+}
+
+func (d *dataTransferServer) GetServerInfo(context.Context, *proto.ServerInfoRequest) (*proto.ServerInfo, error) {
+	return d.info, nil
+}
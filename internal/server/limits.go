@@ -0,0 +1,51 @@
+package server
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/NikoMalik/potoc/internal/config"
+	"golang.org/x/time/rate"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// streamLimiter enforces config.Limits on a single stream. It rereads the
+// shared limits on every message, so a config reload reaches streams that
+// are already open.
+type streamLimiter struct {
+	limits  *atomic.Pointer[config.Limits]
+	current *config.Limits
+	rate    *rate.Limiter
+}
+
+func newStreamLimiter(limits *atomic.Pointer[config.Limits]) *streamLimiter {
+	return &streamLimiter{
+		limits: limits,
+		rate:   rate.NewLimiter(rate.Inf, 0),
+	}
+}
+
+// wait blocks until the stream may handle another message, or fails if
+// ctx is done.
+func (l *streamLimiter) wait(ctx context.Context) error {
+	limits := l.limits.Load()
+	if limits != l.current {
+		l.current = limits
+		l.rate.SetLimit(rate.Inf)
+		if limits != nil && limits.MessagesPerSecond > 0 {
+			l.rate.SetLimit(rate.Limit(limits.MessagesPerSecond))
+			l.rate.SetBurst(max(limits.Burst, 1))
+		}
+	}
+	return l.rate.Wait(ctx)
+}
+
+// checkPayload fails if a decoded payload of size bytes is over the limit
+// in force for the last message wait let through.
+func (l *streamLimiter) checkPayload(size int) error {
+	if limits := l.current; limits != nil && limits.MaxPayloadBytes > 0 && size > limits.MaxPayloadBytes {
+		return status.Errorf(codes.ResourceExhausted, "payload of %d bytes exceeds the limit of %d", size, limits.MaxPayloadBytes)
+	}
+	return nil
+}
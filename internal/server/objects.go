@@ -0,0 +1,144 @@
+package server
+
+import (
+	"context"
+	"errors"
+
+	"github.com/NikoMalik/potoc/internal/accesslog"
+	"github.com/NikoMalik/potoc/internal/models"
+	"github.com/NikoMalik/potoc/internal/repository"
+	"github.com/NikoMalik/potoc/pkg/proto"
+	"github.com/NikoMalik/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+
+	maxLabels     = 32
+	maxLabelKey   = 63
+	maxLabelValue = 255
+)
+
+func (d *dataTransferServer) Delete(ctx context.Context, req *proto.DeleteRequest) (*proto.DeleteResponse, error) {
+	id := req.GetSocketId()
+	if err := checkID(id); err != nil {
+		return nil, err
+	}
+
+	if err := d.repo.Delete(ctx, id); err != nil {
+		return nil, objectError(id, err)
+	}
+	accesslog.Touch(ctx, id)
+
+	return &proto.DeleteResponse{}, nil
+}
+
+func (d *dataTransferServer) List(ctx context.Context, req *proto.ListRequest) (*proto.ListResponse, error) {
+	size := int(req.GetPageSize())
+	switch {
+	case size < 0:
+		return nil, status.Errorf(codes.InvalidArgument, "negative page size %d", size)
+	case size == 0:
+		size = defaultPageSize
+	case size > maxPageSize:
+		size = maxPageSize
+	}
+
+	// The page token is the id of the last object on the previous page.
+	token := req.GetPageToken()
+	if token != "" {
+		if _, err := uuid.ParseString(token); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid page token %q", token)
+		}
+	}
+
+	if err := checkLabels(req.GetLabels()); err != nil {
+		return nil, err
+	}
+
+	objects, err := d.repo.List(ctx, repository.ListFilter{
+		After:  token,
+		Limit:  size,
+		Labels: req.GetLabels(),
+	})
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to list objects")
+	}
+
+	resp := &proto.ListResponse{Objects: make([]*proto.ObjectInfo, len(objects))}
+	for i := range objects {
+		resp.Objects[i] = objectInfo(&objects[i])
+	}
+	if len(objects) == size {
+		resp.NextPageToken = objects[len(objects)-1].ID
+	}
+
+	return resp, nil
+}
+
+func (d *dataTransferServer) Stat(ctx context.Context, req *proto.StatRequest) (*proto.ObjectInfo, error) {
+	id := req.GetSocketId()
+	if err := checkID(id); err != nil {
+		return nil, err
+	}
+
+	info, err := d.repo.Stat(ctx, id)
+	if err != nil {
+		return nil, objectError(id, err)
+	}
+	accesslog.Touch(ctx, id)
+
+	return objectInfo(info), nil
+}
+
+func objectInfo(o *models.ObjectInfo) *proto.ObjectInfo {
+	info := &proto.ObjectInfo{
+		SocketId: o.ID,
+		Size:     o.Size,
+		Labels:   o.Labels,
+		Queue:    o.Queue,
+	}
+	if !o.CreatedAt.IsZero() {
+		info.CreatedAt = o.CreatedAt.UnixNano()
+	}
+	return info
+}
+
+// checkLabels keeps labels small enough to index and print.
+func checkLabels(labels map[string]string) error {
+	if len(labels) > maxLabels {
+		return status.Errorf(codes.InvalidArgument, "%d labels, at most %d allowed", len(labels), maxLabels)
+	}
+	for k, v := range labels {
+		if k == "" || len(k) > maxLabelKey {
+			return status.Errorf(codes.InvalidArgument, "label key %q must be 1 to %d bytes", k, maxLabelKey)
+		}
+		if len(v) > maxLabelValue {
+			return status.Errorf(codes.InvalidArgument, "label %q: value longer than %d bytes", k, maxLabelValue)
+		}
+	}
+	return nil
+}
+
+// checkID rejects ids that cannot name an object before they reach the
+// database.
+func checkID(id string) error {
+	if id == "" {
+		return status.Error(codes.InvalidArgument, "empty SocketId")
+	}
+	if _, err := uuid.ParseString(id); err != nil {
+		return status.Errorf(codes.InvalidArgument, "invalid SocketId %q", id)
+	}
+	return nil
+}
+
+// objectError converts a repository error about object id to a status.
+func objectError(id string, err error) error {
+	if errors.Is(err, repository.ErrNotFound) {
+		return status.Errorf(codes.NotFound, "no object with id %s", id)
+	}
+	return status.Errorf(codes.Internal, "failed to access object %s", id)
+}
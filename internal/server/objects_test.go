@@ -0,0 +1,174 @@
+package server_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/NikoMalik/potoc/internal/servertest"
+	"github.com/NikoMalik/potoc/pkg/client"
+	"github.com/NikoMalik/potoc/pkg/proto"
+	"github.com/NikoMalik/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// runLabel tells the objects of one test apart from the others in a shared
+// database.
+func runLabel() map[string]string {
+	return map[string]string{"test-run": uuid.New().String()}
+}
+
+func put(t *testing.T, s *servertest.Server, data string, labels map[string]string) string {
+	t.Helper()
+	id, err := s.Client.Put(context.Background(), strings.NewReader(data), client.WithLabels(labels))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func TestList(t *testing.T) {
+	s := servertest.Start(t)
+	ctx := context.Background()
+
+	run := runLabel()
+	even := map[string]string{"parity": "even"}
+	for k, v := range run {
+		even[k] = v
+	}
+	var evens []string
+	for i := range 5 {
+		labels := run
+		if i%2 == 0 {
+			labels = even
+		}
+		id := put(t, s, strings.Repeat("x", i), labels)
+		if i%2 == 0 {
+			evens = append(evens, id)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		pageSize int32
+		labels   map[string]string
+		token    string
+		want     int
+		wantCode codes.Code
+	}{
+		{name: "all in one page", labels: run, want: 5},
+		{name: "by label", labels: even, want: len(evens)},
+		{name: "no match", labels: map[string]string{"test-run": "none"}, want: 0},
+		{name: "paged", pageSize: 2, labels: run, want: 5},
+		{name: "negative page size", pageSize: -1, labels: run, wantCode: codes.InvalidArgument},
+		{name: "bad page token", token: "nope", labels: run, wantCode: codes.InvalidArgument},
+		{name: "bad label", labels: map[string]string{strings.Repeat("k", 64): "v"}, wantCode: codes.InvalidArgument},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []*proto.ObjectInfo
+			token := tt.token
+			for {
+				resp, err := s.API.List(ctx, &proto.ListRequest{PageSize: tt.pageSize, PageToken: token, Labels: tt.labels})
+				if code := status.Code(err); code != tt.wantCode {
+					t.Fatalf("got code %v (%v), want %v", code, err, tt.wantCode)
+				}
+				if err != nil {
+					return
+				}
+				if tt.pageSize > 0 && len(resp.GetObjects()) > int(tt.pageSize) {
+					t.Fatalf("page of %d objects, asked for %d", len(resp.GetObjects()), tt.pageSize)
+				}
+				got = append(got, resp.GetObjects()...)
+				if token = resp.GetNextPageToken(); token == "" {
+					break
+				}
+			}
+
+			if len(got) != tt.want {
+				t.Fatalf("got %d objects, want %d", len(got), tt.want)
+			}
+			for i := 1; i < len(got); i++ {
+				if got[i-1].GetSocketId() >= got[i].GetSocketId() {
+					t.Fatalf("objects not ordered by id at %d", i)
+				}
+			}
+		})
+	}
+}
+
+func TestStat(t *testing.T) {
+	s := servertest.Start(t)
+	ctx := context.Background()
+
+	labels := runLabel()
+	before := time.Now().Add(-time.Second)
+	id := put(t, s, "hello", labels)
+
+	tests := []struct {
+		name     string
+		id       string
+		wantCode codes.Code
+	}{
+		{name: "existing", id: id},
+		{name: "empty id", id: "", wantCode: codes.InvalidArgument},
+		{name: "not a uuid", id: "nope", wantCode: codes.InvalidArgument},
+		{name: "missing id", id: uuid.New().String(), wantCode: codes.NotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := s.API.Stat(ctx, &proto.StatRequest{SocketId: tt.id})
+			if code := status.Code(err); code != tt.wantCode {
+				t.Fatalf("got code %v (%v), want %v", code, err, tt.wantCode)
+			}
+			if err != nil {
+				return
+			}
+			if info.GetSocketId() != id || info.GetSize() != 5 {
+				t.Errorf("got id %s size %d, want %s size 5", info.GetSocketId(), info.GetSize(), id)
+			}
+			if info.GetLabels()["test-run"] != labels["test-run"] {
+				t.Errorf("got labels %v, want %v", info.GetLabels(), labels)
+			}
+			if created := time.Unix(0, info.GetCreatedAt()); created.Before(before) {
+				t.Errorf("created at %v, before the test started", created)
+			}
+		})
+	}
+}
+
+func TestDelete(t *testing.T) {
+	s := servertest.Start(t)
+	ctx := context.Background()
+
+	id := put(t, s, "hello", nil)
+
+	tests := []struct {
+		name     string
+		id       string
+		wantCode codes.Code
+	}{
+		{name: "existing", id: id},
+		{name: "already deleted", id: id, wantCode: codes.NotFound},
+		{name: "empty id", id: "", wantCode: codes.InvalidArgument},
+		{name: "not a uuid", id: "nope", wantCode: codes.InvalidArgument},
+	}
+
+	// The cases run in order: the second deletes what the first did.
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := s.API.Delete(ctx, &proto.DeleteRequest{SocketId: tt.id})
+			if code := status.Code(err); code != tt.wantCode {
+				t.Fatalf("got code %v (%v), want %v", code, err, tt.wantCode)
+			}
+		})
+	}
+
+	if _, err := s.Repos.SocketRepo.Get(ctx, id); err == nil {
+		t.Fatal("object still stored after Delete")
+	}
+}
@@ -0,0 +1,263 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/NikoMalik/potoc/internal/accesslog"
+	"github.com/NikoMalik/potoc/internal/logger"
+	"github.com/NikoMalik/potoc/internal/models"
+	"github.com/NikoMalik/potoc/internal/repository"
+	"github.com/NikoMalik/potoc/pkg/proto"
+	"github.com/NikoMalik/uuid"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	maxQueueName = 63
+	// releaseTimeout bounds returning the leases of a finished consumer.
+	releaseTimeout = 5 * time.Second
+)
+
+var _errQueuesDisabled = status.Error(codes.Unimplemented, "queues are disabled on this server")
+
+// broadcast wakes every goroutine waiting on it at once.
+type broadcast struct {
+	mu sync.Mutex
+	ch chan struct{}
+}
+
+func newBroadcast() *broadcast {
+	return &broadcast{ch: make(chan struct{})}
+}
+
+// wait returns a channel closed by the next notify.
+func (b *broadcast) wait() <-chan struct{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.ch
+}
+
+func (b *broadcast) notify() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	close(b.ch)
+	b.ch = make(chan struct{})
+}
+
+func (d *dataTransferServer) Consume(req *proto.ConsumeRequest, stream grpc.ServerStreamingServer[proto.Delivery]) error {
+	if d.queue == nil || d.queueCfg == nil {
+		return _errQueuesDisabled
+	}
+	queue := req.GetQueue()
+	if err := checkQueue(queue, true); err != nil {
+		return err
+	}
+	visibility, prefetch, err := d.consumeParams(req)
+	if err != nil {
+		return err
+	}
+
+	ctx := stream.Context()
+	log := logger.FromContext(ctx)
+	consumer := uuid.New().String()
+	defer d.release(log, consumer)
+
+	drain := drainSignal(ctx)
+	poll := time.NewTicker(d.queueCfg.PollInterval)
+	defer poll.Stop()
+
+	for {
+		// Taken before looking, so that a wake while claiming is not lost.
+		changed, queued := d.events.changed(), d.queued.wait()
+
+		claimed, err := d.deliver(ctx, stream, queue, consumer, prefetch, visibility)
+		if err != nil {
+			return err
+		}
+		if claimed {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		case <-drain:
+			return _errDraining
+		case <-d.stopping:
+			return _errStopping
+		case <-changed:
+		case <-queued:
+		case <-poll.C:
+		}
+	}
+}
+
+// deliver leases as many objects as the consumer has room for and sends
+// them. It reports whether it filled that room, so there may be more.
+func (d *dataTransferServer) deliver(ctx context.Context, stream grpc.ServerStreamingServer[proto.Delivery], queue, consumer string, prefetch int, visibility time.Duration) (bool, error) {
+	inFlight, err := d.queue.InFlight(ctx, consumer)
+	if err != nil {
+		return false, queueError(ctx, err)
+	}
+	room := prefetch - inFlight
+	if room <= 0 {
+		return false, nil
+	}
+
+	leases, err := d.queue.Claim(ctx, queue, consumer, room, visibility)
+	if err != nil {
+		return false, queueError(ctx, err)
+	}
+	for i := range leases {
+		if err := stream.Send(delivery(&leases[i])); err != nil {
+			return false, err
+		}
+		accesslog.Touch(ctx, leases[i].Object.ID)
+	}
+	return len(leases) == room, nil
+}
+
+// release returns the unacked objects of a finished consumer to the queue
+// rather than leaving them hidden until their leases expire.
+func (d *dataTransferServer) release(log *zap.Logger, consumer string) {
+	ctx, cancel := context.WithTimeout(context.Background(), releaseTimeout)
+	defer cancel()
+
+	n, err := d.queue.Release(ctx, consumer)
+	if err != nil {
+		log.Warn("failed to release leases", zap.String("consumer", consumer), zap.Error(err))
+		return
+	}
+	if n > 0 {
+		d.queued.notify()
+		log.Debug("Released leases", zap.String("consumer", consumer), zap.Int64("count", n))
+	}
+}
+
+func (d *dataTransferServer) consumeParams(req *proto.ConsumeRequest) (time.Duration, int, error) {
+	cfg := d.queueCfg
+
+	visibility := time.Duration(req.GetVisibilityTimeoutMs()) * time.Millisecond
+	switch {
+	case visibility < 0:
+		return 0, 0, status.Errorf(codes.InvalidArgument, "negative visibility timeout %s", visibility)
+	case visibility == 0:
+		visibility = cfg.VisibilityTimeout
+	case visibility > cfg.MaxVisibilityTimeout:
+		return 0, 0, status.Errorf(codes.InvalidArgument, "visibility timeout %s over the maximum %s", visibility, cfg.MaxVisibilityTimeout)
+	}
+
+	prefetch := int(req.GetPrefetch())
+	switch {
+	case prefetch < 0:
+		return 0, 0, status.Errorf(codes.InvalidArgument, "negative prefetch %d", prefetch)
+	case prefetch == 0:
+		prefetch = cfg.Prefetch
+	case prefetch > cfg.MaxPrefetch:
+		prefetch = cfg.MaxPrefetch
+	}
+	return visibility, prefetch, nil
+}
+
+func (d *dataTransferServer) Ack(ctx context.Context, req *proto.AckRequest) (*proto.AckResponse, error) {
+	if d.queue == nil {
+		return nil, _errQueuesDisabled
+	}
+	id, leaseID := req.GetSocketId(), req.GetLeaseId()
+	if err := checkLease(id, leaseID); err != nil {
+		return nil, err
+	}
+
+	if err := d.queue.Ack(ctx, id, leaseID); err != nil {
+		return nil, leaseError(id, err)
+	}
+	accesslog.Touch(ctx, id)
+
+	return &proto.AckResponse{}, nil
+}
+
+func (d *dataTransferServer) Nack(ctx context.Context, req *proto.NackRequest) (*proto.NackResponse, error) {
+	if d.queue == nil || d.queueCfg == nil {
+		return nil, _errQueuesDisabled
+	}
+	id, leaseID := req.GetSocketId(), req.GetLeaseId()
+	if err := checkLease(id, leaseID); err != nil {
+		return nil, err
+	}
+	delay := time.Duration(req.GetDelayMs()) * time.Millisecond
+	if delay < 0 || delay > d.queueCfg.MaxVisibilityTimeout {
+		return nil, status.Errorf(codes.InvalidArgument, "delay %s must be between 0 and %s", delay, d.queueCfg.MaxVisibilityTimeout)
+	}
+
+	if err := d.queue.Nack(ctx, id, leaseID, delay); err != nil {
+		return nil, leaseError(id, err)
+	}
+	if delay == 0 {
+		d.queued.notify()
+	}
+	accesslog.Touch(ctx, id)
+
+	return &proto.NackResponse{}, nil
+}
+
+func delivery(l *models.Lease) *proto.Delivery {
+	return &proto.Delivery{
+		Object:         objectInfo(&l.Object),
+		Data:           l.Data,
+		LeaseId:        l.LeaseID,
+		Attempt:        int32(l.Attempt),
+		LeaseExpiresAt: l.Until.UnixNano(),
+	}
+}
+
+// checkQueue keeps queue names short and printable. An empty name is only
+// valid where the queue is optional.
+func checkQueue(name string, required bool) error {
+	if name == "" {
+		if required {
+			return status.Error(codes.InvalidArgument, "empty queue name")
+		}
+		return nil
+	}
+	if len(name) > maxQueueName {
+		return status.Errorf(codes.InvalidArgument, "queue name longer than %d bytes", maxQueueName)
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.') {
+			return status.Errorf(codes.InvalidArgument, "queue name %q may only hold letters, digits, '-', '_' and '.'", name)
+		}
+	}
+	return nil
+}
+
+func checkLease(id, leaseID string) error {
+	if err := checkID(id); err != nil {
+		return err
+	}
+	if _, err := uuid.ParseString(leaseID); err != nil {
+		return status.Errorf(codes.InvalidArgument, "invalid lease id %q", leaseID)
+	}
+	return nil
+}
+
+// leaseError converts an Ack or Nack error about object id to a status.
+func leaseError(id string, err error) error {
+	if errors.Is(err, repository.ErrLeaseLost) {
+		return status.Errorf(codes.FailedPrecondition, "lease on %s expired or was lost, the object is delivered again", id)
+	}
+	return objectError(id, err)
+}
+
+// queueError converts a failure to claim objects to a status.
+func queueError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return status.FromContextError(ctx.Err()).Err()
+	}
+	return status.Error(codes.Internal, "failed to read the queue")
+}
@@ -0,0 +1,223 @@
+package server_test
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/NikoMalik/potoc/internal/servertest"
+	"github.com/NikoMalik/potoc/pkg/client"
+	"github.com/NikoMalik/potoc/pkg/proto"
+	"github.com/NikoMalik/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type deliveryStream = grpc.ServerStreamingClient[proto.Delivery]
+
+// testQueue names a queue no other test uses.
+func testQueue() string {
+	return "test-" + uuid.New().String()
+}
+
+func enqueue(t *testing.T, s *servertest.Server, queue string, data ...string) []string {
+	t.Helper()
+	ids := make([]string, len(data))
+	for i, d := range data {
+		id, err := s.Client.Put(context.Background(), strings.NewReader(d), client.WithQueue(queue))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[i] = id
+	}
+	return ids
+}
+
+func consume(t *testing.T, s *servertest.Server, req *proto.ConsumeRequest) (deliveryStream, context.CancelFunc) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(cancel)
+	stream, err := s.API.Consume(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stream, cancel
+}
+
+func recvDelivery(t *testing.T, stream deliveryStream) *proto.Delivery {
+	t.Helper()
+	d, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestConsume(t *testing.T) {
+	s := servertest.Start(t)
+	ctx := context.Background()
+
+	queue := testQueue()
+	enqueue(t, s, testQueue(), "other queue")
+	put(t, s, "no queue", nil)
+	ids := enqueue(t, s, queue, "a", "b", "c")
+
+	stream, _ := consume(t, s, &proto.ConsumeRequest{Queue: queue, Prefetch: 2})
+	first, second := recvDelivery(t, stream), recvDelivery(t, stream)
+	if first.GetObject().GetSocketId() != ids[0] || second.GetObject().GetSocketId() != ids[1] {
+		t.Fatalf("got %s, %s, want %s, %s", first.GetObject().GetSocketId(), second.GetObject().GetSocketId(), ids[0], ids[1])
+	}
+	if string(first.GetData()) != "a" || first.GetAttempt() != 1 || first.GetObject().GetQueue() != queue {
+		t.Fatalf("first delivery: %v", first)
+	}
+
+	// The third waits for room under the prefetch limit.
+	if _, err := s.API.Ack(ctx, &proto.AckRequest{SocketId: ids[0], LeaseId: first.GetLeaseId()}); err != nil {
+		t.Fatal(err)
+	}
+	if d := recvDelivery(t, stream); d.GetObject().GetSocketId() != ids[2] {
+		t.Fatalf("after ack: got %s, want %s", d.GetObject().GetSocketId(), ids[2])
+	}
+	if _, err := s.Repos.SocketRepo.Get(ctx, ids[0]); err == nil {
+		t.Error("acked object still stored")
+	}
+
+	// A nacked object comes back as another attempt.
+	if _, err := s.API.Nack(ctx, &proto.NackRequest{SocketId: ids[1], LeaseId: second.GetLeaseId()}); err != nil {
+		t.Fatal(err)
+	}
+	again := recvDelivery(t, stream)
+	if again.GetObject().GetSocketId() != ids[1] || again.GetAttempt() != 2 || again.GetLeaseId() == second.GetLeaseId() {
+		t.Fatalf("after nack: %v", again)
+	}
+
+	tests := []struct {
+		name     string
+		id       string
+		lease    string
+		wantCode codes.Code
+	}{
+		{name: "old lease", id: ids[1], lease: second.GetLeaseId(), wantCode: codes.FailedPrecondition},
+		{name: "already acked", id: ids[0], lease: first.GetLeaseId(), wantCode: codes.NotFound},
+		{name: "bad lease id", id: ids[1], lease: "nope", wantCode: codes.InvalidArgument},
+		{name: "current lease", id: ids[1], lease: again.GetLeaseId()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := s.API.Ack(ctx, &proto.AckRequest{SocketId: tt.id, LeaseId: tt.lease})
+			if code := status.Code(err); code != tt.wantCode {
+				t.Fatalf("got code %v (%v), want %v", code, err, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestConsumeErrors(t *testing.T) {
+	s := servertest.Start(t)
+
+	tests := []struct {
+		name     string
+		req      *proto.ConsumeRequest
+		wantCode codes.Code
+	}{
+		{name: "empty queue", req: &proto.ConsumeRequest{}, wantCode: codes.InvalidArgument},
+		{name: "bad queue name", req: &proto.ConsumeRequest{Queue: "a b"}, wantCode: codes.InvalidArgument},
+		{name: "long queue name", req: &proto.ConsumeRequest{Queue: strings.Repeat("q", 64)}, wantCode: codes.InvalidArgument},
+		{name: "negative prefetch", req: &proto.ConsumeRequest{Queue: "q", Prefetch: -1}, wantCode: codes.InvalidArgument},
+		{name: "negative visibility", req: &proto.ConsumeRequest{Queue: "q", VisibilityTimeoutMs: -1}, wantCode: codes.InvalidArgument},
+		{name: "visibility over the maximum", req: &proto.ConsumeRequest{Queue: "q", VisibilityTimeoutMs: (24 * time.Hour).Milliseconds()}, wantCode: codes.InvalidArgument},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stream, err := s.API.Consume(context.Background(), tt.req)
+			if err == nil {
+				_, err = stream.Recv()
+			}
+			if code := status.Code(err); code != tt.wantCode {
+				t.Fatalf("got code %v (%v), want %v", code, err, tt.wantCode)
+			}
+		})
+	}
+
+	_, err := s.API.Nack(context.Background(), &proto.NackRequest{SocketId: uuid.New().String(), LeaseId: uuid.New().String(), DelayMs: -1})
+	if code := status.Code(err); code != codes.InvalidArgument {
+		t.Fatalf("negative nack delay: got code %v (%v), want InvalidArgument", code, err)
+	}
+}
+
+func TestConsumeCompeting(t *testing.T) {
+	s := servertest.Start(t)
+	ctx := context.Background()
+
+	queue := testQueue()
+	const n = 40
+	ids := enqueue(t, s, queue, strings.Split(strings.Repeat("x", n), "")...)
+
+	var (
+		mu   sync.Mutex
+		seen = map[string]int{}
+		wg   sync.WaitGroup
+	)
+	cctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			stream, err := s.API.Consume(cctx, &proto.ConsumeRequest{Queue: queue, Prefetch: 3})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			for {
+				d, err := stream.Recv()
+				if err != nil {
+					return
+				}
+				mu.Lock()
+				seen[d.GetObject().GetSocketId()]++
+				done := len(seen) == n
+				mu.Unlock()
+				if _, err := s.API.Ack(ctx, &proto.AckRequest{SocketId: d.GetObject().GetSocketId(), LeaseId: d.GetLeaseId()}); err != nil {
+					t.Error(err)
+				}
+				if done {
+					cancel()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, id := range ids {
+		if seen[id] != 1 {
+			t.Errorf("object %s delivered %d times", id, seen[id])
+		}
+	}
+}
+
+func TestConsumeRedelivery(t *testing.T) {
+	s := servertest.Start(t)
+	queue := testQueue()
+	id := enqueue(t, s, queue, "x")[0]
+
+	// A lease that expires makes the object visible again.
+	stream, cancel := consume(t, s, &proto.ConsumeRequest{Queue: queue, VisibilityTimeoutMs: 50})
+	recvDelivery(t, stream)
+	if d := recvDelivery(t, stream); d.GetObject().GetSocketId() != id || d.GetAttempt() != 2 {
+		t.Fatalf("after the visibility timeout: %v", d)
+	}
+	cancel()
+
+	// A consumer that goes away gives its objects back at once.
+	stream, cancel = consume(t, s, &proto.ConsumeRequest{Queue: queue})
+	recvDelivery(t, stream)
+	other, _ := consume(t, s, &proto.ConsumeRequest{Queue: queue})
+	cancel()
+	if d := recvDelivery(t, other); d.GetObject().GetSocketId() != id {
+		t.Fatalf("after the consumer left: %v", d)
+	}
+}
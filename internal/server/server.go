@@ -1,46 +1,99 @@
 package server
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net"
+	"net/http"
+	"time"
 
 	"github.com/NikoMalik/potoc/internal/config"
 	"github.com/NikoMalik/potoc/internal/logger"
 	"github.com/NikoMalik/potoc/internal/repository"
+	"go.uber.org/zap"
 )
 
 type Server struct {
 	config *config.Config
 	grpc   *GRPC
+	admin  *http.Server
 }
 
 func NewServer(config *config.Config, repo *repository.Repositories) *Server {
-	return &Server{
+	s := &Server{
 		config: config,
 		grpc:   NewGRPC(config, repo),
 	}
+	if config.Admin != nil && config.Admin.Port != "" {
+		s.admin = &http.Server{
+			Addr:    net.JoinHostPort(config.Admin.Host, config.Admin.Port),
+			Handler: s.grpc.health.Handler(),
+		}
+	}
+	return s
 }
 
+// Run serves until the server is stopped. A failure to listen or serve is
+// returned, the caller stops the app.
 func (s *Server) Run() error {
 	ln, err := net.Listen("tcp", net.JoinHostPort(s.config.Server.Host, s.config.Server.Port))
 	if err != nil {
-		logger.Fatal(fmt.Sprintf("failed to listen: %v", err))
-		return err
+		return fmt.Errorf("failed to listen: %w", err)
+	}
+
+	go s.grpc.health.Run()
+
+	if s.admin != nil {
+		go func() {
+			logger.Info("Starting admin server", zap.String("addr", s.admin.Addr))
+			if err := s.admin.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				logger.Error("admin server failed", zap.Error(err))
+			}
+		}()
 	}
-	r := s.grpc.Run(ln)
-	if r != nil {
-		logger.Fatal(fmt.Sprintf("failed to run: %v", err))
-		return err
+
+	if err := s.grpc.Run(ln); err != nil {
+		return fmt.Errorf("failed to run: %w", err)
 	}
-	return r
+	return nil
 }
 
 func (s *Server) Stop() error {
 	s.grpc.Stop()
-	return nil
+	return s.stopAdmin()
+}
+
+// Drain gracefully stops the gRPC server, see GRPC.Drain, and then the
+// admin listener. Aborted streams are logged one by one.
+func (s *Server) Drain(ctx context.Context) (*DrainReport, error) {
+	report := s.grpc.Drain(ctx)
+	for _, st := range report.Aborted {
+		logger.Warn("Stream aborted on shutdown",
+			zap.Uint64("stream_id", st.ID),
+			zap.String("method", st.Method),
+			zap.String("peer", st.Peer),
+			zap.Duration("age", time.Since(st.Started)),
+		)
+	}
+	logger.Info("Streams drained", zap.Int("drained", report.Drained), zap.Int("aborted", len(report.Aborted)))
+
+	return report, s.stopAdmin()
+}
+
+// SetLimits applies new per stream limits, see config.Watch.
+func (s *Server) SetLimits(limits *config.Limits) {
+	s.grpc.SetLimits(limits)
 }
 
 func (s *Server) PanicStop() {
 	s.grpc.PanicStop()
+	s.stopAdmin()
+}
 
+func (s *Server) stopAdmin() error {
+	if s.admin == nil {
+		return nil
+	}
+	return s.admin.Close()
 }
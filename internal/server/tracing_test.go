@@ -0,0 +1,108 @@
+package server_test
+
+import (
+	"context"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/NikoMalik/potoc/internal/servertest"
+	"github.com/NikoMalik/potoc/pkg/proto"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+)
+
+// recordSpans installs a tracer provider recording every span until the
+// test ends.
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	rec := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec))
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(tp)
+	t.Cleanup(func() {
+		otel.SetTracerProvider(prev)
+		tp.Shutdown(context.Background())
+	})
+	return rec
+}
+
+// spansNamed returns the ended spans with the given name.
+func spansNamed(rec *tracetest.SpanRecorder, name string) []sdktrace.ReadOnlySpan {
+	var spans []sdktrace.ReadOnlySpan
+	for _, s := range rec.Ended() {
+		if s.Name() == name {
+			spans = append(spans, s)
+		}
+	}
+	return spans
+}
+
+func TestTracing(t *testing.T) {
+	rec := recordSpans(t)
+	s := servertest.Start(t, servertest.WithServerOptions(grpc.StatsHandler(otelgrpc.NewServerHandler())))
+
+	stream, err := s.API.GetData(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := roundTrips(stream, []*proto.DataRequest{{EncodedData: encode("a")}, {EncodedData: encode("b")}}); err != nil {
+		t.Fatal(err)
+	}
+	stream.CloseSend()
+	if _, err := stream.Recv(); err != io.EOF {
+		t.Fatalf("stream did not end cleanly: %v", err)
+	}
+
+	// The stream span ends once the handler has returned, just after the
+	// client sees the end of the stream.
+	var streams []sdktrace.ReadOnlySpan
+	for deadline := time.Now().Add(5 * time.Second); len(streams) == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("no stream span")
+		}
+		time.Sleep(10 * time.Millisecond)
+		streams = spansNamed(rec, "DataTranfer/GetData")
+	}
+	streamSpan := streams[0].SpanContext()
+	if kind := streams[0].SpanKind(); kind != trace.SpanKindServer {
+		t.Errorf("stream span kind %v, want server", kind)
+	}
+
+	messages := spansNamed(rec, "DataTranfer.GetData/message")
+	if len(messages) != 2 {
+		t.Fatalf("got %d message spans, want 2", len(messages))
+	}
+	children := map[string]int{}
+	for _, msg := range messages {
+		if msg.Parent().SpanID() != streamSpan.SpanID() || msg.SpanContext().TraceID() != streamSpan.TraceID() {
+			t.Errorf("message span %v is not a child of the stream span", msg.SpanContext().SpanID())
+		}
+		for _, s := range rec.Ended() {
+			if s.Parent().SpanID() == msg.SpanContext().SpanID() {
+				children[s.Name()]++
+			}
+		}
+	}
+
+	want := map[string]int{"base64.Decode": 2, "stream.Send": 2}
+	// Only the Postgres repository traces its queries.
+	if os.Getenv(servertest.DatabaseURLEnv) != "" {
+		want["socketRepo.Create"] = 2
+		for _, db := range spansNamed(rec, "socketRepo.Create") {
+			if db.SpanKind() != trace.SpanKindClient {
+				t.Errorf("db span kind %v, want client", db.SpanKind())
+			}
+		}
+	}
+	for name, n := range want {
+		if children[name] != n {
+			t.Errorf("got %d %q spans under the message spans, want %d", children[name], name, n)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/NikoMalik/potoc/internal/config"
+	"github.com/NikoMalik/potoc/internal/models"
+	"github.com/NikoMalik/potoc/pkg/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	msgSaved         = "Data received and saved"
+	msgSavedOnCommit = "Data received, saved on commit"
+	msgCommitted     = "Transaction committed"
+)
+
+var (
+	_errTxNotCommitted = status.Error(codes.Aborted, "stream closed without commit, transaction rolled back")
+	_errTxCommitted    = status.Error(codes.FailedPrecondition, "transaction already committed, close the stream")
+	_errNoTx           = status.Error(codes.FailedPrecondition, "no transaction to commit, set transaction on the first message")
+
+	// _errTxExpired is returned by nextMessage when the transaction timed
+	// out; the client is told uploadTx.expiry instead.
+	_errTxExpired = errors.New("transaction expired")
+)
+
+// uploadTx is the transaction of a transactional GetData stream. Its
+// objects are held in memory and stored in one short database
+// transaction on commit, so that an open stream holds no connection and
+// no lock. A nil uploadTx is a stream storing every object on its own.
+type uploadTx struct {
+	objects   []*models.SocketData
+	size      int
+	queued    bool
+	committed bool
+	// stored counts the objects once committed.
+	stored int
+
+	started time.Time
+	timer   *time.Timer
+	// expiry tells the client which time limit the timer is for.
+	expiry error
+}
+
+// beginUpload opens the transaction asked for by a message, which must be
+// the first of its stream.
+func (d *dataTransferServer) beginUpload(ctx context.Context, first bool) (*uploadTx, error) {
+	if !first {
+		return nil, status.Error(codes.FailedPrecondition, "a transaction must begin on the first message of the stream")
+	}
+	if d.txRepo == nil {
+		return nil, status.Error(codes.Unimplemented, "transactions are not supported")
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return &uploadTx{started: time.Now()}, nil
+}
+
+func (t *uploadTx) create(data *models.SocketData, limits *config.Limits) error {
+	if t.committed {
+		return _errTxCommitted
+	}
+	if limits != nil && limits.MaxTransactionObjects > 0 && len(t.objects) >= limits.MaxTransactionObjects {
+		return status.Errorf(codes.ResourceExhausted, "transaction exceeds the limit of %d objects", limits.MaxTransactionObjects)
+	}
+	if limits != nil && limits.MaxTransactionBytes > 0 && t.size+len(data.Data) > limits.MaxTransactionBytes {
+		return status.Errorf(codes.ResourceExhausted, "transaction exceeds the limit of %d bytes", limits.MaxTransactionBytes)
+	}
+	t.objects = append(t.objects, data)
+	t.size += len(data.Data)
+	t.queued = t.queued || data.Queue != ""
+	return nil
+}
+
+// commit stores the objects of the transaction and returns the response
+// telling the client how many there were.
+func (d *dataTransferServer) commit(ctx context.Context, t *uploadTx) (*proto.DataResponse, error) {
+	if t == nil {
+		return nil, _errNoTx
+	}
+	if t.committed {
+		return nil, _errTxCommitted
+	}
+	if len(t.objects) > 0 {
+		if err := d.txRepo.CreateAll(ctx, t.objects); err != nil {
+			return nil, err
+		}
+	}
+	t.committed, t.stored, t.objects = true, len(t.objects), nil
+	t.stopTimer()
+	return &proto.DataResponse{
+		Status: "ok",
+		Msg:    msgCommitted,
+		Data:   []byte(strconv.Itoa(t.stored)),
+	}, nil
+}
+
+// open reports whether the stream has a transaction left to commit.
+func (t *uploadTx) open() bool {
+	return t != nil && !t.committed
+}
+
+// rollback discards the objects of a transaction that was not committed.
+func (t *uploadTx) rollback() {
+	if !t.open() {
+		return
+	}
+	t.objects = nil
+	t.stopTimer()
+}
+
+// arm restarts the timer that ends the transaction when it stays idle for
+// TransactionIdleTimeout or open for TransactionTimeout.
+func (t *uploadTx) arm(limits *config.Limits) {
+	t.stopTimer()
+	if !t.open() || limits == nil {
+		return
+	}
+	var wait time.Duration
+	t.expiry = nil
+	if idle := limits.TransactionIdleTimeout; idle > 0 {
+		wait = idle
+		t.expiry = status.Errorf(codes.Aborted, "no message for %s, transaction rolled back", idle)
+	}
+	if total := limits.TransactionTimeout; total > 0 {
+		if left := total - time.Since(t.started); t.expiry == nil || left < wait {
+			wait = left
+			t.expiry = status.Errorf(codes.Aborted, "transaction not committed within %s, rolled back", total)
+		}
+	}
+	if t.expiry != nil {
+		t.timer = time.NewTimer(max(wait, 0))
+	}
+}
+
+func (t *uploadTx) stopTimer() {
+	if t.timer != nil {
+		t.timer.Stop()
+		t.timer = nil
+	}
+}
+
+// expired fires when the transaction times out. It is nil, and never
+// fires, without a transaction or time limits.
+func (t *uploadTx) expired() <-chan time.Time {
+	if t == nil || t.timer == nil {
+		return nil
+	}
+	return t.timer.C
+}
+
+// savedMsg is the response message to a stored object.
+func (t *uploadTx) savedMsg() string {
+	if t == nil {
+		return msgSaved
+	}
+	return msgSavedOnCommit
+}
+
+// checkCommit rejects commit messages that carry an object.
+func checkCommit(req *proto.DataRequest) error {
+	if !req.GetCommit() {
+		return nil
+	}
+	if len(req.GetEncodedData()) > 0 || len(req.GetLabels()) > 0 || req.GetQueue() != "" || req.GetTransaction() {
+		return status.Error(codes.InvalidArgument, "a commit message carries no object")
+	}
+	return nil
+}
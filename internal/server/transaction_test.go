@@ -0,0 +1,251 @@
+package server_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/NikoMalik/potoc/internal/config"
+	"github.com/NikoMalik/potoc/internal/repository"
+	"github.com/NikoMalik/potoc/internal/servertest"
+	"github.com/NikoMalik/potoc/pkg/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestGetDataTransaction(t *testing.T) {
+	begin := func(s string) *proto.DataRequest {
+		return &proto.DataRequest{EncodedData: encode(s), Transaction: true}
+	}
+	obj := func(s string) *proto.DataRequest { return &proto.DataRequest{EncodedData: encode(s)} }
+	commit := &proto.DataRequest{Commit: true}
+
+	tests := []struct {
+		name   string
+		limits *config.Limits
+		reqs   []*proto.DataRequest
+		// wantCode ends the stream, after a half-close when every request
+		// got its response.
+		wantCode codes.Code
+		// stored tells whether the acknowledged objects exist afterwards.
+		stored bool
+	}{
+		{
+			name:   "commit",
+			reqs:   []*proto.DataRequest{begin("a"), obj("b"), {EncodedData: encode("c"), Queue: "tx"}, commit},
+			stored: true,
+		},
+		{
+			name:     "closed without commit",
+			reqs:     []*proto.DataRequest{begin("a"), obj("b")},
+			wantCode: codes.Aborted,
+		},
+		{
+			name:     "bad message after objects",
+			reqs:     []*proto.DataRequest{begin("a"), obj("b"), {EncodedData: []byte("%%%")}},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name:     "object after commit",
+			reqs:     []*proto.DataRequest{begin("a"), commit, obj("b")},
+			wantCode: codes.FailedPrecondition,
+			stored:   true,
+		},
+		{
+			name:     "commit twice",
+			reqs:     []*proto.DataRequest{begin("a"), commit, commit},
+			wantCode: codes.FailedPrecondition,
+			stored:   true,
+		},
+		{
+			name:     "commit without transaction",
+			reqs:     []*proto.DataRequest{obj("a"), commit},
+			wantCode: codes.FailedPrecondition,
+			stored:   true,
+		},
+		{
+			name:     "transaction on a later message",
+			reqs:     []*proto.DataRequest{obj("a"), begin("b")},
+			wantCode: codes.FailedPrecondition,
+			stored:   true,
+		},
+		{
+			name:     "commit carrying an object",
+			reqs:     []*proto.DataRequest{begin("a"), {Commit: true, EncodedData: encode("b")}},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name:     "objects over the limit",
+			limits:   &config.Limits{MaxTransactionObjects: 2},
+			reqs:     []*proto.DataRequest{begin("a"), obj("b"), obj("c"), commit},
+			wantCode: codes.ResourceExhausted,
+		},
+		{
+			name:     "bytes over the limit",
+			limits:   &config.Limits{MaxTransactionBytes: 2},
+			reqs:     []*proto.DataRequest{begin("a"), obj("b"), obj("c"), commit},
+			wantCode: codes.ResourceExhausted,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var opts []servertest.Option
+			if tt.limits != nil {
+				opts = append(opts, servertest.WithLimits(tt.limits))
+			}
+			s := servertest.Start(t, opts...)
+			ctx := context.Background()
+
+			stream, err := s.API.GetData(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resps, err := roundTrips(stream, tt.reqs)
+			if err == nil {
+				stream.CloseSend()
+				if _, err = stream.Recv(); err == io.EOF {
+					err = nil
+				}
+			}
+			if code := status.Code(err); code != tt.wantCode {
+				t.Fatalf("got code %v (%v), want %v", code, err, tt.wantCode)
+			}
+
+			var objects int
+			for i, resp := range resps {
+				if tt.reqs[i].GetCommit() {
+					if got, want := string(resp.GetData()), strconv.Itoa(objects); got != want {
+						t.Errorf("commit response %q, want %q objects", got, want)
+					}
+					continue
+				}
+				objects++
+				_, err := s.Repos.SocketRepo.Stat(ctx, string(resp.GetData()))
+				if tt.stored && err != nil {
+					t.Errorf("object %d not stored: %v", i, err)
+				}
+				if !tt.stored && !errors.Is(err, repository.ErrNotFound) {
+					t.Errorf("object %d of a rolled back transaction: got %v, want ErrNotFound", i, err)
+				}
+			}
+		})
+	}
+}
+
+func TestGetDataTransactionVisibility(t *testing.T) {
+	s := servertest.Start(t)
+	ctx := context.Background()
+
+	stream, err := s.API.GetData(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resps, err := roundTrips(stream, []*proto.DataRequest{{EncodedData: encode("a"), Transaction: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := string(resps[0].GetData())
+	if _, err := s.Repos.SocketRepo.Stat(ctx, id); !errors.Is(err, repository.ErrNotFound) {
+		t.Fatalf("uncommitted object: got %v, want ErrNotFound", err)
+	}
+
+	if _, err := roundTrips(stream, []*proto.DataRequest{{Commit: true}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Repos.SocketRepo.Stat(ctx, id); err != nil {
+		t.Fatalf("committed object: %v", err)
+	}
+	stream.CloseSend()
+}
+
+func TestGetDataTransactionCancelled(t *testing.T) {
+	s := servertest.Start(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := s.API.GetData(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resps, err := roundTrips(stream, []*proto.DataRequest{{EncodedData: encode("a"), Transaction: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	if _, err := stream.Recv(); status.Code(err) != codes.Canceled {
+		t.Fatalf("got %v, want Canceled", err)
+	}
+
+	if _, err := s.Repos.SocketRepo.Stat(context.Background(), string(resps[0].GetData())); !errors.Is(err, repository.ErrNotFound) {
+		t.Fatalf("object of a cancelled transaction: got %v, want ErrNotFound", err)
+	}
+}
+
+func TestGetDataTransactionTimeout(t *testing.T) {
+	tests := []struct {
+		name    string
+		limits  *config.Limits
+		wantMsg string
+	}{
+		{"idle", &config.Limits{TransactionIdleTimeout: 20 * time.Millisecond}, "no message for 20ms"},
+		{"open too long", &config.Limits{TransactionTimeout: 20 * time.Millisecond, TransactionIdleTimeout: time.Hour}, "not committed within 20ms"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := servertest.Start(t, servertest.WithLimits(tt.limits))
+			stream, err := s.API.GetData(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			resps, err := roundTrips(stream, []*proto.DataRequest{{EncodedData: encode("a"), Transaction: true}})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = stream.Recv()
+			if status.Code(err) != codes.Aborted || !strings.Contains(status.Convert(err).Message(), tt.wantMsg) {
+				t.Fatalf("got %v, want Aborted with %q", err, tt.wantMsg)
+			}
+			if _, err := s.Repos.SocketRepo.Stat(context.Background(), string(resps[0].GetData())); !errors.Is(err, repository.ErrNotFound) {
+				t.Fatalf("object of an expired transaction: got %v, want ErrNotFound", err)
+			}
+		})
+	}
+}
+
+// An open transaction must not hold the lock the event trigger takes, or
+// every other write would wait for its commit.
+func TestGetDataTransactionDoesNotBlockWriters(t *testing.T) {
+	if os.Getenv(servertest.DatabaseURLEnv) == "" {
+		t.Skipf("%s is not set", servertest.DatabaseURLEnv)
+	}
+	s := servertest.Start(t)
+
+	stream, err := s.API.GetData(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := roundTrips(stream, []*proto.DataRequest{{EncodedData: encode("a"), Transaction: true}}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	id, err := s.Client.Put(ctx, strings.NewReader("b"))
+	if err != nil {
+		t.Fatalf("write during an open transaction: %v", err)
+	}
+	if _, err := s.Repos.SocketRepo.Stat(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := roundTrips(stream, []*proto.DataRequest{{Commit: true}}); err != nil {
+		t.Fatal(err)
+	}
+	stream.CloseSend()
+}
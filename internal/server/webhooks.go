@@ -0,0 +1,429 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/NikoMalik/potoc/internal/config"
+	"github.com/NikoMalik/potoc/internal/logger"
+	"github.com/NikoMalik/potoc/internal/models"
+	"github.com/NikoMalik/potoc/internal/repository"
+	"github.com/NikoMalik/potoc/internal/version"
+	"github.com/NikoMalik/potoc/pkg/proto"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Headers of every webhook post. The signature header holds the unix time
+// of the post and the hex HMAC-SHA256 of "<time>.<body>" keyed with the
+// endpoint secret, as "t=<time>,v1=<hmac>".
+const (
+	WebhookSignatureHeader = "Potoc-Signature"
+	WebhookIDHeader        = "Potoc-Webhook-Id"
+	WebhookAttemptHeader   = "Potoc-Webhook-Attempt"
+)
+
+const (
+	webhookBatch        = 500
+	webhookQueryTimeout = 10 * time.Second
+	// webhookLeaseMargin is added to the longest endpoint timeout to hide a
+	// claimed delivery from other servers while it is posted.
+	webhookLeaseMargin = 30 * time.Second
+	// maxWebhookError bounds the response body kept as the failure reason.
+	maxWebhookError = 512
+	maxRetryIDs     = 1000
+)
+
+var _errWebhooksDisabled = status.Error(codes.Unimplemented, "webhooks are disabled on this server")
+
+// webhookDispatcher copies new events into the outbox for the endpoints
+// that select them and posts the due deliveries. Any number of servers may
+// run one: the outbox rows are claimed with SKIP LOCKED, so each delivery
+// is posted by one server at a time, at least once.
+type webhookDispatcher struct {
+	repo      repository.WebhookRepo
+	cfg       *config.Webhooks
+	endpoints map[string]*webhookEndpoint
+	names     []string
+	lease     time.Duration
+	client    *http.Client
+	// changed wakes the dispatcher on new events.
+	changed func() <-chan struct{}
+
+	wake chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+// webhookEndpoint is an endpoint config with the defaults applied and the
+// filter parsed.
+type webhookEndpoint struct {
+	config.WebhookEndpoint
+	kinds  map[string]bool
+	labels map[string]string
+}
+
+// newWebhookDispatcher returns nil when no endpoint is configured or repo
+// is nil.
+func newWebhookDispatcher(cfg *config.Webhooks, repo repository.WebhookRepo, changed func() <-chan struct{}) *webhookDispatcher {
+	if cfg == nil || len(cfg.Endpoints) == 0 || repo == nil {
+		return nil
+	}
+	w := &webhookDispatcher{
+		repo:      repo,
+		cfg:       cfg,
+		endpoints: make(map[string]*webhookEndpoint, len(cfg.Endpoints)),
+		client:    &http.Client{},
+		changed:   changed,
+		wake:      make(chan struct{}, 1),
+		done:      make(chan struct{}),
+	}
+	for _, e := range cfg.Endpoints {
+		ep := &webhookEndpoint{WebhookEndpoint: cfg.WithDefaults(e)}
+		for _, k := range e.Kinds {
+			if ep.kinds == nil {
+				ep.kinds = make(map[string]bool)
+			}
+			ep.kinds[k] = true
+		}
+		for _, l := range e.Labels {
+			if ep.labels == nil {
+				ep.labels = make(map[string]string)
+			}
+			k, v, _ := strings.Cut(l, "=")
+			ep.labels[k] = v
+		}
+		w.endpoints[e.Name] = ep
+		w.names = append(w.names, e.Name)
+		w.lease = max(w.lease, ep.Timeout+webhookLeaseMargin)
+	}
+	slices.Sort(w.names)
+	return w
+}
+
+// Run copies and posts deliveries until Stop.
+func (w *webhookDispatcher) Run() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-w.done
+		cancel()
+	}()
+
+	poll := time.NewTicker(w.cfg.PollInterval)
+	defer poll.Stop()
+
+	for {
+		// Taken before looking, so that an event while posting is not lost.
+		var changed <-chan struct{}
+		if w.changed != nil {
+			changed = w.changed()
+		}
+
+		w.enqueue(ctx)
+		w.deliver(ctx)
+
+		select {
+		case <-w.done:
+			return
+		case <-changed:
+		case <-w.wake:
+		case <-poll.C:
+		}
+	}
+}
+
+// notify asks Run to look for due deliveries. It never blocks.
+func (w *webhookDispatcher) notify() {
+	if w == nil {
+		return
+	}
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Stop ends Run. Posts in flight are cut off; their deliveries are posted
+// again once their lease runs out.
+func (w *webhookDispatcher) Stop() {
+	w.once.Do(func() { close(w.done) })
+}
+
+// enqueue copies every new event into the outbox.
+func (w *webhookDispatcher) enqueue(ctx context.Context) {
+	for {
+		qctx, cancel := context.WithTimeout(ctx, webhookQueryTimeout)
+		n, err := w.repo.Enqueue(qctx, webhookBatch, w.route)
+		cancel()
+		if err != nil {
+			if ctx.Err() == nil {
+				logger.Warn("failed to queue webhook deliveries", zap.Error(err))
+			}
+			return
+		}
+		if n < webhookBatch {
+			return
+		}
+	}
+}
+
+// route returns the endpoints that select ev.
+func (w *webhookDispatcher) route(ev *models.ObjectEvent) []string {
+	var names []string
+	for _, name := range w.names {
+		if w.endpoints[name].match(ev) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func (e *webhookEndpoint) match(ev *models.ObjectEvent) bool {
+	if e.kinds != nil && !e.kinds[ev.Kind] {
+		return false
+	}
+	for k, v := range e.labels {
+		if got, ok := ev.Object.Labels[k]; !ok || got != v {
+			return false
+		}
+	}
+	return true
+}
+
+// deliver posts the due deliveries, up to Workers at once.
+func (w *webhookDispatcher) deliver(ctx context.Context) {
+	for {
+		qctx, cancel := context.WithTimeout(ctx, webhookQueryTimeout)
+		deliveries, err := w.repo.Claim(qctx, w.names, w.cfg.Workers, w.lease)
+		cancel()
+		if err != nil {
+			if ctx.Err() == nil {
+				logger.Warn("failed to claim webhook deliveries", zap.Error(err))
+			}
+			return
+		}
+
+		var wg sync.WaitGroup
+		for i := range deliveries {
+			wg.Add(1)
+			go func(d *models.WebhookDelivery) {
+				defer wg.Done()
+				w.attempt(ctx, d)
+			}(&deliveries[i])
+		}
+		wg.Wait()
+
+		if len(deliveries) < w.cfg.Workers || ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// attempt posts d once and records the outcome.
+func (w *webhookDispatcher) attempt(ctx context.Context, d *models.WebhookDelivery) {
+	e := w.endpoints[d.Endpoint]
+	log := logger.FromContext(ctx).With(zap.String("endpoint", d.Endpoint), zap.Int64("delivery", d.ID), zap.Int("attempt", d.Attempts))
+
+	sendErr := w.post(ctx, e, d)
+	if sendErr != nil && ctx.Err() != nil {
+		// Stopping; the lease brings the delivery back.
+		return
+	}
+
+	qctx, cancel := context.WithTimeout(context.Background(), webhookQueryTimeout)
+	defer cancel()
+	var err error
+	switch {
+	case sendErr == nil:
+		if err = w.repo.Delivered(qctx, d.ID, d.Attempts); err == nil {
+			log.Debug("Webhook delivered")
+		}
+	case d.Attempts >= e.MaxAttempts:
+		err = w.repo.Dead(qctx, d.ID, d.Attempts, sendErr.Error())
+		log.Warn("webhook delivery ran out of attempts", zap.Error(sendErr))
+	default:
+		next := webhookBackoff(e, d.Attempts)
+		err = w.repo.Retry(qctx, d.ID, d.Attempts, time.Now().Add(next), sendErr.Error())
+		log.Info("Webhook delivery failed, retrying", zap.Error(sendErr), zap.Duration("backoff", next))
+	}
+	if err != nil {
+		log.Warn("failed to record webhook delivery", zap.Error(err))
+	}
+}
+
+// webhookPayload is the JSON body of a post. ID is the same on every
+// attempt, for receivers to skip repeats.
+type webhookPayload struct {
+	ID     int64         `json:"id"`
+	Seq    int64         `json:"seq"`
+	Kind   string        `json:"kind"`
+	Object webhookObject `json:"object"`
+	Time   time.Time     `json:"time"`
+}
+
+type webhookObject struct {
+	ID        string            `json:"id"`
+	Size      int64             `json:"size"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+}
+
+func (w *webhookDispatcher) post(ctx context.Context, e *webhookEndpoint, d *models.WebhookDelivery) error {
+	ev := &d.Event
+	body, err := json.Marshal(webhookPayload{
+		ID:   d.ID,
+		Seq:  ev.Seq,
+		Kind: ev.Kind,
+		Object: webhookObject{
+			ID:        ev.Object.ID,
+			Size:      ev.Object.Size,
+			Labels:    ev.Object.Labels,
+			CreatedAt: ev.Object.CreatedAt.UTC(),
+		},
+		Time: ev.Time.UTC(),
+	})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, e.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "potoc-webhook/"+version.Version)
+	req.Header.Set(WebhookIDHeader, strconv.FormatInt(d.ID, 10))
+	req.Header.Set(WebhookAttemptHeader, strconv.Itoa(d.Attempts))
+	req.Header.Set(WebhookSignatureHeader, signWebhook(e.Secret, time.Now(), body))
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		io.Copy(io.Discard, io.LimitReader(resp.Body, maxWebhookError))
+		return nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, maxWebhookError))
+	if msg = bytes.TrimSpace(msg); len(msg) > 0 {
+		return fmt.Errorf("%s: %s", resp.Status, msg)
+	}
+	return fmt.Errorf("%s", resp.Status)
+}
+
+// signWebhook returns the signature header of body posted at t.
+func signWebhook(secret string, t time.Time, body []byte) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "t=" + ts + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookBackoff is the wait after the given failed attempt, counting
+// from 1, doubling up to the maximum with up to 20% jitter either way.
+func webhookBackoff(e *webhookEndpoint, attempt int) time.Duration {
+	d := e.MinBackoff
+	for range attempt - 1 {
+		if d >= e.MaxBackoff {
+			break
+		}
+		d *= 2
+	}
+	d = min(d, e.MaxBackoff)
+	return d + time.Duration(float64(d)*0.2*(2*rand.Float64()-1))
+}
+
+func (d *dataTransferServer) ListDeadLetters(ctx context.Context, req *proto.ListDeadLettersRequest) (*proto.ListDeadLettersResponse, error) {
+	if d.webhookRepo == nil {
+		return nil, _errWebhooksDisabled
+	}
+	size := int(req.GetPageSize())
+	switch {
+	case size < 0:
+		return nil, status.Errorf(codes.InvalidArgument, "negative page size %d", size)
+	case size == 0:
+		size = defaultPageSize
+	case size > maxPageSize:
+		size = maxPageSize
+	}
+
+	// The page token is the id of the last dead letter on the previous page.
+	var after int64
+	if token := req.GetPageToken(); token != "" {
+		var err error
+		if after, err = strconv.ParseInt(token, 10, 64); err != nil || after < 0 {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid page token %q", token)
+		}
+	}
+
+	letters, err := d.webhookRepo.DeadLetters(ctx, repository.DeadLetterFilter{
+		Endpoint: req.GetEndpoint(),
+		After:    after,
+		Limit:    size,
+	})
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to list dead letters")
+	}
+
+	resp := &proto.ListDeadLettersResponse{DeadLetters: make([]*proto.DeadLetter, len(letters))}
+	for i := range letters {
+		resp.DeadLetters[i] = deadLetter(&letters[i])
+	}
+	if len(letters) == size {
+		resp.NextPageToken = strconv.FormatInt(letters[len(letters)-1].ID, 10)
+	}
+
+	return resp, nil
+}
+
+func (d *dataTransferServer) RetryDeadLetters(ctx context.Context, req *proto.RetryDeadLettersRequest) (*proto.RetryDeadLettersResponse, error) {
+	if d.webhookRepo == nil {
+		return nil, _errWebhooksDisabled
+	}
+	ids := req.GetIds()
+	if len(ids) == 0 || len(ids) > maxRetryIDs {
+		return nil, status.Errorf(codes.InvalidArgument, "%d ids, must be 1 to %d", len(ids), maxRetryIDs)
+	}
+
+	n, err := d.webhookRepo.Redrive(ctx, ids)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to retry dead letters")
+	}
+	if n > 0 {
+		d.webhooks.notify()
+	}
+
+	return &proto.RetryDeadLettersResponse{Retried: n}, nil
+}
+
+func deadLetter(l *models.WebhookDelivery) *proto.DeadLetter {
+	return &proto.DeadLetter{
+		Id:        l.ID,
+		Endpoint:  l.Endpoint,
+		Event:     objectEvent(&l.Event),
+		Attempts:  int32(l.Attempts),
+		LastError: l.LastError,
+		DeadAt:    l.DeadAt.UnixNano(),
+	}
+}
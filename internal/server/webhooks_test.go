@@ -0,0 +1,282 @@
+package server_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/NikoMalik/potoc/internal/config"
+	"github.com/NikoMalik/potoc/internal/models"
+	"github.com/NikoMalik/potoc/internal/server"
+	"github.com/NikoMalik/potoc/internal/servertest"
+	"github.com/NikoMalik/potoc/pkg/client"
+	"github.com/NikoMalik/potoc/pkg/proto"
+	"github.com/NikoMalik/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const testSecret = "test-secret"
+
+// post is a webhook post received by a hookServer.
+type post struct {
+	event   *client.WebhookEvent
+	attempt int
+}
+
+// hookServer receives webhook posts and answers with the status codes in
+// replies, in turn, and 200 once they run out.
+func hookServer(t *testing.T, replies ...int) (*httptest.Server, <-chan post) {
+	t.Helper()
+	posts := make(chan post, 100)
+	var n atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ev, err := client.VerifyWebhook(r, testSecret, time.Minute)
+		if err != nil {
+			t.Errorf("verify webhook: %v", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if id := r.Header.Get(server.WebhookIDHeader); id != strconv.FormatInt(ev.ID, 10) {
+			t.Errorf("%s header %q, body id %d", server.WebhookIDHeader, id, ev.ID)
+		}
+		attempt, _ := strconv.Atoi(r.Header.Get(server.WebhookAttemptHeader))
+		posts <- post{event: ev, attempt: attempt}
+
+		if i := int(n.Add(1)) - 1; i < len(replies) {
+			http.Error(w, "try later", replies[i])
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, posts
+}
+
+// startWebhooks starts a server posting to a single endpoint selecting the
+// objects labelled with labels.
+func startWebhooks(t *testing.T, url string, labels map[string]string, kinds ...string) (*servertest.Server, string) {
+	t.Helper()
+	name := "test-" + uuid.New().String()
+	var pairs []string
+	for k, v := range labels {
+		pairs = append(pairs, k+"="+v)
+	}
+	s := servertest.Start(t, servertest.WithWebhooks(&config.Webhooks{
+		Endpoints:    []config.WebhookEndpoint{{Name: name, URL: url, Secret: testSecret, Kinds: kinds, Labels: pairs}},
+		Workers:      4,
+		PollInterval: 20 * time.Millisecond,
+		Timeout:      2 * time.Second,
+		MaxAttempts:  3,
+		MinBackoff:   10 * time.Millisecond,
+		MaxBackoff:   50 * time.Millisecond,
+	}))
+	return s, name
+}
+
+func recvPost(t *testing.T, posts <-chan post) post {
+	t.Helper()
+	select {
+	case p := <-posts:
+		return p
+	case <-time.After(5 * time.Second):
+		t.Fatal("no webhook post")
+		return post{}
+	}
+}
+
+func TestWebhooks(t *testing.T) {
+	srv, posts := hookServer(t)
+	labels := runLabel()
+	s, _ := startWebhooks(t, srv.URL, labels, "created", "deleted")
+
+	put(t, s, "not selected", nil)
+	id := put(t, s, "hello", labels)
+	if err := s.Client.Delete(context.Background(), id); err != nil {
+		t.Fatal(err)
+	}
+
+	// Posts may arrive in any order; seq tells them apart.
+	got := map[client.EventKind]post{}
+	for range 2 {
+		p := recvPost(t, posts)
+		got[p.event.Kind] = p
+	}
+	created, deleted := got[client.EventCreated], got[client.EventDeleted]
+	if created.event == nil || deleted.event == nil {
+		t.Fatalf("got posts %v, want created and deleted", got)
+	}
+	if obj := created.event.Object; obj.ID != id || obj.Size != 5 || obj.Labels["test-run"] != labels["test-run"] {
+		t.Errorf("created object %+v", obj)
+	}
+	if created.attempt != 1 || created.event.Seq >= deleted.event.Seq || created.event.ID == deleted.event.ID {
+		t.Errorf("created %+v (attempt %d), deleted %+v", created.event, created.attempt, deleted.event)
+	}
+
+	select {
+	case p := <-posts:
+		t.Fatalf("unexpected post %+v", p.event)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestWebhookRetries(t *testing.T) {
+	srv, posts := hookServer(t, http.StatusInternalServerError, http.StatusTooManyRequests)
+	labels := runLabel()
+	s, _ := startWebhooks(t, srv.URL, labels)
+
+	put(t, s, "x", labels)
+
+	first := recvPost(t, posts)
+	for want := 2; want <= 3; want++ {
+		p := recvPost(t, posts)
+		if p.attempt != want || p.event.ID != first.event.ID {
+			t.Fatalf("post %d: attempt %d of delivery %d, want delivery %d", want, p.attempt, p.event.ID, first.event.ID)
+		}
+	}
+}
+
+func TestWebhookStaleOutcome(t *testing.T) {
+	s := servertest.Start(t)
+	ctx := context.Background()
+	run := runLabel()
+	endpoint := run["test-run"]
+	put(t, s, "x", run)
+
+	repo := s.Repos.WebhookRepo
+	route := func(ev *models.ObjectEvent) []string {
+		if ev.Object.Labels["test-run"] == endpoint {
+			return []string{endpoint}
+		}
+		return nil
+	}
+	for {
+		n, err := repo.Enqueue(ctx, 100, route)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n == 0 {
+			break
+		}
+	}
+	claim := func() []models.WebhookDelivery {
+		t.Helper()
+		claimed, err := repo.Claim(ctx, []string{endpoint}, 1, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return claimed
+	}
+
+	// The first attempt outlives its lease and reports after a second claim.
+	first := claim()
+	if len(first) != 1 {
+		t.Fatalf("claimed %d deliveries, want 1", len(first))
+	}
+	d := first[0]
+	if again := claim(); len(again) != 1 || again[0].Attempts != d.Attempts+1 {
+		t.Fatalf("second claim %+v", again)
+	}
+	if err := repo.Delivered(ctx, d.ID, d.Attempts); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Dead(ctx, d.ID, d.Attempts, "stale"); err != nil {
+		t.Fatal(err)
+	}
+
+	last := claim()
+	if len(last) != 1 || last[0].Attempts != d.Attempts+2 || last[0].LastError != "" {
+		t.Fatalf("stale outcome recorded: %+v", last)
+	}
+	if err := repo.Delivered(ctx, d.ID, last[0].Attempts); err != nil {
+		t.Fatal(err)
+	}
+	if left := claim(); len(left) != 0 {
+		t.Fatalf("delivered, still claimed: %+v", left)
+	}
+}
+
+func TestWebhookDeadLetters(t *testing.T) {
+	var failing atomic.Bool
+	failing.Store(true)
+	posts := make(chan *client.WebhookEvent, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing.Load() {
+			http.Error(w, "endpoint down", http.StatusServiceUnavailable)
+			return
+		}
+		ev, err := client.VerifyWebhook(r, testSecret, time.Minute)
+		if err != nil {
+			t.Error(err)
+		}
+		posts <- ev
+	}))
+	defer srv.Close()
+
+	labels := runLabel()
+	s, name := startWebhooks(t, srv.URL, labels)
+	ctx := context.Background()
+	id := put(t, s, "x", labels)
+
+	var letters []client.DeadLetter
+	deadline := time.Now().Add(5 * time.Second)
+	for len(letters) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("no dead letter")
+		}
+		time.Sleep(20 * time.Millisecond)
+		var err error
+		if letters, err = s.Client.DeadLetters(ctx, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	l := letters[0]
+	if len(letters) != 1 || l.Endpoint != name || l.Attempts != 3 || l.Event.Object.ID != id || !strings.Contains(l.LastError, "endpoint down") {
+		t.Fatalf("dead letters %+v", letters)
+	}
+
+	failing.Store(false)
+	n, err := s.Client.RetryDeadLetters(ctx, []int64{l.ID, l.ID + 1000})
+	if err != nil || n != 1 {
+		t.Fatalf("RetryDeadLetters: %d, %v", n, err)
+	}
+	select {
+	case ev := <-posts:
+		if ev.ID != l.ID || ev.Object.ID != id {
+			t.Fatalf("retried post %+v", ev)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("dead letter not posted again")
+	}
+	if letters, err := s.Client.DeadLetters(ctx, name); err != nil || len(letters) != 0 {
+		t.Fatalf("after retry: %+v, %v", letters, err)
+	}
+
+	tests := []struct {
+		name string
+		call func() error
+	}{
+		{name: "bad page token", call: func() error {
+			_, err := s.API.ListDeadLetters(ctx, &proto.ListDeadLettersRequest{PageToken: "x"})
+			return err
+		}},
+		{name: "negative page size", call: func() error {
+			_, err := s.API.ListDeadLetters(ctx, &proto.ListDeadLettersRequest{PageSize: -1})
+			return err
+		}},
+		{name: "no ids", call: func() error {
+			_, err := s.API.RetryDeadLetters(ctx, &proto.RetryDeadLettersRequest{})
+			return err
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code := status.Code(tt.call()); code != codes.InvalidArgument {
+				t.Fatalf("got code %v, want InvalidArgument", code)
+			}
+		})
+	}
+}
@@ -0,0 +1,351 @@
+package servertest
+
+import (
+	"context"
+	"maps"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/NikoMalik/potoc/internal/models"
+	"github.com/NikoMalik/potoc/internal/repository"
+	"github.com/NikoMalik/uuid"
+)
+
+var (
+	_ repository.SocketRepo = (*MemoryRepo)(nil)
+	_ repository.TxRepo     = (*MemoryRepo)(nil)
+	_ repository.EventRepo  = (*MemoryRepo)(nil)
+	_ repository.QueueRepo  = (*MemoryRepo)(nil)
+)
+
+// MemoryRepo is a SocketRepo keeping objects in a map. It records their
+// changes as an EventRepo, like the trigger on socket_data does, leases
+// them as a QueueRepo and stores the objects of a transaction at once as a
+// TxRepo.
+type MemoryRepo struct {
+	mu      sync.Mutex
+	objects map[string]*memoryObject
+
+	events    []models.ObjectEvent
+	seq       int64
+	listeners map[*func()]struct{}
+}
+
+type memoryObject struct {
+	data      *models.SocketData
+	createdAt time.Time
+
+	leaseID    string
+	leasedBy   string
+	leaseUntil time.Time
+	deliveries int
+}
+
+func NewMemoryRepo() *MemoryRepo {
+	return &MemoryRepo{
+		objects:   map[string]*memoryObject{},
+		listeners: map[*func()]struct{}{},
+	}
+}
+
+func (r *MemoryRepo) Create(ctx context.Context, data *models.SocketData) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.add(copyData(data), time.Now())
+	return data.ID.String(), nil
+}
+
+// add stores data and records its creation. r.mu must be held.
+func (r *MemoryRepo) add(data *models.SocketData, createdAt time.Time) {
+	o := &memoryObject{data: data, createdAt: createdAt}
+	r.objects[data.ID.String()] = o
+	r.record(models.EventCreated, o)
+}
+
+func copyData(data *models.SocketData) *models.SocketData {
+	return &models.SocketData{
+		ID:     data.ID,
+		Data:   append([]byte(nil), data.Data...),
+		Labels: maps.Clone(data.Labels),
+		Queue:  data.Queue,
+	}
+}
+
+func (r *MemoryRepo) CreateAll(ctx context.Context, objects []*models.SocketData) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	for _, data := range objects {
+		r.add(copyData(data), now)
+	}
+	return nil
+}
+
+func (r *MemoryRepo) Get(_ context.Context, id string) (*models.SocketData, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	o, ok := r.objects[id]
+	if !ok {
+		return nil, repository.ErrNotFound
+	}
+	return &models.SocketData{ID: o.data.ID, Data: o.data.Data, Labels: maps.Clone(o.data.Labels), Queue: o.data.Queue}, nil
+}
+
+func (r *MemoryRepo) Delete(_ context.Context, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	o, ok := r.objects[id]
+	if !ok {
+		return repository.ErrNotFound
+	}
+	delete(r.objects, id)
+	r.record(models.EventDeleted, o)
+	return nil
+}
+
+func (r *MemoryRepo) List(_ context.Context, f repository.ListFilter) ([]models.ObjectInfo, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var objects []models.ObjectInfo
+	for id, o := range r.objects {
+		if id > f.After && hasLabels(o.data.Labels, f.Labels) {
+			objects = append(objects, o.info())
+		}
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].ID < objects[j].ID })
+	if f.Limit > 0 && len(objects) > f.Limit {
+		objects = objects[:f.Limit]
+	}
+	return objects, nil
+}
+
+func (r *MemoryRepo) Stat(_ context.Context, id string) (*models.ObjectInfo, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	o, ok := r.objects[id]
+	if !ok {
+		return nil, repository.ErrNotFound
+	}
+	info := o.info()
+	return &info, nil
+}
+
+func (r *MemoryRepo) DeleteAll(context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, o := range r.objects {
+		r.record(models.EventDeleted, o)
+	}
+	clear(r.objects)
+	return nil
+}
+
+func (r *MemoryRepo) Count(context.Context) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.objects), nil
+}
+
+func (r *MemoryRepo) Update(ctx context.Context, id string) (*models.SocketData, error) {
+	return r.Get(ctx, id)
+}
+
+// record appends an event and wakes the listeners. r.mu must be held.
+func (r *MemoryRepo) record(kind string, o *memoryObject) {
+	r.seq++
+	r.events = append(r.events, models.ObjectEvent{
+		Seq:    r.seq,
+		Kind:   kind,
+		Object: o.info(),
+		Time:   time.Now(),
+	})
+	for wake := range r.listeners {
+		(*wake)()
+	}
+}
+
+func (r *MemoryRepo) After(_ context.Context, after int64, limit int) ([]models.ObjectEvent, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i := sort.Search(len(r.events), func(i int) bool { return r.events[i].Seq > after })
+	events := r.events[i:]
+	if limit > 0 && len(events) > limit {
+		events = events[:limit]
+	}
+	return append([]models.ObjectEvent(nil), events...), nil
+}
+
+func (r *MemoryRepo) Bounds(context.Context) (first, last int64, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.events) == 0 {
+		return 0, 0, nil
+	}
+	return r.events[0].Seq, r.events[len(r.events)-1].Seq, nil
+}
+
+func (r *MemoryRepo) Prune(_ context.Context, before time.Time) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var n int
+	for n < len(r.events)-1 && r.events[n].Time.Before(before) {
+		n++
+	}
+	r.events = r.events[n:]
+	return int64(n), nil
+}
+
+func (r *MemoryRepo) Listen(ctx context.Context, wake func()) error {
+	r.mu.Lock()
+	r.listeners[&wake] = struct{}{}
+	r.mu.Unlock()
+	defer func() {
+		r.mu.Lock()
+		delete(r.listeners, &wake)
+		r.mu.Unlock()
+	}()
+
+	wake()
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (r *MemoryRepo) Claim(_ context.Context, queue, consumer string, n int, visibility time.Duration) ([]models.Lease, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	var visible []*memoryObject
+	for _, o := range r.objects {
+		if o.data.Queue == queue && !o.leaseUntil.After(now) {
+			visible = append(visible, o)
+		}
+	}
+	sort.Slice(visible, func(i, j int) bool {
+		a, b := visible[i], visible[j]
+		if !a.createdAt.Equal(b.createdAt) {
+			return a.createdAt.Before(b.createdAt)
+		}
+		return a.data.ID.String() < b.data.ID.String()
+	})
+	if len(visible) > n {
+		visible = visible[:n]
+	}
+
+	leases := make([]models.Lease, len(visible))
+	for i, o := range visible {
+		o.leaseID, o.leasedBy, o.leaseUntil = uuid.New().String(), consumer, now.Add(visibility)
+		o.deliveries++
+		leases[i] = models.Lease{
+			Object:  o.info(),
+			Data:    append([]byte(nil), o.data.Data...),
+			LeaseID: o.leaseID,
+			Until:   o.leaseUntil,
+			Attempt: o.deliveries,
+		}
+	}
+	return leases, nil
+}
+
+func (r *MemoryRepo) InFlight(_ context.Context, consumer string) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	var n int
+	for _, o := range r.objects {
+		if o.leasedBy == consumer && o.leaseUntil.After(now) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (r *MemoryRepo) Ack(_ context.Context, id, leaseID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	o, err := r.leased(id, leaseID)
+	if err != nil {
+		return err
+	}
+	delete(r.objects, id)
+	r.record(models.EventDeleted, o)
+	return nil
+}
+
+func (r *MemoryRepo) Nack(_ context.Context, id, leaseID string, delay time.Duration) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	o, err := r.leased(id, leaseID)
+	if err != nil {
+		return err
+	}
+	o.leaseID, o.leasedBy, o.leaseUntil = "", "", time.Now().Add(delay)
+	return nil
+}
+
+func (r *MemoryRepo) Release(_ context.Context, consumer string) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	var n int64
+	for _, o := range r.objects {
+		if o.leasedBy == consumer && o.leaseUntil.After(now) {
+			o.leaseID, o.leasedBy, o.leaseUntil = "", "", time.Time{}
+			n++
+		}
+	}
+	return n, nil
+}
+
+// leased returns the object id if leaseID holds it. r.mu must be held.
+func (r *MemoryRepo) leased(id, leaseID string) (*memoryObject, error) {
+	o, ok := r.objects[id]
+	if !ok {
+		return nil, repository.ErrNotFound
+	}
+	if o.leaseID != leaseID || !o.leaseUntil.After(time.Now()) {
+		return nil, repository.ErrLeaseLost
+	}
+	return o, nil
+}
+
+func (o *memoryObject) info() models.ObjectInfo {
+	labels := maps.Clone(o.data.Labels)
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	return models.ObjectInfo{
+		ID:        o.data.ID.String(),
+		Size:      int64(len(o.data.Data)),
+		Labels:    labels,
+		CreatedAt: o.createdAt,
+		Queue:     o.data.Queue,
+	}
+}
+
+// hasLabels reports whether labels contain every pair of want, like the
+// jsonb @> the Postgres repository filters with.
+func hasLabels(labels, want map[string]string) bool {
+	for k, v := range want {
+		if got, ok := labels[k]; !ok || got != v {
+			return false
+		}
+	}
+	return true
+}
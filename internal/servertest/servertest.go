@@ -0,0 +1,172 @@
+// Package servertest runs the potoc gRPC server in process for tests and
+// benchmarks.
+//
+//	s := servertest.Start(t)
+//	id, err := s.Client.Put(ctx, strings.NewReader("hello"))
+//
+// The server keeps its objects in a MemoryRepo, or in the Postgres database
+// at $DATABASE_URL when it is set. Migrations are applied to that database
+// and objects written by tests are left in it.
+package servertest
+
+import (
+	"context"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/NikoMalik/potoc/internal/config"
+	"github.com/NikoMalik/potoc/internal/database"
+	"github.com/NikoMalik/potoc/internal/repository"
+	"github.com/NikoMalik/potoc/internal/server"
+	"github.com/NikoMalik/potoc/pkg/client"
+	"github.com/NikoMalik/potoc/pkg/proto"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// DatabaseURLEnv names the variable selecting a real database.
+const DatabaseURLEnv = "DATABASE_URL"
+
+const bufSize = 1 << 20
+
+// Server is a running server and a connection to it.
+type Server struct {
+	GRPC   *server.GRPC
+	Repos  *repository.Repositories
+	Conn   *grpc.ClientConn
+	API    proto.DataTranferClient
+	Client *client.Client
+}
+
+type options struct {
+	config *config.Config
+	repos  *repository.Repositories
+}
+
+// Option configures Start.
+type Option func(*options)
+
+// WithLimits sets the per stream limits.
+func WithLimits(limits *config.Limits) Option {
+	return func(o *options) { o.config.Limits = limits }
+}
+
+// WithServerOptions adds options to the gRPC server, e.g. a stats handler.
+func WithServerOptions(opts ...grpc.ServerOption) Option {
+	return func(o *options) { o.config.Server.Opts = append(o.config.Server.Opts, opts...) }
+}
+
+// WithWebhooks makes the server post events to the endpoints in cfg.
+func WithWebhooks(cfg *config.Webhooks) Option {
+	return func(o *options) { o.config.Webhooks = cfg }
+}
+
+// WithConfig replaces the server config. Its Server and Limits must be set,
+// events and queues are disabled when Events or Queue is nil.
+func WithConfig(cfg *config.Config) Option {
+	return func(o *options) { o.config = cfg }
+}
+
+// WithRepo makes the server use repo whatever $DATABASE_URL says.
+// Transactions, events and queues are served from repo too when it is a
+// TxRepo, an EventRepo or a QueueRepo, and webhooks from a
+// MemoryWebhookRepo over its events.
+func WithRepo(repo repository.SocketRepo) Option {
+	return func(o *options) {
+		o.repos = &repository.Repositories{SocketRepo: repo}
+		o.repos.TxRepo, _ = repo.(repository.TxRepo)
+		o.repos.EventRepo, _ = repo.(repository.EventRepo)
+		o.repos.QueueRepo, _ = repo.(repository.QueueRepo)
+		if o.repos.EventRepo != nil {
+			o.repos.WebhookRepo = NewMemoryWebhookRepo(o.repos.EventRepo)
+		}
+	}
+}
+
+// Start starts a server on an in-memory listener and stops it when the
+// test ends.
+func Start(tb testing.TB, opts ...Option) *Server {
+	tb.Helper()
+
+	o := options{config: &config.Config{
+		Server: &config.Server{},
+		Limits: &config.Limits{},
+		Events: &config.Events{
+			Enabled:      true,
+			Buffer:       256,
+			PollInterval: time.Second,
+			Retention:    time.Hour,
+		},
+		Queue: &config.Queue{
+			VisibilityTimeout:    30 * time.Second,
+			MaxVisibilityTimeout: time.Hour,
+			Prefetch:             10,
+			MaxPrefetch:          100,
+			PollInterval:         time.Second,
+		},
+	}}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.repos == nil {
+		o.repos = Repositories(tb)
+	}
+
+	g := server.NewGRPC(o.config, o.repos)
+	lis := bufconn.Listen(bufSize)
+	go g.Run(lis)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		tb.Fatalf("servertest: dial: %v", err)
+	}
+	tb.Cleanup(func() {
+		conn.Close()
+		g.Stop()
+	})
+
+	return &Server{
+		GRPC:   g,
+		Repos:  o.repos,
+		Conn:   conn,
+		API:    proto.NewDataTranferClient(conn),
+		Client: client.NewFromConn(conn),
+	}
+}
+
+// Repositories returns Postgres repositories when $DATABASE_URL is set and
+// a MemoryRepo otherwise.
+func Repositories(tb testing.TB) *repository.Repositories {
+	tb.Helper()
+
+	url := os.Getenv(DatabaseURLEnv)
+	if url == "" {
+		repo := NewMemoryRepo()
+		return &repository.Repositories{
+			SocketRepo:  repo,
+			TxRepo:      repo,
+			EventRepo:   repo,
+			QueueRepo:   repo,
+			WebhookRepo: NewMemoryWebhookRepo(repo),
+		}
+	}
+
+	db, err := pgxpool.New(context.Background(), url)
+	if err != nil {
+		tb.Fatalf("servertest: connect to %s: %v", DatabaseURLEnv, err)
+	}
+	tb.Cleanup(db.Close)
+	if err := database.Migrate(db, ""); err != nil {
+		tb.Fatalf("servertest: %v", err)
+	}
+	return repository.NewRepositories(db)
+}
@@ -0,0 +1,149 @@
+package servertest
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/NikoMalik/potoc/internal/models"
+	"github.com/NikoMalik/potoc/internal/repository"
+)
+
+var _ repository.WebhookRepo = (*MemoryWebhookRepo)(nil)
+
+// MemoryWebhookRepo is a WebhookRepo keeping the outbox in a slice. It
+// copies the events recorded by an EventRepo, usually a MemoryRepo, after
+// it was created.
+type MemoryWebhookRepo struct {
+	events repository.EventRepo
+
+	mu     sync.Mutex
+	cursor int64
+	nextID int64
+	outbox []*models.WebhookDelivery
+}
+
+func NewMemoryWebhookRepo(events repository.EventRepo) *MemoryWebhookRepo {
+	w := &MemoryWebhookRepo{events: events}
+	_, w.cursor, _ = events.Bounds(context.Background())
+	return w
+}
+
+func (w *MemoryWebhookRepo) Enqueue(ctx context.Context, limit int, route func(*models.ObjectEvent) []string) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	events, err := w.events.After(ctx, w.cursor, limit)
+	if err != nil {
+		return 0, err
+	}
+	for i := range events {
+		ev := events[i]
+		for _, endpoint := range route(&ev) {
+			w.nextID++
+			w.outbox = append(w.outbox, &models.WebhookDelivery{
+				ID:          w.nextID,
+				Endpoint:    endpoint,
+				Event:       ev,
+				NextAttempt: time.Now(),
+			})
+		}
+		w.cursor = ev.Seq
+	}
+	return len(events), nil
+}
+
+func (w *MemoryWebhookRepo) Claim(_ context.Context, endpoints []string, n int, lease time.Duration) ([]models.WebhookDelivery, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	now := time.Now()
+	var due []*models.WebhookDelivery
+	for _, d := range w.outbox {
+		if d.DeadAt.IsZero() && !d.NextAttempt.After(now) && slices.Contains(endpoints, d.Endpoint) {
+			due = append(due, d)
+		}
+	}
+	sort.SliceStable(due, func(i, j int) bool { return due[i].NextAttempt.Before(due[j].NextAttempt) })
+	if len(due) > n {
+		due = due[:n]
+	}
+
+	claimed := make([]models.WebhookDelivery, len(due))
+	for i, d := range due {
+		d.Attempts++
+		d.NextAttempt = now.Add(lease)
+		claimed[i] = *d
+	}
+	return claimed, nil
+}
+
+func (w *MemoryWebhookRepo) Delivered(_ context.Context, id int64, attempt int) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.outbox = slices.DeleteFunc(w.outbox, func(d *models.WebhookDelivery) bool {
+		return d.ID == id && d.Attempts == attempt
+	})
+	return nil
+}
+
+func (w *MemoryWebhookRepo) Retry(_ context.Context, id int64, attempt int, at time.Time, reason string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if d := w.find(id); d != nil && d.Attempts == attempt {
+		d.NextAttempt, d.LastError = at, reason
+	}
+	return nil
+}
+
+func (w *MemoryWebhookRepo) Dead(_ context.Context, id int64, attempt int, reason string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if d := w.find(id); d != nil && d.Attempts == attempt {
+		d.DeadAt, d.LastError = time.Now(), reason
+	}
+	return nil
+}
+
+func (w *MemoryWebhookRepo) DeadLetters(_ context.Context, f repository.DeadLetterFilter) ([]models.WebhookDelivery, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var letters []models.WebhookDelivery
+	for _, d := range w.outbox {
+		if d.DeadAt.IsZero() || d.ID <= f.After || (f.Endpoint != "" && d.Endpoint != f.Endpoint) {
+			continue
+		}
+		if len(letters) == f.Limit {
+			break
+		}
+		letters = append(letters, *d)
+	}
+	return letters, nil
+}
+
+func (w *MemoryWebhookRepo) Redrive(_ context.Context, ids []int64) (int64, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var n int64
+	for _, id := range ids {
+		if d := w.find(id); d != nil && !d.DeadAt.IsZero() {
+			d.DeadAt, d.Attempts, d.NextAttempt = time.Time{}, 0, time.Now()
+			n++
+		}
+	}
+	return n, nil
+}
+
+// find returns the delivery with the given id. w.mu must be held.
+func (w *MemoryWebhookRepo) find(id int64) *models.WebhookDelivery {
+	for _, d := range w.outbox {
+		if d.ID == id {
+			return d
+		}
+	}
+	return nil
+}
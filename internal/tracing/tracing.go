@@ -0,0 +1,119 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/NikoMalik/potoc/internal/config"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	ExporterOTLP   = "otlp"
+	ExporterStdout = "stdout"
+	ExporterNone   = "none"
+
+	tracerName         = "github.com/NikoMalik/potoc"
+	defaultServiceName = "potoc"
+)
+
+var _errUnknownExporter = errors.New("unknown tracing exporter")
+
+// Tracer returns the tracer used for potoc spans. It is backed by the global
+// provider, so spans are dropped until NewProvider has been called.
+func Tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// NewProvider builds the exporter described by cfg and installs a tracer
+// provider and W3C trace context propagator globally. It returns nil when
+// tracing is disabled; the caller owns Shutdown of the returned provider.
+func NewProvider(ctx context.Context, cfg *config.Tracing) (*sdktrace.TracerProvider, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	if cfg == nil || !cfg.Enabled || cfg.Exporter == ExporterNone {
+		return nil, nil
+	}
+
+	exporter, err := newExporter(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewProviderWithExporter(cfg, exporter), nil
+}
+
+// NewProviderWithExporter installs a tracer provider that sends spans to
+// exporter, sampling cfg.SampleRatio of the traces; a nil cfg samples all
+// of them.
+func NewProviderWithExporter(cfg *config.Tracing, exporter sdktrace.SpanExporter) *sdktrace.TracerProvider {
+	serviceName := defaultServiceName
+	ratio := 1.0
+	if cfg != nil {
+		if cfg.ServiceName != "" {
+			serviceName = cfg.ServiceName
+		}
+		ratio = cfg.SampleRatio
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(serviceName))),
+	)
+	otel.SetTracerProvider(tp)
+
+	return tp
+}
+
+func newExporter(ctx context.Context, cfg *config.Tracing) (sdktrace.SpanExporter, error) {
+	switch cfg.Exporter {
+	case ExporterOTLP:
+		opts := []otlptracegrpc.Option{}
+		if cfg.Endpoint != "" {
+			opts = append(opts, otlptracegrpc.WithEndpoint(cfg.Endpoint))
+		}
+		if cfg.Insecure {
+			opts = append(opts, otlptracegrpc.WithInsecure())
+		}
+		return otlptracegrpc.New(ctx, opts...)
+	case ExporterStdout, "":
+		return stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	default:
+		return nil, fmt.Errorf("%w: %q", _errUnknownExporter, cfg.Exporter)
+	}
+}
+
+// StartDB starts a client span for a query against table.
+func StartDB(ctx context.Context, name, operation, table string) (context.Context, trace.Span) {
+	return Tracer().Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBSystemPostgreSQL,
+			semconv.DBOperationName(operation),
+			semconv.DBCollectionName(table),
+		),
+	)
+}
+
+// End records err on span, if any, and ends it.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,37 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+
+	"github.com/NikoMalik/potoc/internal/config"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestSampleRatio(t *testing.T) {
+	prev := otel.GetTracerProvider()
+	t.Cleanup(func() { otel.SetTracerProvider(prev) })
+
+	tests := []struct {
+		name string
+		cfg  *config.Tracing
+		want bool
+	}{
+		{name: "no config", cfg: nil, want: true},
+		{name: "all", cfg: &config.Tracing{SampleRatio: 1}, want: true},
+		{name: "none", cfg: &config.Tracing{SampleRatio: 0}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tp := NewProviderWithExporter(tt.cfg, tracetest.NewInMemoryExporter())
+			defer tp.Shutdown(context.Background())
+
+			_, span := Tracer().Start(context.Background(), "test")
+			span.End()
+			if got := span.SpanContext().IsSampled(); got != tt.want {
+				t.Fatalf("sampled %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
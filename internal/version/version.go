@@ -0,0 +1,40 @@
+package version
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+// Version is stamped at build time:
+//
+//	go build -ldflags "-X github.com/NikoMalik/potoc/internal/version.Version=v1.2.3"
+var Version = "dev"
+
+type Build struct {
+	GoVersion string
+	Commit    string
+	Time      string
+	Modified  bool
+}
+
+// ReadBuild returns the VCS details the Go toolchain embedded in the binary.
+func ReadBuild() Build {
+	b := Build{GoVersion: runtime.Version()}
+
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return b
+	}
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			b.Commit = s.Value
+		case "vcs.time":
+			b.Time = s.Value
+		case "vcs.modified":
+			b.Modified = s.Value == "true"
+		}
+	}
+
+	return b
+}
@@ -0,0 +1,173 @@
+// Package client is the Go SDK for the potoc DataTranfer service.
+//
+//	c, err := client.New("localhost:50051")
+//	if err != nil {
+//		return err
+//	}
+//	defer c.Close()
+//
+//	id, err := c.Put(ctx, strings.NewReader("hello"))
+package client
+
+import (
+	"context"
+	"errors"
+
+	"github.com/NikoMalik/potoc/pkg/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// ErrNotFound is returned, wrapped, when the server has no object with the
+// requested id.
+var ErrNotFound = errors.New("potoc: object not found")
+
+// Client is safe for concurrent use.
+type Client struct {
+	conn *grpc.ClientConn
+	api  proto.DataTranferClient
+	opts options
+	sem  chan struct{}
+
+	// serviceRetry is set when gRPC retries unary calls through the
+	// service config, so the client does not retry them again.
+	serviceRetry bool
+}
+
+type options struct {
+	creds          credentials.TransportCredentials
+	dialOpts       []grpc.DialOption
+	maxConcurrency int
+	retry          RetryPolicy
+}
+
+// Option configures a Client.
+type Option func(*options)
+
+// WithTransportCredentials sets the connection credentials. Without it the
+// connection is plaintext.
+func WithTransportCredentials(creds credentials.TransportCredentials) Option {
+	return func(o *options) { o.creds = creds }
+}
+
+// WithDialOptions passes extra options to grpc.NewClient.
+func WithDialOptions(opts ...grpc.DialOption) Option {
+	return func(o *options) { o.dialOpts = append(o.dialOpts, opts...) }
+}
+
+// WithMaxConcurrency bounds the number of calls in flight at once. Further
+// calls wait for a free slot. Zero means no limit.
+func WithMaxConcurrency(n int) Option {
+	return func(o *options) { o.maxConcurrency = n }
+}
+
+// WithRetryPolicy replaces DefaultRetryPolicy for every call.
+func WithRetryPolicy(p RetryPolicy) Option {
+	return func(o *options) { o.retry = p }
+}
+
+// New connects to the server at target. The connection is established
+// lazily on the first call. Unary calls are retried by gRPC according to
+// a service config built from the retry policy; a service config passed
+// with WithDialOptions takes precedence. Calls given their own policy with
+// WithCallRetry are retried by the client instead.
+func New(target string, opts ...Option) (*Client, error) {
+	o := newOptions(opts)
+
+	creds := o.creds
+	if creds == nil {
+		creds = insecure.NewCredentials()
+	}
+	dialOpts := []grpc.DialOption{grpc.WithTransportCredentials(creds)}
+	serviceRetry := o.retry.MaxAttempts > 1
+	if serviceRetry {
+		dialOpts = append(dialOpts, grpc.WithDefaultServiceConfig(serviceConfig(o.retry)))
+	}
+	dialOpts = append(dialOpts, o.dialOpts...)
+
+	conn, err := grpc.NewClient(target, dialOpts...)
+	if err != nil {
+		return nil, err
+	}
+
+	c := newClient(proto.NewDataTranferClient(conn), o)
+	c.conn = conn
+	c.serviceRetry = serviceRetry
+	return c, nil
+}
+
+// NewFromConn returns a client using an existing connection. Close leaves
+// the connection open; connection options are ignored and unary calls are
+// retried by the client itself.
+func NewFromConn(conn grpc.ClientConnInterface, opts ...Option) *Client {
+	return newClient(proto.NewDataTranferClient(conn), newOptions(opts))
+}
+
+func newOptions(opts []Option) options {
+	o := options{retry: DefaultRetryPolicy}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+func newClient(api proto.DataTranferClient, o options) *Client {
+	c := &Client{api: api, opts: o}
+	if o.maxConcurrency > 0 {
+		c.sem = make(chan struct{}, o.maxConcurrency)
+	}
+	return c
+}
+
+// Close closes the connection opened by New.
+func (c *Client) Close() error {
+	if c.conn == nil {
+		return nil
+	}
+	return c.conn.Close()
+}
+
+// ServerInfo describes the server, see proto.ServerInfo.
+func (c *Client) ServerInfo(ctx context.Context, opts ...CallOption) (*proto.ServerInfo, error) {
+	var info *proto.ServerInfo
+	err := c.unary(ctx, opts, func(ctx context.Context, callOpts ...grpc.CallOption) (err error) {
+		info, err = c.api.GetServerInfo(ctx, &proto.ServerInfoRequest{}, callOpts...)
+		return err
+	})
+	return info, err
+}
+
+// unary runs call in a concurrency slot. Exactly one layer retries it: the
+// service config for the client's policy, if there is one, and the client
+// for a per call policy or when there is no service config.
+func (c *Client) unary(ctx context.Context, opts []CallOption, call func(ctx context.Context, callOpts ...grpc.CallOption) error) error {
+	release, err := c.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	o := c.callOptions(opts)
+	if c.serviceRetry && !o.ownRetry {
+		return call(ctx)
+	}
+	// Without a retry buffer gRPC commits to the first attempt, so it
+	// leaves retrying to the client.
+	return retry(ctx, o.retry, func() error {
+		return call(ctx, grpc.MaxRetryRPCBufferSize(0))
+	})
+}
+
+// acquire waits for a concurrency slot.
+func (c *Client) acquire(ctx context.Context) (release func(), err error) {
+	if c.sem == nil {
+		return func() {}, nil
+	}
+	select {
+	case c.sem <- struct{}{}:
+		return func() { <-c.sem }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
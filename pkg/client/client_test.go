@@ -0,0 +1,174 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/NikoMalik/potoc/internal/servertest"
+	"github.com/NikoMalik/potoc/pkg/client"
+	"github.com/NikoMalik/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestRoundTrip(t *testing.T) {
+	s := servertest.Start(t)
+	c := s.Client
+	ctx := context.Background()
+
+	labels := map[string]string{"test-run": uuid.New().String()}
+	id, err := c.Put(ctx, strings.NewReader("hello"), client.WithLabels(labels))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := c.Get(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(r)
+	r.Close()
+	if err != nil || string(data) != "hello" {
+		t.Fatalf("Get: %q, %v", data, err)
+	}
+
+	obj, err := c.Stat(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if obj.ID != id || obj.Size != 5 || obj.Labels["test-run"] != labels["test-run"] || obj.CreatedAt.IsZero() {
+		t.Fatalf("Stat: %+v", obj)
+	}
+
+	objects, err := c.List(ctx, client.WithLabelFilter(labels))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 1 || objects[0].ID != id {
+		t.Fatalf("List: %+v", objects)
+	}
+
+	if err := c.Delete(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNotFound(t *testing.T) {
+	s := servertest.Start(t)
+	c := s.Client
+	ctx := context.Background()
+	id := uuid.New().String()
+
+	_, getErr := c.Get(ctx, id)
+	_, statErr := c.Stat(ctx, id)
+	deleteErr := c.Delete(ctx, id)
+
+	for name, err := range map[string]error{"Get": getErr, "Stat": statErr, "Delete": deleteErr} {
+		if !errors.Is(err, client.ErrNotFound) {
+			t.Errorf("%s: got %v, want ErrNotFound", name, err)
+		}
+	}
+}
+
+func TestStreams(t *testing.T) {
+	s := servertest.Start(t)
+	ctx := context.Background()
+
+	u, err := s.Client.NewUploadStream(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"a", "bb", "", "dddd"}
+	ids := make([]string, len(want))
+	for i, data := range want {
+		if ids[i], err = u.Put(strings.NewReader(data)); err != nil {
+			t.Fatalf("Put %d: %v", i, err)
+		}
+	}
+	if err := u.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := s.Client.NewDownloadStream(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	for i, id := range ids {
+		r, err := d.Get(id)
+		if err != nil {
+			t.Fatalf("Get %d: %v", i, err)
+		}
+		data, _ := io.ReadAll(r)
+		if string(data) != want[i] {
+			t.Errorf("Get %d: got %q, want %q", i, data, want[i])
+		}
+	}
+
+	// A failed fetch ends the server side stream; the next one reopens it.
+	if _, err := d.Get(uuid.New().String()); !errors.Is(err, client.ErrNotFound) {
+		t.Fatalf("got %v, want ErrNotFound", err)
+	}
+	if _, err := d.Get(ids[0]); err != nil {
+		t.Fatalf("Get after a failed one: %v", err)
+	}
+}
+
+func TestTransactionalUploadStream(t *testing.T) {
+	s := servertest.Start(t)
+	ctx := context.Background()
+
+	upload := func(data ...string) (*client.UploadStream, []string) {
+		t.Helper()
+		u, err := s.Client.NewUploadStream(ctx, client.WithTransaction())
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids := make([]string, len(data))
+		for i, d := range data {
+			if ids[i], err = u.Put(strings.NewReader(d)); err != nil {
+				t.Fatalf("Put %d: %v", i, err)
+			}
+		}
+		return u, ids
+	}
+
+	u, ids := upload("a", "b")
+	if _, err := s.Client.Stat(ctx, ids[0]); !errors.Is(err, client.ErrNotFound) {
+		t.Fatalf("Stat before Commit: got %v, want ErrNotFound", err)
+	}
+	if n, err := u.Commit(); err != nil || n != 2 {
+		t.Fatalf("Commit: %d, %v", n, err)
+	}
+	if _, err := u.Put(strings.NewReader("c")); !errors.Is(err, client.ErrCommitted) {
+		t.Fatalf("Put after Commit: got %v, want ErrCommitted", err)
+	}
+	if err := u.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range ids {
+		if _, err := s.Client.Stat(ctx, id); err != nil {
+			t.Fatalf("Stat after Commit: %v", err)
+		}
+	}
+
+	u, ids = upload("x")
+	if err := u.Close(); status.Code(err) != codes.Aborted {
+		t.Fatalf("Close without Commit: got %v, want Aborted", err)
+	}
+	if _, err := s.Client.Stat(ctx, ids[0]); !errors.Is(err, client.ErrNotFound) {
+		t.Fatalf("Stat after rollback: got %v, want ErrNotFound", err)
+	}
+
+	// Committing a stream without objects sends nothing.
+	u, _ = upload()
+	if n, err := u.Commit(); err != nil || n != 0 {
+		t.Fatalf("empty Commit: %d, %v", n, err)
+	}
+	if err := u.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,207 @@
+package client
+
+import (
+	"context"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/NikoMalik/potoc/pkg/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// eventSeqHeader carries the seq a new subscription starts after.
+const eventSeqHeader = "potoc-event-seq"
+
+// EventKind is what happened to an object.
+type EventKind string
+
+const (
+	EventCreated EventKind = "created"
+	EventUpdated EventKind = "updated"
+	EventDeleted EventKind = "deleted"
+)
+
+var eventKinds = map[EventKind]proto.EventKind{
+	EventCreated: proto.EventKind_EVENT_KIND_CREATED,
+	EventUpdated: proto.EventKind_EVENT_KIND_UPDATED,
+	EventDeleted: proto.EventKind_EVENT_KIND_DELETED,
+}
+
+// Event is a committed change to an object. Object is the object after the
+// change, or before it for a deletion.
+type Event struct {
+	Seq    int64     `json:"seq"`
+	Kind   EventKind `json:"kind"`
+	Object Object    `json:"object"`
+	Time   time.Time `json:"time"`
+}
+
+func newEvent(ev *proto.ObjectEvent) Event {
+	e := Event{
+		Seq:    ev.GetSeq(),
+		Object: newObject(ev.GetObject()),
+		Time:   time.Unix(0, ev.GetTime()).UTC(),
+	}
+	for k, v := range eventKinds {
+		if v == ev.GetKind() {
+			e.Kind = k
+		}
+	}
+	return e
+}
+
+// WithKinds makes Subscribe deliver only events of the given kinds.
+func WithKinds(kinds ...EventKind) CallOption {
+	return func(o *callOptions) { o.kinds = kinds }
+}
+
+// WithResumeAfter makes Subscribe start with the stored events after seq,
+// usually the Seq of the last event handled before a restart.
+func WithResumeAfter(seq int64) CallOption {
+	return func(o *callOptions) { o.resumeAfter = &seq }
+}
+
+type eventStream = grpc.ServerStreamingClient[proto.ObjectEvent]
+
+// Subscription delivers events in seq order. When the stream breaks with
+// a retryable error it is reopened after the last event delivered, so no
+// event is lost or repeated. It is not safe for concurrent use and holds a
+// concurrency slot until it is closed.
+type Subscription struct {
+	ctx     context.Context
+	stop    context.CancelFunc
+	api     proto.DataTranferClient
+	req     *proto.SubscribeRequest
+	policy  RetryPolicy
+	release func()
+
+	// last is the seq to resume after once resume is set.
+	last   int64
+	resume bool
+
+	stream eventStream
+	cancel context.CancelFunc
+}
+
+// Subscribe starts delivering the events of objects committed from now
+// on, or after the seq given with WithResumeAfter. Filter them with
+// WithLabelFilter and WithKinds. ctx bounds the whole subscription.
+func (c *Client) Subscribe(ctx context.Context, opts ...CallOption) (*Subscription, error) {
+	release, err := c.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	o := c.callOptions(opts)
+
+	req := &proto.SubscribeRequest{Labels: o.labelFilter}
+	for _, k := range o.kinds {
+		req.Kinds = append(req.Kinds, eventKinds[k])
+	}
+	s := &Subscription{
+		api:     c.api,
+		req:     req,
+		policy:  o.retry,
+		release: release,
+	}
+	s.ctx, s.stop = context.WithCancel(ctx)
+	if o.resumeAfter != nil {
+		s.last, s.resume = *o.resumeAfter, true
+	}
+
+	if err := retry(s.ctx, s.policy, s.open); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// open starts a stream and waits until the server has registered it.
+func (s *Subscription) open() error {
+	req := s.req
+	if s.resume {
+		req = &proto.SubscribeRequest{Labels: s.req.Labels, Kinds: s.req.Kinds, AfterSeq: &s.last}
+	}
+
+	ctx, cancel := context.WithCancel(s.ctx)
+	stream, err := s.api.Subscribe(ctx, req)
+	if err != nil {
+		cancel()
+		return err
+	}
+	md, err := stream.Header()
+	values := md.Get(eventSeqHeader)
+	if err != nil || len(values) == 0 {
+		// The call failed before the server sent its header.
+		_, err = stream.Recv()
+		cancel()
+		if err == nil || err == io.EOF {
+			err = status.Error(codes.Internal, "subscription started without a position")
+		}
+		return err
+	}
+
+	if !s.resume {
+		if s.last, err = strconv.ParseInt(values[0], 10, 64); err != nil {
+			cancel()
+			return status.Errorf(codes.Internal, "bad %s header %q", eventSeqHeader, values[0])
+		}
+		s.resume = true
+	}
+	s.stream, s.cancel = stream, cancel
+	return nil
+}
+
+// Next waits for the next event.
+func (s *Subscription) Next() (*Event, error) {
+	var ev *proto.ObjectEvent
+	err := retry(s.ctx, s.policy, func() error {
+		if s.stream == nil {
+			if err := s.open(); err != nil {
+				return err
+			}
+		}
+		var err error
+		if ev, err = s.stream.Recv(); err != nil {
+			if err == io.EOF {
+				err = status.Error(codes.Unavailable, "subscription ended by the server")
+			}
+			s.reset()
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.last = ev.GetSeq()
+	e := newEvent(ev)
+	return &e, nil
+}
+
+// Seq returns the seq of the last event delivered, to resume after with
+// WithResumeAfter.
+func (s *Subscription) Seq() int64 {
+	return s.last
+}
+
+func (s *Subscription) reset() {
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.stream, s.cancel = nil, nil
+}
+
+// Close ends the subscription.
+func (s *Subscription) Close() error {
+	s.reset()
+	s.stop()
+	if s.release != nil {
+		s.release()
+		s.release = nil
+	}
+	return nil
+}
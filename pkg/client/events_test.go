@@ -0,0 +1,101 @@
+package client_test
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/NikoMalik/potoc/internal/config"
+	"github.com/NikoMalik/potoc/internal/servertest"
+	"github.com/NikoMalik/potoc/pkg/client"
+	"github.com/NikoMalik/uuid"
+)
+
+func TestSubscribe(t *testing.T) {
+	s := servertest.Start(t)
+	c := s.Client
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	labels := map[string]string{"test-run": uuid.New().String()}
+	sub, err := c.Subscribe(ctx, client.WithLabelFilter(labels))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+
+	id, err := c.Put(ctx, strings.NewReader("hello"), client.WithLabels(labels))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Delete(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+
+	created, err := sub.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.Kind != client.EventCreated || created.Object.ID != id || created.Object.Size != 5 {
+		t.Fatalf("first event: %+v", created)
+	}
+	deleted, err := sub.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted.Kind != client.EventDeleted || deleted.Object.ID != id || sub.Seq() != deleted.Seq {
+		t.Fatalf("second event: %+v, Seq %d", deleted, sub.Seq())
+	}
+
+	// A new subscription resuming after the first event gets the second.
+	resumed, err := c.Subscribe(ctx, client.WithLabelFilter(labels), client.WithResumeAfter(created.Seq))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resumed.Close()
+	if ev, err := resumed.Next(); err != nil || ev.Seq != deleted.Seq {
+		t.Fatalf("resumed: %+v, %v", ev, err)
+	}
+}
+
+func TestSubscribeResumes(t *testing.T) {
+	s := servertest.Start(t, servertest.WithConfig(&config.Config{
+		Server: &config.Server{},
+		Limits: &config.Limits{},
+		Events: &config.Events{Enabled: true, Buffer: 2, PollInterval: time.Second, Retention: time.Hour},
+	}))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	sub, err := s.Client.Subscribe(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+
+	// The server ends the stream of a subscriber this far behind; the
+	// subscription reopens it and still delivers every event once.
+	labels := map[string]string{}
+	for i := range 30 {
+		labels[strconv.Itoa(i)] = strings.Repeat("v", 255)
+	}
+	const n = 50
+	ids := make([]string, n)
+	for i := range ids {
+		if ids[i], err = s.Client.Put(ctx, strings.NewReader("x"), client.WithLabels(labels)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i, id := range ids {
+		ev, err := sub.Next()
+		if err != nil {
+			t.Fatalf("event %d: %v", i, err)
+		}
+		if ev.Object.ID != id {
+			t.Fatalf("event %d: got %s, want %s", i, ev.Object.ID, id)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/NikoMalik/potoc/pkg/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	statusOK       = "ok"
+	statusDraining = "draining"
+
+	listPageSize = 500
+)
+
+// Object describes a stored object.
+type Object struct {
+	ID        string            `json:"id"`
+	Size      int64             `json:"size"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+	Queue     string            `json:"queue,omitempty"`
+}
+
+func newObject(o *proto.ObjectInfo) Object {
+	obj := Object{
+		ID:     o.GetSocketId(),
+		Size:   o.GetSize(),
+		Labels: o.GetLabels(),
+		Queue:  o.GetQueue(),
+	}
+	if o.GetCreatedAt() != 0 {
+		obj.CreatedAt = time.Unix(0, o.GetCreatedAt()).UTC()
+	}
+	return obj
+}
+
+// Put stores everything read from r as one object and returns its id.
+// The whole object is held in memory and must fit the server's maximum
+// message size once base64 encoded. To store many objects use an
+// UploadStream.
+func (c *Client) Put(ctx context.Context, r io.Reader, opts ...CallOption) (string, error) {
+	u, err := c.NewUploadStream(ctx, opts...)
+	if err != nil {
+		return "", err
+	}
+	id, err := u.Put(r)
+	if err != nil {
+		u.Close()
+		return "", err
+	}
+	return id, u.Close()
+}
+
+// Get returns the object with the given id. The object is read into memory
+// before Get returns; closing the reader releases nothing but is required
+// for forward compatibility.
+func (c *Client) Get(ctx context.Context, id string, opts ...CallOption) (io.ReadCloser, error) {
+	d, err := c.NewDownloadStream(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	r, err := d.Get(id)
+	if err != nil {
+		d.Close()
+		return nil, err
+	}
+	return r, d.Close()
+}
+
+// Delete removes the object with the given id.
+func (c *Client) Delete(ctx context.Context, id string, opts ...CallOption) error {
+	err := c.unary(ctx, opts, func(ctx context.Context, callOpts ...grpc.CallOption) error {
+		_, err := c.api.Delete(ctx, &proto.DeleteRequest{SocketId: id}, callOpts...)
+		return err
+	})
+	return objectError(id, err)
+}
+
+// List returns every stored object ordered by id.
+func (c *Client) List(ctx context.Context, opts ...CallOption) ([]Object, error) {
+	var all []Object
+	token := ""
+	for {
+		objects, next, err := c.ListPage(ctx, token, listPageSize, opts...)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, objects...)
+		if next == "" {
+			return all, nil
+		}
+		token = next
+	}
+}
+
+// ListPage returns one page of objects after token, which is empty for
+// the first page, and the token for the next page, empty on the last one.
+func (c *Client) ListPage(ctx context.Context, token string, size int, opts ...CallOption) ([]Object, string, error) {
+	var resp *proto.ListResponse
+	err := c.unary(ctx, opts, func(ctx context.Context, callOpts ...grpc.CallOption) (err error) {
+		resp, err = c.api.List(ctx, &proto.ListRequest{
+			PageToken: token,
+			PageSize:  int32(size),
+			Labels:    c.callOptions(opts).labelFilter,
+		}, callOpts...)
+		return err
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	objects := make([]Object, len(resp.GetObjects()))
+	for i, o := range resp.GetObjects() {
+		objects[i] = newObject(o)
+	}
+	return objects, resp.GetNextPageToken(), nil
+}
+
+// Stat describes the object with the given id without fetching its data.
+func (c *Client) Stat(ctx context.Context, id string, opts ...CallOption) (*Object, error) {
+	var info *proto.ObjectInfo
+	err := c.unary(ctx, opts, func(ctx context.Context, callOpts ...grpc.CallOption) (err error) {
+		info, err = c.api.Stat(ctx, &proto.StatRequest{SocketId: id}, callOpts...)
+		return err
+	})
+	if err != nil {
+		return nil, objectError(id, err)
+	}
+	o := newObject(info)
+	return &o, nil
+}
+
+func objectError(id string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if status.Code(err) == codes.NotFound && !errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+	return err
+}
@@ -0,0 +1,188 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/NikoMalik/potoc/pkg/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrLeaseLost is returned, wrapped, by Ack and Nack when the lease expired
+// and the object may have been delivered to another consumer.
+var ErrLeaseLost = errors.New("potoc: lease expired or lost")
+
+// WithQueue puts the objects stored by Put or an UploadStream in queue,
+// for Consume.
+func WithQueue(queue string) CallOption {
+	return func(o *callOptions) { o.queue = queue }
+}
+
+// WithVisibilityTimeout sets how long Consume leases each object before
+// delivering it again unless it is acked or nacked. Zero leaves it to the
+// server.
+func WithVisibilityTimeout(d time.Duration) CallOption {
+	return func(o *callOptions) { o.visibility = d }
+}
+
+// WithPrefetch bounds the deliveries a Consumer holds unacked at once.
+// Zero leaves it to the server.
+func WithPrefetch(n int) CallOption {
+	return func(o *callOptions) { o.prefetch = n }
+}
+
+// Delivery is an object leased to a Consumer. Ack it once handled or Nack
+// it to give it back; otherwise it is delivered again after the visibility
+// timeout.
+type Delivery struct {
+	Object Object
+	Data   []byte
+	// LeaseID proves the lease to Ack and Nack.
+	LeaseID string
+	// Attempt is 1 on the first delivery of the object.
+	Attempt        int
+	LeaseExpiresAt time.Time
+
+	c *Client
+}
+
+// Ack deletes the delivered object.
+func (d *Delivery) Ack(ctx context.Context) error {
+	return d.c.Ack(ctx, d.Object.ID, d.LeaseID)
+}
+
+// Nack returns the object to its queue, to be delivered again after delay.
+func (d *Delivery) Nack(ctx context.Context, delay time.Duration) error {
+	return d.c.Nack(ctx, d.Object.ID, d.LeaseID, delay)
+}
+
+// Ack deletes the object id leased with leaseID. An object already acked
+// gives ErrNotFound.
+func (c *Client) Ack(ctx context.Context, id, leaseID string, opts ...CallOption) error {
+	err := c.unary(ctx, opts, func(ctx context.Context, callOpts ...grpc.CallOption) error {
+		_, err := c.api.Ack(ctx, &proto.AckRequest{SocketId: id, LeaseId: leaseID}, callOpts...)
+		return err
+	})
+	return leaseError(id, err)
+}
+
+// Nack returns the object id leased with leaseID to its queue, to be
+// delivered again after delay.
+func (c *Client) Nack(ctx context.Context, id, leaseID string, delay time.Duration, opts ...CallOption) error {
+	err := c.unary(ctx, opts, func(ctx context.Context, callOpts ...grpc.CallOption) error {
+		_, err := c.api.Nack(ctx, &proto.NackRequest{SocketId: id, LeaseId: leaseID, DelayMs: delay.Milliseconds()}, callOpts...)
+		return err
+	})
+	return leaseError(id, err)
+}
+
+func leaseError(id string, err error) error {
+	if status.Code(err) == codes.FailedPrecondition {
+		return fmt.Errorf("%w: %s", ErrLeaseLost, id)
+	}
+	return objectError(id, err)
+}
+
+type deliveryStream = grpc.ServerStreamingClient[proto.Delivery]
+
+// Consumer receives the objects of a queue. Consumers on any number of
+// clients and servers share the queue: each object is leased to one of
+// them at a time. A broken stream is reopened; the server then hands the
+// unacked objects of the old one to any consumer again. It is not safe for
+// concurrent use and holds a concurrency slot until it is closed.
+type Consumer struct {
+	c       *Client
+	ctx     context.Context
+	stop    context.CancelFunc
+	req     *proto.ConsumeRequest
+	policy  RetryPolicy
+	release func()
+
+	stream deliveryStream
+	cancel context.CancelFunc
+}
+
+// Consume starts receiving the objects of queue. ctx bounds the whole
+// consumer. The stream opens on the first Next, which also returns any
+// error in the request, such as a bad queue name.
+func (c *Client) Consume(ctx context.Context, queue string, opts ...CallOption) (*Consumer, error) {
+	release, err := c.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	o := c.callOptions(opts)
+
+	cons := &Consumer{
+		c: c,
+		req: &proto.ConsumeRequest{
+			Queue:               queue,
+			VisibilityTimeoutMs: o.visibility.Milliseconds(),
+			Prefetch:            int32(o.prefetch),
+		},
+		policy:  o.retry,
+		release: release,
+	}
+	cons.ctx, cons.stop = context.WithCancel(ctx)
+	return cons, nil
+}
+
+// Next waits for the next delivery.
+func (cons *Consumer) Next() (*Delivery, error) {
+	var d *proto.Delivery
+	err := retry(cons.ctx, cons.policy, func() error {
+		if cons.stream == nil {
+			ctx, cancel := context.WithCancel(cons.ctx)
+			stream, err := cons.c.api.Consume(ctx, cons.req)
+			if err != nil {
+				cancel()
+				return err
+			}
+			cons.stream, cons.cancel = stream, cancel
+		}
+		var err error
+		if d, err = cons.stream.Recv(); err != nil {
+			if err == io.EOF {
+				err = status.Error(codes.Unavailable, "consumer ended by the server")
+			}
+			cons.reset()
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Delivery{
+		Object:         newObject(d.GetObject()),
+		Data:           d.GetData(),
+		LeaseID:        d.GetLeaseId(),
+		Attempt:        int(d.GetAttempt()),
+		LeaseExpiresAt: time.Unix(0, d.GetLeaseExpiresAt()).UTC(),
+		c:              cons.c,
+	}, nil
+}
+
+func (cons *Consumer) reset() {
+	if cons.cancel != nil {
+		cons.cancel()
+	}
+	cons.stream, cons.cancel = nil, nil
+}
+
+// Close ends the consumer. The server returns its unacked objects to the
+// queue.
+func (cons *Consumer) Close() error {
+	cons.reset()
+	cons.stop()
+	if cons.release != nil {
+		cons.release()
+		cons.release = nil
+	}
+	return nil
+}
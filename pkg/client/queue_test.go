@@ -0,0 +1,63 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/NikoMalik/potoc/internal/servertest"
+	"github.com/NikoMalik/potoc/pkg/client"
+	"github.com/NikoMalik/uuid"
+)
+
+func TestConsume(t *testing.T) {
+	s := servertest.Start(t)
+	c := s.Client
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	queue := "test-" + uuid.New().String()
+	id, err := c.Put(ctx, strings.NewReader("job"), client.WithQueue(queue))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if obj, err := c.Stat(ctx, id); err != nil || obj.Queue != queue {
+		t.Fatalf("Stat: %+v, %v", obj, err)
+	}
+
+	cons, err := c.Consume(ctx, queue, client.WithPrefetch(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cons.Close()
+
+	d, err := cons.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Object.ID != id || string(d.Data) != "job" || d.Attempt != 1 || !d.LeaseExpiresAt.After(time.Now()) {
+		t.Fatalf("first delivery: %+v", d)
+	}
+	if err := d.Nack(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Ack(ctx); !errors.Is(err, client.ErrLeaseLost) {
+		t.Fatalf("Ack after Nack: got %v, want ErrLeaseLost", err)
+	}
+
+	d, err = cons.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Object.ID != id || d.Attempt != 2 {
+		t.Fatalf("second delivery: %+v", d)
+	}
+	if err := d.Ack(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Ack(ctx); !errors.Is(err, client.ErrNotFound) {
+		t.Fatalf("second Ack: got %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,186 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/NikoMalik/potoc/pkg/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// RetryPolicy decides whether and when a failed call is tried again.
+type RetryPolicy struct {
+	// MaxAttempts counts the first attempt too; 1 disables retries.
+	MaxAttempts int
+	// The wait before retry n is InitialBackoff * Multiplier^(n-1), capped
+	// at MaxBackoff and moved randomly by up to Jitter of itself either
+	// way so that clients cut off together do not retry together.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+	Jitter         float64
+	// PerAttemptTimeout bounds each attempt of a stream round trip. When
+	// it fires the attempt fails with DeadlineExceeded and may be retried.
+	// Zero leaves attempts bounded by the call context only.
+	PerAttemptTimeout time.Duration
+	// RetryableCodes are the status codes worth another attempt.
+	RetryableCodes []codes.Code
+}
+
+// DefaultRetryPolicy rides out a server restart of a few seconds.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    5,
+	InitialBackoff: 100 * time.Millisecond,
+	MaxBackoff:     5 * time.Second,
+	Multiplier:     2,
+	Jitter:         0.2,
+	RetryableCodes: []codes.Code{codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted},
+}
+
+// NoRetry makes a single attempt.
+var NoRetry = RetryPolicy{MaxAttempts: 1}
+
+// CallOption configures a single call.
+type CallOption func(*callOptions)
+
+type callOptions struct {
+	retry       RetryPolicy
+	ownRetry    bool
+	labels      map[string]string
+	labelFilter map[string]string
+	transaction bool
+	kinds       []EventKind
+	resumeAfter *int64
+	queue       string
+	visibility  time.Duration
+	prefetch    int
+}
+
+// WithCallRetry overrides the client's retry policy for one call.
+func WithCallRetry(p RetryPolicy) CallOption {
+	return func(o *callOptions) { o.retry, o.ownRetry = p, true }
+}
+
+// WithLabels attaches labels to the objects stored by Put or an
+// UploadStream.
+func WithLabels(labels map[string]string) CallOption {
+	return func(o *callOptions) { o.labels = labels }
+}
+
+// WithLabelFilter makes List return only objects carrying all of labels,
+// and Subscribe only their events.
+func WithLabelFilter(labels map[string]string) CallOption {
+	return func(o *callOptions) { o.labelFilter = labels }
+}
+
+func (c *Client) callOptions(opts []CallOption) callOptions {
+	o := callOptions{retry: c.opts.retry}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// retryable reports whether err is worth another attempt. A deadline is
+// only retried when it was the attempt's and not the caller's.
+func (p RetryPolicy) retryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	code := status.Code(err)
+	for _, c := range p.RetryableCodes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff is the wait before the given retry, counting from 1.
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	d := float64(p.InitialBackoff) * math.Pow(max(p.Multiplier, 1), float64(retry-1))
+	if p.MaxBackoff > 0 {
+		d = min(d, float64(p.MaxBackoff))
+	}
+	if p.Jitter > 0 {
+		d += d * p.Jitter * (2*rand.Float64() - 1)
+	}
+	return time.Duration(d)
+}
+
+// retry calls fn until it succeeds, fails for good or runs out of attempts,
+// and returns the last error.
+func retry(ctx context.Context, p RetryPolicy, fn func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= p.MaxAttempts || !p.retryable(ctx, err) {
+			return err
+		}
+
+		t := time.NewTimer(p.backoff(attempt))
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		}
+	}
+}
+
+// serviceConfig returns a gRPC service config applying p to every unary
+// method, so that gRPC itself retries them below the client.
+func serviceConfig(p RetryPolicy) string {
+	codes := make([]string, len(p.RetryableCodes))
+	for i, c := range p.RetryableCodes {
+		codes[i] = upperSnake(c.String())
+	}
+
+	desc := proto.DataTranfer_ServiceDesc
+	unary := make([]map[string]string, len(desc.Methods))
+	for i, m := range desc.Methods {
+		unary[i] = map[string]string{"service": desc.ServiceName, "method": m.MethodName}
+	}
+	cfg := map[string]any{
+		"methodConfig": []any{map[string]any{
+			"name": unary,
+			"retryPolicy": map[string]any{
+				// gRPC caps attempts at 5 and requires at least 2.
+				"maxAttempts":          min(max(p.MaxAttempts, 2), 5),
+				"initialBackoff":       seconds(p.InitialBackoff),
+				"maxBackoff":           seconds(max(p.MaxBackoff, p.InitialBackoff)),
+				"backoffMultiplier":    max(p.Multiplier, 1),
+				"retryableStatusCodes": codes,
+			},
+		}},
+	}
+
+	b, err := json.Marshal(cfg)
+	if err != nil {
+		panic(err)
+	}
+	return string(b)
+}
+
+func seconds(d time.Duration) string {
+	return fmt.Sprintf("%.3fs", max(d, time.Millisecond).Seconds())
+}
+
+// upperSnake turns a Go status code name like DeadlineExceeded into the
+// service config form DEADLINE_EXCEEDED.
+func upperSnake(s string) string {
+	var b strings.Builder
+	for i, r := range s {
+		if i > 0 && unicode.IsUpper(r) {
+			b.WriteByte('_')
+		}
+		b.WriteRune(unicode.ToUpper(r))
+	}
+	return b.String()
+}
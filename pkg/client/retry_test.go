@@ -0,0 +1,174 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/NikoMalik/potoc/pkg/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestBackoff(t *testing.T) {
+	p := RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second, Multiplier: 2}
+	tests := []struct {
+		retry int
+		want  time.Duration
+	}{
+		{1, 100 * time.Millisecond},
+		{2, 200 * time.Millisecond},
+		{4, 800 * time.Millisecond},
+		{5, time.Second},
+		{10, time.Second},
+	}
+	for _, tt := range tests {
+		if got := p.backoff(tt.retry); got != tt.want {
+			t.Errorf("backoff(%d) = %v, want %v", tt.retry, got, tt.want)
+		}
+	}
+
+	p.Jitter = 0.5
+	for range 100 {
+		if got := p.backoff(1); got < 50*time.Millisecond || got > 150*time.Millisecond {
+			t.Fatalf("backoff(1) with jitter = %v, want within 50ms of 100ms", got)
+		}
+	}
+}
+
+func TestRetry(t *testing.T) {
+	p := RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, RetryableCodes: []codes.Code{codes.Unavailable}}
+	tests := []struct {
+		name         string
+		errs         []error
+		wantAttempts int
+		wantCode     codes.Code
+	}{
+		{"success", []error{nil}, 1, codes.OK},
+		{"retried until success", []error{status.Error(codes.Unavailable, ""), nil}, 2, codes.OK},
+		{"attempts run out", []error{status.Error(codes.Unavailable, ""), status.Error(codes.Unavailable, ""), status.Error(codes.Unavailable, "")}, 3, codes.Unavailable},
+		{"not retryable", []error{status.Error(codes.NotFound, "")}, 1, codes.NotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			err := retry(context.Background(), p, func() error {
+				attempts++
+				return tt.errs[attempts-1]
+			})
+			if attempts != tt.wantAttempts || status.Code(err) != tt.wantCode {
+				t.Fatalf("got %d attempts and %v, want %d and %v", attempts, err, tt.wantAttempts, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestServiceConfig(t *testing.T) {
+	var cfg struct {
+		MethodConfig []struct {
+			Name []struct {
+				Method string
+			}
+			RetryPolicy struct {
+				MaxAttempts          int
+				RetryableStatusCodes []string
+			}
+		}
+	}
+	if err := json.Unmarshal([]byte(serviceConfig(DefaultRetryPolicy)), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if n, want := len(cfg.MethodConfig[0].Name), len(proto.DataTranfer_ServiceDesc.Methods); n != want {
+		t.Errorf("service config names %d methods, want all %d unary ones", n, want)
+	}
+	rp := cfg.MethodConfig[0].RetryPolicy
+	if rp.MaxAttempts != 5 {
+		t.Errorf("maxAttempts = %d, want 5", rp.MaxAttempts)
+	}
+	want := []string{"UNAVAILABLE", "DEADLINE_EXCEEDED", "RESOURCE_EXHAUSTED"}
+	if len(rp.RetryableStatusCodes) != len(want) {
+		t.Fatalf("codes = %v, want %v", rp.RetryableStatusCodes, want)
+	}
+	for i := range want {
+		if rp.RetryableStatusCodes[i] != want[i] {
+			t.Errorf("codes = %v, want %v", rp.RetryableStatusCodes, want)
+		}
+	}
+}
+
+// unavailableServer starts a server failing every unary call with
+// Unavailable and returns a dialer for it and the number of calls it got.
+func unavailableServer(t *testing.T) (grpc.DialOption, *atomic.Int32) {
+	var calls atomic.Int32
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer(grpc.UnaryInterceptor(func(context.Context, any, *grpc.UnaryServerInfo, grpc.UnaryHandler) (any, error) {
+		calls.Add(1)
+		return nil, status.Error(codes.Unavailable, "down")
+	}))
+	proto.RegisterDataTranferServer(srv, proto.UnimplementedDataTranferServer{})
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	return grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+		return lis.DialContext(ctx)
+	}), &calls
+}
+
+func TestUnaryRetryLayers(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, RetryableCodes: []codes.Code{codes.Unavailable}}
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		service bool
+		call    func(c *Client) error
+		want    int32
+	}{
+		{"stat", true, func(c *Client) error { _, err := c.Stat(ctx, "id"); return err }, 3},
+		{"list with a filter", true, func(c *Client) error {
+			_, err := c.List(ctx, WithLabelFilter(map[string]string{"k": "v"}))
+			return err
+		}, 3},
+		{"ack", true, func(c *Client) error { return c.Ack(ctx, "id", "lease") }, 3},
+		{"dead letters", true, func(c *Client) error { _, err := c.RetryDeadLetters(ctx, []int64{1}); return err }, 3},
+		{"per call policy", true, func(c *Client) error {
+			return c.Delete(ctx, "id", WithCallRetry(RetryPolicy{MaxAttempts: 2, RetryableCodes: []codes.Code{codes.Unavailable}}))
+		}, 2},
+		{"per call no retry", true, func(c *Client) error { return c.Nack(ctx, "id", "lease", 0, WithCallRetry(NoRetry)) }, 1},
+		{"without service config", false, func(c *Client) error { _, err := c.ServerInfo(ctx); return err }, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dialer, calls := unavailableServer(t)
+			var c *Client
+			if tt.service {
+				var err error
+				c, err = New("passthrough:///bufnet", WithRetryPolicy(policy), WithDialOptions(dialer))
+				if err != nil {
+					t.Fatal(err)
+				}
+				t.Cleanup(func() { c.Close() })
+			} else {
+				conn, err := grpc.NewClient("passthrough:///bufnet", dialer, grpc.WithTransportCredentials(insecure.NewCredentials()))
+				if err != nil {
+					t.Fatal(err)
+				}
+				t.Cleanup(func() { conn.Close() })
+				c = NewFromConn(conn, WithRetryPolicy(policy))
+			}
+
+			if err := tt.call(c); status.Code(err) != codes.Unavailable {
+				t.Fatalf("got %v, want Unavailable", err)
+			}
+			if got := calls.Load(); got != tt.want {
+				t.Errorf("server got %d attempts, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,331 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/NikoMalik/potoc/pkg/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type dataStream = grpc.BidiStreamingClient[proto.DataRequest, proto.DataResponse]
+
+// session is a bidi stream that outlives failures: when a round trip fails
+// with a retryable error the stream is reopened and the unacknowledged
+// request sent again. A request may therefore reach the server twice if
+// its response was lost.
+//
+// A pinned session keeps to one stream instead: it makes a single attempt
+// per round trip and stays on the stream when the server drains.
+type session struct {
+	ctx     context.Context
+	open    func(context.Context, ...grpc.CallOption) (dataStream, error)
+	policy  RetryPolicy
+	pinned  bool
+	release func()
+
+	stream dataStream
+	cancel context.CancelFunc
+}
+
+func (c *Client) newSession(ctx context.Context, open func(context.Context, ...grpc.CallOption) (dataStream, error), opts []CallOption) (*session, error) {
+	release, err := c.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &session{
+		ctx:     ctx,
+		open:    open,
+		policy:  c.callOptions(opts).retry,
+		release: release,
+	}, nil
+}
+
+// roundTrip sends req and returns the server's response to it.
+func (s *session) roundTrip(req *proto.DataRequest) (*proto.DataResponse, error) {
+	var resp *proto.DataResponse
+	policy := s.policy
+	if s.pinned {
+		policy.MaxAttempts = 1
+	}
+	err := retry(s.ctx, policy, func() error {
+		if s.stream == nil {
+			ctx, cancel := context.WithCancel(s.ctx)
+			stream, err := s.open(ctx)
+			if err != nil {
+				cancel()
+				return err
+			}
+			s.stream, s.cancel = stream, cancel
+		}
+
+		var timedOut atomic.Bool
+		if s.policy.PerAttemptTimeout > 0 {
+			cancel := s.cancel
+			t := time.AfterFunc(s.policy.PerAttemptTimeout, func() {
+				timedOut.Store(true)
+				cancel()
+			})
+			defer t.Stop()
+		}
+
+		var (
+			draining bool
+			err      error
+		)
+		if err = s.stream.Send(req); err != nil {
+			err = recvError(s.stream)
+		} else {
+			resp, draining, err = recv(s.stream)
+		}
+		if timedOut.Load() {
+			err = status.Error(codes.DeadlineExceeded, "attempt timed out")
+		}
+		if err != nil {
+			s.reset()
+			return err
+		}
+
+		// The server is going away: finish this stream and let the next
+		// round trip open one elsewhere.
+		if draining && !s.pinned {
+			s.finish()
+		}
+		return nil
+	})
+	return resp, err
+}
+
+// finish ends the stream cleanly, waiting for the server to close it.
+func (s *session) finish() error {
+	if s.stream == nil {
+		return nil
+	}
+	err := closeStream(s.stream)
+	s.reset()
+	return err
+}
+
+func (s *session) reset() {
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.stream, s.cancel = nil, nil
+}
+
+// close finishes the stream and frees the concurrency slot.
+func (s *session) close() error {
+	err := s.finish()
+	if s.release != nil {
+		s.release()
+		s.release = nil
+	}
+	return err
+}
+
+// UploadStream stores many objects over one stream, which is cheaper than
+// a Put per object. It is not safe for concurrent use; open one per
+// goroutine. Each stream holds a concurrency slot until it is closed.
+type UploadStream struct {
+	s      *session
+	labels map[string]string
+	queue  string
+
+	// A transactional stream has begun once the server accepted its
+	// first object; err then sticks after any failure.
+	tx        bool
+	begun     bool
+	committed bool
+	err       error
+}
+
+// ErrCommitted is returned by the Put and Commit methods of a
+// transactional UploadStream that has already been committed.
+var ErrCommitted = errors.New("potoc: upload stream already committed")
+
+// NewUploadStream opens an upload stream. ctx bounds its whole life.
+func (c *Client) NewUploadStream(ctx context.Context, opts ...CallOption) (*UploadStream, error) {
+	s, err := c.newSession(ctx, c.api.GetData, opts)
+	if err != nil {
+		return nil, err
+	}
+	o := c.callOptions(opts)
+	s.pinned = o.transaction
+	return &UploadStream{s: s, labels: o.labels, queue: o.queue, tx: o.transaction}, nil
+}
+
+// WithTransaction makes an UploadStream store its objects in one
+// transaction: none is visible until Commit, and all are discarded if the
+// stream is closed or fails before it. Round trips of such a stream are not
+// retried, since a new stream could not continue the transaction. The
+// server limits the size of a transaction and rolls it back when the
+// stream stays idle or open for too long.
+func WithTransaction() CallOption {
+	return func(o *callOptions) { o.transaction = true }
+}
+
+// Put stores everything read from r with the stream's labels, in its queue
+// if it has one, and returns the new object's id.
+func (u *UploadStream) Put(r io.Reader) (string, error) {
+	return u.PutWithLabels(r, u.labels)
+}
+
+// PutWithLabels is Put with labels for this object only.
+func (u *UploadStream) PutWithLabels(r io.Reader, labels map[string]string) (string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	encoded := make([]byte, base64.StdEncoding.EncodedLen(len(data)))
+	base64.StdEncoding.Encode(encoded, data)
+
+	req := &proto.DataRequest{EncodedData: encoded, Labels: labels, Queue: u.queue}
+	if u.tx {
+		if err := u.txErr(); err != nil {
+			return "", err
+		}
+		req.Transaction = !u.begun
+	}
+	resp, err := u.s.roundTrip(req)
+	if u.tx {
+		u.begun = u.begun || err == nil
+		if err != nil && u.begun {
+			u.err = err
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	return string(resp.GetData()), nil
+}
+
+// Commit makes the objects of a transactional stream visible and returns
+// how many there are. The stream must be closed after it.
+func (u *UploadStream) Commit() (int, error) {
+	if !u.tx {
+		return 0, errors.New("potoc: upload stream is not transactional, see WithTransaction")
+	}
+	if err := u.txErr(); err != nil {
+		return 0, err
+	}
+	if !u.begun {
+		u.committed = true
+		return 0, nil
+	}
+
+	resp, err := u.s.roundTrip(&proto.DataRequest{Commit: true})
+	if err != nil {
+		u.err = err
+		return 0, err
+	}
+	u.committed = true
+	n, err := strconv.Atoi(string(resp.GetData()))
+	if err != nil {
+		return 0, fmt.Errorf("potoc: bad commit response %q", resp.GetData())
+	}
+	return n, nil
+}
+
+// txErr returns why a transactional stream can take no more requests.
+func (u *UploadStream) txErr() error {
+	if u.committed {
+		return ErrCommitted
+	}
+	return u.err
+}
+
+// Close ends the stream. A transactional stream closed before Commit is
+// rolled back and Close then reports codes.Aborted.
+func (u *UploadStream) Close() error {
+	return u.s.close()
+}
+
+// DownloadStream fetches many objects over one stream. Like UploadStream
+// it is not safe for concurrent use.
+type DownloadStream struct {
+	s *session
+}
+
+// NewDownloadStream opens a download stream. ctx bounds its whole life.
+func (c *Client) NewDownloadStream(ctx context.Context, opts ...CallOption) (*DownloadStream, error) {
+	s, err := c.newSession(ctx, c.api.FetchData, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &DownloadStream{s: s}, nil
+}
+
+// Get returns the object with the given id, read into memory.
+func (d *DownloadStream) Get(id string) (io.ReadCloser, error) {
+	resp, err := d.s.roundTrip(&proto.DataRequest{SocketId: id})
+	if err != nil {
+		// The server ends the stream on a failed fetch.
+		return nil, objectError(id, err)
+	}
+	return io.NopCloser(base64.NewDecoder(base64.StdEncoding, bytes.NewReader(resp.GetData()))), nil
+}
+
+// Close ends the stream.
+func (d *DownloadStream) Close() error {
+	return d.s.close()
+}
+
+// recv returns the next response and whether the server announced it is
+// draining while waiting for it.
+func recv(stream dataStream) (_ *proto.DataResponse, draining bool, _ error) {
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			return nil, draining, status.Error(codes.Unavailable, "stream closed before the response")
+		}
+		if err != nil {
+			return nil, draining, err
+		}
+		switch resp.GetStatus() {
+		case statusDraining:
+			draining = true
+		case statusOK:
+			return resp, draining, nil
+		default:
+			return nil, draining, status.Errorf(codes.Internal, "unexpected status %q: %s", resp.GetStatus(), resp.GetMsg())
+		}
+	}
+}
+
+// recvError returns the status that made Send fail. gRPC reports it on
+// Recv, Send itself only returns io.EOF.
+func recvError(stream dataStream) error {
+	for {
+		if _, err := stream.Recv(); err != nil {
+			if err == io.EOF {
+				return status.Error(codes.Unavailable, "stream closed by the server")
+			}
+			return err
+		}
+	}
+}
+
+// closeStream half-closes the stream and waits for the server to end it.
+func closeStream(stream dataStream) error {
+	if err := stream.CloseSend(); err != nil {
+		return err
+	}
+	for {
+		_, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package client
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/NikoMalik/potoc/pkg/proto"
+	"google.golang.org/grpc"
+)
+
+// WebhookSignatureHeader carries the signature of a webhook post as
+// "t=<unix time>,v1=<hex HMAC-SHA256 of "<unix time>.<body>">".
+const WebhookSignatureHeader = "Potoc-Signature"
+
+// maxWebhookBody bounds the webhook bodies VerifyWebhook reads.
+const maxWebhookBody = 1 << 20
+
+// ErrWebhookSignature is returned, wrapped, by VerifyWebhook when a post is
+// not signed with the secret or the signature is too old.
+var ErrWebhookSignature = errors.New("potoc: invalid webhook signature")
+
+// WebhookEvent is the body of a webhook post. ID identifies the delivery
+// and is the same on every attempt, so a receiver can skip repeats.
+type WebhookEvent struct {
+	ID int64 `json:"id"`
+	Event
+}
+
+// VerifyWebhook reads a webhook post, checks that it is signed with secret
+// no longer than tolerance ago and returns its event. A zero tolerance
+// accepts any signing time.
+func VerifyWebhook(r *http.Request, secret string, tolerance time.Duration) (*WebhookEvent, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookBody))
+	if err != nil {
+		return nil, err
+	}
+
+	var ts, sig string
+	for _, part := range strings.Split(r.Header.Get(WebhookSignatureHeader), ",") {
+		k, v, _ := strings.Cut(part, "=")
+		switch k {
+		case "t":
+			ts = v
+		case "v1":
+			sig = v
+		}
+	}
+	signed, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil || sig == "" {
+		return nil, fmt.Errorf("%w: malformed %s header", ErrWebhookSignature, WebhookSignatureHeader)
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts + "."))
+	mac.Write(body)
+	if got, err := hex.DecodeString(sig); err != nil || !hmac.Equal(got, mac.Sum(nil)) {
+		return nil, fmt.Errorf("%w: signature mismatch", ErrWebhookSignature)
+	}
+	if age := time.Since(time.Unix(signed, 0)); tolerance > 0 && (age > tolerance || age < -tolerance) {
+		return nil, fmt.Errorf("%w: signed %s ago", ErrWebhookSignature, age.Round(time.Second))
+	}
+
+	var ev WebhookEvent
+	if err := json.Unmarshal(body, &ev); err != nil {
+		return nil, fmt.Errorf("potoc: decode webhook: %w", err)
+	}
+	return &ev, nil
+}
+
+// DeadLetter is a webhook delivery the server gave up on.
+type DeadLetter struct {
+	ID        int64     `json:"id"`
+	Endpoint  string    `json:"endpoint"`
+	Event     Event     `json:"event"`
+	Attempts  int       `json:"attempts"`
+	LastError string    `json:"last_error"`
+	DeadAt    time.Time `json:"dead_at"`
+}
+
+// DeadLetters returns the dead letters of the webhook endpoint with the
+// given name, or of all endpoints when it is empty, ordered by id.
+func (c *Client) DeadLetters(ctx context.Context, endpoint string, opts ...CallOption) ([]DeadLetter, error) {
+	var (
+		all   []DeadLetter
+		token string
+	)
+	for {
+		var resp *proto.ListDeadLettersResponse
+		err := c.unary(ctx, opts, func(ctx context.Context, callOpts ...grpc.CallOption) (err error) {
+			resp, err = c.api.ListDeadLetters(ctx, &proto.ListDeadLettersRequest{
+				Endpoint:  endpoint,
+				PageSize:  listPageSize,
+				PageToken: token,
+			}, callOpts...)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, l := range resp.GetDeadLetters() {
+			all = append(all, DeadLetter{
+				ID:        l.GetId(),
+				Endpoint:  l.GetEndpoint(),
+				Event:     newEvent(l.GetEvent()),
+				Attempts:  int(l.GetAttempts()),
+				LastError: l.GetLastError(),
+				DeadAt:    time.Unix(0, l.GetDeadAt()).UTC(),
+			})
+		}
+		if token = resp.GetNextPageToken(); token == "" {
+			return all, nil
+		}
+	}
+}
+
+// RetryDeadLetters queues the dead letters with the given ids for delivery
+// again and returns how many of them it found.
+func (c *Client) RetryDeadLetters(ctx context.Context, ids []int64, opts ...CallOption) (int, error) {
+	var resp *proto.RetryDeadLettersResponse
+	err := c.unary(ctx, opts, func(ctx context.Context, callOpts ...grpc.CallOption) (err error) {
+		resp, err = c.api.RetryDeadLetters(ctx, &proto.RetryDeadLettersRequest{Ids: ids}, callOpts...)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return int(resp.GetRetried()), nil
+}
@@ -0,0 +1,79 @@
+package client_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/NikoMalik/potoc/internal/config"
+	"github.com/NikoMalik/potoc/internal/servertest"
+	"github.com/NikoMalik/potoc/pkg/client"
+	"github.com/NikoMalik/uuid"
+)
+
+func TestVerifyWebhook(t *testing.T) {
+	type captured struct {
+		header http.Header
+		body   []byte
+	}
+	posts := make(chan captured, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		posts <- captured{header: r.Header.Clone(), body: body}
+	}))
+	defer srv.Close()
+
+	labels := map[string]string{"test-run": uuid.New().String()}
+	s := servertest.Start(t, servertest.WithWebhooks(&config.Webhooks{
+		Endpoints: []config.WebhookEndpoint{{
+			Name:   "test-" + labels["test-run"],
+			URL:    srv.URL,
+			Secret: "secret",
+			Labels: []string{"test-run=" + labels["test-run"]},
+		}},
+		Workers:      1,
+		PollInterval: 20 * time.Millisecond,
+		Timeout:      2 * time.Second,
+		MaxAttempts:  3,
+		MinBackoff:   10 * time.Millisecond,
+		MaxBackoff:   50 * time.Millisecond,
+	}))
+	id, err := s.Client.Put(context.Background(), strings.NewReader("hello"), client.WithLabels(labels))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var post captured
+	select {
+	case post = <-posts:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no webhook post")
+	}
+	verify := func(secret string, body []byte) (*client.WebhookEvent, error) {
+		r := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+		r.Header = post.header
+		return client.VerifyWebhook(r, secret, time.Minute)
+	}
+
+	ev, err := verify("secret", post.body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ev.ID == 0 || ev.Kind != client.EventCreated || ev.Object.ID != id || ev.Object.Size != 5 {
+		t.Fatalf("got event %+v", ev)
+	}
+
+	if _, err := verify("other", post.body); !errors.Is(err, client.ErrWebhookSignature) {
+		t.Errorf("wrong secret: got %v", err)
+	}
+	tampered := bytes.Replace(post.body, []byte(`"size":5`), []byte(`"size":6`), 1)
+	if _, err := verify("secret", tampered); !errors.Is(err, client.ErrWebhookSignature) {
+		t.Errorf("tampered body: got %v", err)
+	}
+}
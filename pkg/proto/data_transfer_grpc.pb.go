@@ -19,8 +19,18 @@ import (
 const _ = grpc.SupportPackageIsVersion9
 
 const (
-	DataTranfer_GetData_FullMethodName   = "/DataTranfer/GetData"
-	DataTranfer_FetchData_FullMethodName = "/DataTranfer/FetchData"
+	DataTranfer_GetData_FullMethodName          = "/DataTranfer/GetData"
+	DataTranfer_FetchData_FullMethodName        = "/DataTranfer/FetchData"
+	DataTranfer_GetServerInfo_FullMethodName    = "/DataTranfer/GetServerInfo"
+	DataTranfer_Delete_FullMethodName           = "/DataTranfer/Delete"
+	DataTranfer_List_FullMethodName             = "/DataTranfer/List"
+	DataTranfer_Stat_FullMethodName             = "/DataTranfer/Stat"
+	DataTranfer_Subscribe_FullMethodName        = "/DataTranfer/Subscribe"
+	DataTranfer_Consume_FullMethodName          = "/DataTranfer/Consume"
+	DataTranfer_Ack_FullMethodName              = "/DataTranfer/Ack"
+	DataTranfer_Nack_FullMethodName             = "/DataTranfer/Nack"
+	DataTranfer_ListDeadLetters_FullMethodName  = "/DataTranfer/ListDeadLetters"
+	DataTranfer_RetryDeadLetters_FullMethodName = "/DataTranfer/RetryDeadLetters"
 )
 
 // DataTranferClient is the client API for DataTranfer service.
@@ -30,6 +40,27 @@ type DataTranferClient interface {
 	// for get base64 from anyone and decode it to db and save
 	GetData(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[DataRequest, DataResponse], error)
 	FetchData(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[DataRequest, DataResponse], error)
+	// describes the running server so clients can adapt to it
+	GetServerInfo(ctx context.Context, in *ServerInfoRequest, opts ...grpc.CallOption) (*ServerInfo, error)
+	// removes a stored object, NOT_FOUND if there is none with that id
+	Delete(ctx context.Context, in *DeleteRequest, opts ...grpc.CallOption) (*DeleteResponse, error)
+	// pages through stored objects ordered by id
+	List(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (*ListResponse, error)
+	// describes a stored object without fetching it
+	Stat(ctx context.Context, in *StatRequest, opts ...grpc.CallOption) (*ObjectInfo, error)
+	// pushes changes to stored objects as they are committed
+	Subscribe(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ObjectEvent], error)
+	// streams the objects of a queue, each leased to this consumer until
+	// acked, nacked or the visibility timeout passes
+	Consume(ctx context.Context, in *ConsumeRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Delivery], error)
+	// deletes a delivered object, FAILED_PRECONDITION once its lease is lost
+	Ack(ctx context.Context, in *AckRequest, opts ...grpc.CallOption) (*AckResponse, error)
+	// returns a delivered object to its queue
+	Nack(ctx context.Context, in *NackRequest, opts ...grpc.CallOption) (*NackResponse, error)
+	// pages through the webhook deliveries that ran out of attempts
+	ListDeadLetters(ctx context.Context, in *ListDeadLettersRequest, opts ...grpc.CallOption) (*ListDeadLettersResponse, error)
+	// queues dead letters for delivery again with their attempts reset
+	RetryDeadLetters(ctx context.Context, in *RetryDeadLettersRequest, opts ...grpc.CallOption) (*RetryDeadLettersResponse, error)
 }
 
 type dataTranferClient struct {
@@ -66,6 +97,124 @@ func (c *dataTranferClient) FetchData(ctx context.Context, opts ...grpc.CallOpti
 // This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
 type DataTranfer_FetchDataClient = grpc.BidiStreamingClient[DataRequest, DataResponse]
 
+func (c *dataTranferClient) GetServerInfo(ctx context.Context, in *ServerInfoRequest, opts ...grpc.CallOption) (*ServerInfo, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ServerInfo)
+	err := c.cc.Invoke(ctx, DataTranfer_GetServerInfo_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *dataTranferClient) Delete(ctx context.Context, in *DeleteRequest, opts ...grpc.CallOption) (*DeleteResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DeleteResponse)
+	err := c.cc.Invoke(ctx, DataTranfer_Delete_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *dataTranferClient) List(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (*ListResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListResponse)
+	err := c.cc.Invoke(ctx, DataTranfer_List_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *dataTranferClient) Stat(ctx context.Context, in *StatRequest, opts ...grpc.CallOption) (*ObjectInfo, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ObjectInfo)
+	err := c.cc.Invoke(ctx, DataTranfer_Stat_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *dataTranferClient) Subscribe(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ObjectEvent], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &DataTranfer_ServiceDesc.Streams[2], DataTranfer_Subscribe_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[SubscribeRequest, ObjectEvent]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type DataTranfer_SubscribeClient = grpc.ServerStreamingClient[ObjectEvent]
+
+func (c *dataTranferClient) Consume(ctx context.Context, in *ConsumeRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Delivery], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &DataTranfer_ServiceDesc.Streams[3], DataTranfer_Consume_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[ConsumeRequest, Delivery]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type DataTranfer_ConsumeClient = grpc.ServerStreamingClient[Delivery]
+
+func (c *dataTranferClient) Ack(ctx context.Context, in *AckRequest, opts ...grpc.CallOption) (*AckResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(AckResponse)
+	err := c.cc.Invoke(ctx, DataTranfer_Ack_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *dataTranferClient) Nack(ctx context.Context, in *NackRequest, opts ...grpc.CallOption) (*NackResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(NackResponse)
+	err := c.cc.Invoke(ctx, DataTranfer_Nack_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *dataTranferClient) ListDeadLetters(ctx context.Context, in *ListDeadLettersRequest, opts ...grpc.CallOption) (*ListDeadLettersResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListDeadLettersResponse)
+	err := c.cc.Invoke(ctx, DataTranfer_ListDeadLetters_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *dataTranferClient) RetryDeadLetters(ctx context.Context, in *RetryDeadLettersRequest, opts ...grpc.CallOption) (*RetryDeadLettersResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(RetryDeadLettersResponse)
+	err := c.cc.Invoke(ctx, DataTranfer_RetryDeadLetters_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // DataTranferServer is the server API for DataTranfer service.
 // All implementations must embed UnimplementedDataTranferServer
 // for forward compatibility.
@@ -73,6 +222,27 @@ type DataTranferServer interface {
 	// for get base64 from anyone and decode it to db and save
 	GetData(grpc.BidiStreamingServer[DataRequest, DataResponse]) error
 	FetchData(grpc.BidiStreamingServer[DataRequest, DataResponse]) error
+	// describes the running server so clients can adapt to it
+	GetServerInfo(context.Context, *ServerInfoRequest) (*ServerInfo, error)
+	// removes a stored object, NOT_FOUND if there is none with that id
+	Delete(context.Context, *DeleteRequest) (*DeleteResponse, error)
+	// pages through stored objects ordered by id
+	List(context.Context, *ListRequest) (*ListResponse, error)
+	// describes a stored object without fetching it
+	Stat(context.Context, *StatRequest) (*ObjectInfo, error)
+	// pushes changes to stored objects as they are committed
+	Subscribe(*SubscribeRequest, grpc.ServerStreamingServer[ObjectEvent]) error
+	// streams the objects of a queue, each leased to this consumer until
+	// acked, nacked or the visibility timeout passes
+	Consume(*ConsumeRequest, grpc.ServerStreamingServer[Delivery]) error
+	// deletes a delivered object, FAILED_PRECONDITION once its lease is lost
+	Ack(context.Context, *AckRequest) (*AckResponse, error)
+	// returns a delivered object to its queue
+	Nack(context.Context, *NackRequest) (*NackResponse, error)
+	// pages through the webhook deliveries that ran out of attempts
+	ListDeadLetters(context.Context, *ListDeadLettersRequest) (*ListDeadLettersResponse, error)
+	// queues dead letters for delivery again with their attempts reset
+	RetryDeadLetters(context.Context, *RetryDeadLettersRequest) (*RetryDeadLettersResponse, error)
 	mustEmbedUnimplementedDataTranferServer()
 }
 
@@ -89,6 +259,36 @@ func (UnimplementedDataTranferServer) GetData(grpc.BidiStreamingServer[DataReque
 func (UnimplementedDataTranferServer) FetchData(grpc.BidiStreamingServer[DataRequest, DataResponse]) error {
 	return status.Errorf(codes.Unimplemented, "method FetchData not implemented")
 }
+func (UnimplementedDataTranferServer) GetServerInfo(context.Context, *ServerInfoRequest) (*ServerInfo, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetServerInfo not implemented")
+}
+func (UnimplementedDataTranferServer) Delete(context.Context, *DeleteRequest) (*DeleteResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Delete not implemented")
+}
+func (UnimplementedDataTranferServer) List(context.Context, *ListRequest) (*ListResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method List not implemented")
+}
+func (UnimplementedDataTranferServer) Stat(context.Context, *StatRequest) (*ObjectInfo, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Stat not implemented")
+}
+func (UnimplementedDataTranferServer) Subscribe(*SubscribeRequest, grpc.ServerStreamingServer[ObjectEvent]) error {
+	return status.Errorf(codes.Unimplemented, "method Subscribe not implemented")
+}
+func (UnimplementedDataTranferServer) Consume(*ConsumeRequest, grpc.ServerStreamingServer[Delivery]) error {
+	return status.Errorf(codes.Unimplemented, "method Consume not implemented")
+}
+func (UnimplementedDataTranferServer) Ack(context.Context, *AckRequest) (*AckResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Ack not implemented")
+}
+func (UnimplementedDataTranferServer) Nack(context.Context, *NackRequest) (*NackResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Nack not implemented")
+}
+func (UnimplementedDataTranferServer) ListDeadLetters(context.Context, *ListDeadLettersRequest) (*ListDeadLettersResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListDeadLetters not implemented")
+}
+func (UnimplementedDataTranferServer) RetryDeadLetters(context.Context, *RetryDeadLettersRequest) (*RetryDeadLettersResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RetryDeadLetters not implemented")
+}
 func (UnimplementedDataTranferServer) mustEmbedUnimplementedDataTranferServer() {}
 func (UnimplementedDataTranferServer) testEmbeddedByValue()                     {}
 
@@ -124,13 +324,212 @@ func _DataTranfer_FetchData_Handler(srv interface{}, stream grpc.ServerStream) e
 // This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
 type DataTranfer_FetchDataServer = grpc.BidiStreamingServer[DataRequest, DataResponse]
 
+func _DataTranfer_GetServerInfo_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ServerInfoRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DataTranferServer).GetServerInfo(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: DataTranfer_GetServerInfo_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DataTranferServer).GetServerInfo(ctx, req.(*ServerInfoRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DataTranfer_Delete_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DataTranferServer).Delete(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: DataTranfer_Delete_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DataTranferServer).Delete(ctx, req.(*DeleteRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DataTranfer_List_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DataTranferServer).List(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: DataTranfer_List_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DataTranferServer).List(ctx, req.(*ListRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DataTranfer_Stat_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StatRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DataTranferServer).Stat(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: DataTranfer_Stat_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DataTranferServer).Stat(ctx, req.(*StatRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DataTranfer_Subscribe_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(SubscribeRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(DataTranferServer).Subscribe(m, &grpc.GenericServerStream[SubscribeRequest, ObjectEvent]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type DataTranfer_SubscribeServer = grpc.ServerStreamingServer[ObjectEvent]
+
+func _DataTranfer_Consume_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ConsumeRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(DataTranferServer).Consume(m, &grpc.GenericServerStream[ConsumeRequest, Delivery]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type DataTranfer_ConsumeServer = grpc.ServerStreamingServer[Delivery]
+
+func _DataTranfer_Ack_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AckRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DataTranferServer).Ack(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: DataTranfer_Ack_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DataTranferServer).Ack(ctx, req.(*AckRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DataTranfer_Nack_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(NackRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DataTranferServer).Nack(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: DataTranfer_Nack_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DataTranferServer).Nack(ctx, req.(*NackRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DataTranfer_ListDeadLetters_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListDeadLettersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DataTranferServer).ListDeadLetters(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: DataTranfer_ListDeadLetters_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DataTranferServer).ListDeadLetters(ctx, req.(*ListDeadLettersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DataTranfer_RetryDeadLetters_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RetryDeadLettersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DataTranferServer).RetryDeadLetters(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: DataTranfer_RetryDeadLetters_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DataTranferServer).RetryDeadLetters(ctx, req.(*RetryDeadLettersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // DataTranfer_ServiceDesc is the grpc.ServiceDesc for DataTranfer service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
 var DataTranfer_ServiceDesc = grpc.ServiceDesc{
 	ServiceName: "DataTranfer",
 	HandlerType: (*DataTranferServer)(nil),
-	Methods:     []grpc.MethodDesc{},
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetServerInfo",
+			Handler:    _DataTranfer_GetServerInfo_Handler,
+		},
+		{
+			MethodName: "Delete",
+			Handler:    _DataTranfer_Delete_Handler,
+		},
+		{
+			MethodName: "List",
+			Handler:    _DataTranfer_List_Handler,
+		},
+		{
+			MethodName: "Stat",
+			Handler:    _DataTranfer_Stat_Handler,
+		},
+		{
+			MethodName: "Ack",
+			Handler:    _DataTranfer_Ack_Handler,
+		},
+		{
+			MethodName: "Nack",
+			Handler:    _DataTranfer_Nack_Handler,
+		},
+		{
+			MethodName: "ListDeadLetters",
+			Handler:    _DataTranfer_ListDeadLetters_Handler,
+		},
+		{
+			MethodName: "RetryDeadLetters",
+			Handler:    _DataTranfer_RetryDeadLetters_Handler,
+		},
+	},
 	Streams: []grpc.StreamDesc{
 		{
 			StreamName:    "GetData",
@@ -144,6 +543,16 @@ var DataTranfer_ServiceDesc = grpc.ServiceDesc{
 			ServerStreams: true,
 			ClientStreams: true,
 		},
+		{
+			StreamName:    "Subscribe",
+			Handler:       _DataTranfer_Subscribe_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "Consume",
+			Handler:       _DataTranfer_Consume_Handler,
+			ServerStreams: true,
+		},
 	},
 	Metadata: "data_transfer.proto",
 }